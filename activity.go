@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Written per board by report --members, and once into the output directory for all boards together
+const (
+	MemberActivityFile = "member-activity.md"
+	MemberActivityCSV  = "member-activity.csv"
+	MemberWorkloadCSV  = "member-workload.csv"
+)
+
+// Time windows member activity can be grouped by (--window)
+var knownWindows = []string{"week", "month", "quarter"}
+
+/*
+memberCounts is what one member did in one time window
+*/
+type memberCounts struct {
+	Created    int // cards created (or copied, or made from a checklist item)
+	Moved      int // cards moved to another list, or onto the board
+	Completed  int // cards moved into a done list
+	Comments   int
+	CheckItems int // checklist items ticked
+}
+
+func (c *memberCounts) add(o memberCounts) {
+	c.Created += o.Created
+	c.Moved += o.Moved
+	c.Completed += o.Completed
+	c.Comments += o.Comments
+	c.CheckItems += o.CheckItems
+}
+
+func (c memberCounts) row() []string {
+	return []string{strconv.Itoa(c.Created), strconv.Itoa(c.Moved), strconv.Itoa(c.Completed), strconv.Itoa(c.Comments), strconv.Itoa(c.CheckItems)}
+}
+
+var memberCountsHeader = []string{"cards_created", "cards_moved", "cards_completed", "comments", "checklist_items_completed"}
+
+/*
+memberLoad is a member's open cards right now
+*/
+type memberLoad struct {
+	Open    int
+	Overdue int
+}
+
+/*
+memberNamer
+
+	How members are shown in the reports, as "Full Name (username)" or, anonymized, as
+	Member 1, Member 2, ...  One namer is used for every board in a run, so the same
+	person has the same pseudonym on each board.
+*/
+type memberNamer struct {
+	anonymize bool
+	names     map[string]string // member ID -> shown name
+	next      int
+}
+
+func newMemberNamer(anonymize bool) *memberNamer {
+	return &memberNamer{anonymize: anonymize, names: make(map[string]string)}
+}
+
+/*
+learn takes the names from a board's members, pseudonyms are given out in member ID order
+*/
+func (n *memberNamer) learn(members []BoardMember) {
+	members = slices.Clone(members)
+	slices.SortFunc(members, func(a, b BoardMember) int { return cmp.Compare(a.ID, b.ID) })
+	for _, m := range members {
+		n.name(m.ID, m.Username, m.FullName)
+	}
+}
+
+/*
+name is how a member is shown, username and fullName are used the first time the ID is seen
+*/
+func (n *memberNamer) name(id string, username string, fullName string) string {
+	if id == "" {
+		return "(unknown)"
+	}
+	if name, ok := n.names[id]; ok {
+		return name
+	}
+
+	var name string
+	switch {
+	case n.anonymize:
+		n.next++
+		name = "Member " + strconv.Itoa(n.next)
+	case username != "" && fullName != "":
+		name = fullName + " (" + username + ")"
+	case username != "":
+		name = username
+	default:
+		name = id
+	}
+	n.names[id] = name
+
+	return name
+}
+
+/*
+ActivityReport
+
+	Per member activity on one board, by time window, plus what is assigned to them now.
+	Cards moved into a done list are counted as completed by whoever moved them, done lists are the flow report's.
+*/
+type ActivityReport struct {
+	flow     *FlowReport
+	opts     reportOptions
+	activity map[string]map[string]*memberCounts // window -> member -> counts
+	load     map[string]*memberLoad              // member -> open cards now
+}
+
+/*
+newActivityReport counts every member's actions (within --since/--until) and open card load
+*/
+func newActivityReport(r *FlowReport, names *memberNamer, opts reportOptions) *ActivityReport {
+
+	a := &ActivityReport{
+		flow:     r,
+		opts:     opts,
+		activity: make(map[string]map[string]*memberCounts),
+		load:     make(map[string]*memberLoad),
+	}
+	h := r.History
+	names.learn(h.Members)
+
+	for _, act := range h.Actions {
+		if act.Date.Before(opts.since) || (!opts.until.IsZero() && !act.Date.Before(opts.until)) {
+			continue
+		}
+
+		var c memberCounts
+		d := act.Data
+		switch {
+		case d == nil:
+			continue
+		case act.Type == "moveCardToBoard":
+			c.Moved = 1
+		case isArrival(act):
+			c.Created = 1
+		case d.ListAfter != nil:
+			c.Moved = 1
+			if r.done[d.ListAfter.ID] && (d.ListBefore == nil || !r.done[d.ListBefore.ID]) {
+				c.Completed = 1
+			}
+		case act.Type == "commentCard":
+			c.Comments = 1
+		case act.Type == "updateCheckItemStateOnCard" && d.CheckItem != nil && d.CheckItem.State == "complete":
+			c.CheckItems = 1
+		default:
+			continue
+		}
+
+		username, fullName := "", ""
+		if act.MemberCreator != nil {
+			username, fullName = act.MemberCreator.Username, act.MemberCreator.FullName
+		}
+		member := names.name(act.IDMemberCreator, username, fullName)
+		window := activityWindow(act.Date, opts.window)
+		if a.activity[window] == nil {
+			a.activity[window] = make(map[string]*memberCounts)
+		}
+		if a.activity[window][member] == nil {
+			a.activity[window][member] = &memberCounts{}
+		}
+		a.activity[window][member].add(c)
+	}
+
+	// Open cards not yet in a done list, overdue when the due date has gone without it being marked complete
+	for _, card := range h.Cards {
+		if card.Closed || r.done[card.IDList] {
+			continue
+		}
+		overdue := card.Due != nil && card.Due.Before(h.AsOf) && !card.DueComplete
+		for _, id := range card.IDMembers {
+			member := names.name(id, "", "")
+			if a.load[member] == nil {
+				a.load[member] = &memberLoad{}
+			}
+			a.load[member].Open++
+			if overdue {
+				a.load[member].Overdue++
+			}
+		}
+	}
+
+	return a
+}
+
+/*
+activityWindow is the week (its Monday), month or quarter a time falls in, in UTC
+*/
+func activityWindow(t time.Time, window string) string {
+	t = t.UTC()
+	switch window {
+	case "week":
+		return weekStart(t).Format("2006-01-02")
+	case "quarter":
+		return fmt.Sprintf("%d-Q%d", t.Year(), (int(t.Month())+2)/3)
+	default:
+		return t.Format("2006-01")
+	}
+}
+
+func (a *ActivityReport) activityTable() ([]string, [][]string) {
+	header := append([]string{a.opts.window, "member"}, memberCountsHeader...)
+	var rows [][]string
+	for _, window := range slices.Sorted(maps.Keys(a.activity)) {
+		for _, member := range slices.Sorted(maps.Keys(a.activity[window])) {
+			rows = append(rows, append([]string{window, csvText(member)}, a.activity[window][member].row()...))
+		}
+	}
+	return header, rows
+}
+
+func (a *ActivityReport) workloadTable() ([]string, [][]string) {
+	header := []string{"member", "open_cards", "overdue_cards"}
+	var rows [][]string
+	for _, member := range slices.Sorted(maps.Keys(a.load)) {
+		l := a.load[member]
+		rows = append(rows, []string{csvText(member), strconv.Itoa(l.Open), strconv.Itoa(l.Overdue)})
+	}
+	return header, rows
+}
+
+/*
+totals is each member's activity summed over every window
+*/
+func (a *ActivityReport) totals() map[string]*memberCounts {
+	out := make(map[string]*memberCounts)
+	for _, members := range a.activity {
+		for member, c := range members {
+			if out[member] == nil {
+				out[member] = &memberCounts{}
+			}
+			out[member].add(*c)
+		}
+	}
+	return out
+}
+
+/*
+write puts the board's member CSV files and Markdown report in dir
+*/
+func (a *ActivityReport) write(dir string) error {
+
+	h := a.flow.History
+	activityHeader, activityRows := a.activityTable()
+	workloadHeader, workloadRows := a.workloadTable()
+	if err := writeCSVFile(filepath.Join(dir, MemberActivityCSV), activityHeader, activityRows); err != nil {
+		return err
+	}
+	if err := writeCSVFile(filepath.Join(dir, MemberWorkloadCSV), workloadHeader, workloadRows); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Member activity: %s\n\n", h.Name)
+	fmt.Fprintf(&buf, "- **Board:** %s (%s)\n", h.Name, h.ID)
+	fmt.Fprintf(&buf, "- **As of:** %s\n", h.AsOf.UTC().Format("2006-01-02 15:04 MST"))
+	fmt.Fprintf(&buf, "- **Period:** %s\n", a.opts.period())
+	fmt.Fprintf(&buf, "- **Done in:** %s\n\n", a.flow.doneNames())
+
+	fmt.Fprintf(&buf, "## Assigned open cards\n\n")
+	writeMarkdownOrNone(&buf, workloadHeader, workloadRows)
+	fmt.Fprintf(&buf, "\n## Activity by %s\n\n", a.opts.window)
+	writeMarkdownOrNone(&buf, activityHeader, activityRows)
+
+	return os.WriteFile(filepath.Join(dir, MemberActivityFile), buf.Bytes(), SecureFileMode)
+}
+
+/*
+writeActivitySummary
+
+	Write the member report for every board together into outDir, the CSV has every
+	board's rows with a board column, the Markdown has each member's totals per board.
+*/
+func writeActivitySummary(reports []*ActivityReport, outDir string) error {
+
+	var (
+		activityRows, workloadRows [][]string
+		totalRows                  [][]string
+		activityHeader             []string
+		opts                       = reports[0].opts
+	)
+	for _, a := range reports {
+		board := csvText(a.flow.History.Name)
+		var rows [][]string
+		activityHeader, rows = a.activityTable()
+		for _, row := range rows {
+			activityRows = append(activityRows, append([]string{board}, row...))
+		}
+
+		totals := a.totals()
+		members := slices.Sorted(maps.Keys(totals))
+		for member := range a.load {
+			if totals[member] == nil {
+				members = append(members, member)
+			}
+		}
+		for _, member := range members {
+			c, l := totals[member], a.load[member]
+			if c == nil {
+				c = &memberCounts{}
+			}
+			if l == nil {
+				l = &memberLoad{}
+			}
+			totalRows = append(totalRows, append(append([]string{member, board}, c.row()...), strconv.Itoa(l.Open), strconv.Itoa(l.Overdue)))
+		}
+		_, rows = a.workloadTable()
+		for _, row := range rows {
+			workloadRows = append(workloadRows, append([]string{board}, row...))
+		}
+	}
+	slices.SortStableFunc(totalRows, func(a, b []string) int { return cmp.Compare(a[0], b[0]) })
+
+	if err := writeCSVFile(filepath.Join(outDir, MemberActivityCSV), append([]string{"board"}, activityHeader...), activityRows); err != nil {
+		return err
+	}
+	if err := writeCSVFile(filepath.Join(outDir, MemberWorkloadCSV), []string{"board", "member", "open_cards", "overdue_cards"}, workloadRows); err != nil {
+		return err
+	}
+
+	var boards []string
+	for _, a := range reports {
+		boards = append(boards, a.flow.History.Name)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Member activity across boards\n\n")
+	fmt.Fprintf(&buf, "- **Boards:** %s\n", strings.Join(boards, ", "))
+	fmt.Fprintf(&buf, "- **Period:** %s\n\n", opts.period())
+	fmt.Fprintf(&buf, "Each board's report is in its own directory, activity by %s for every board is in `%s`.\n\n", opts.window, MemberActivityCSV)
+	header := append(append([]string{"member", "board"}, memberCountsHeader...), "open_cards", "overdue_cards")
+	writeMarkdownOrNone(&buf, header, totalRows)
+
+	return os.WriteFile(filepath.Join(outDir, MemberActivityFile), buf.Bytes(), SecureFileMode)
+}
+
+// period is the --since/--until range as text
+func (o reportOptions) period() string {
+	since, until := "the start", "now"
+	if !o.since.IsZero() {
+		since = o.since.Format("2006-01-02")
+	}
+	if !o.until.IsZero() {
+		until = o.until.AddDate(0, 0, -1).Format("2006-01-02")
+	}
+	return since + " to " + until
+}
+
+// writeMarkdownOrNone writes a Markdown table, or None. when there are no rows
+func writeMarkdownOrNone(buf *bytes.Buffer, header []string, rows [][]string) {
+	if len(rows) == 0 {
+		buf.WriteString("None.\n")
+		return
+	}
+	markdownTable(buf, header, rows)
+}
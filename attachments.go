@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/adlio/trello"
+)
+
+// Per card attachment metadata, written into the card's attachments directory
+const (
+	AttachmentsMDFile   = "attachments.md"
+	AttachmentsJSONFile = "attachments.json"
+	PreviewsDir         = "previews"
+)
+
+/*
+AttachmentMeta
+
+	What Trello knows about one attachment, kept next to the downloaded files in attachments.json.
+	File and Preview are relative to the card's attachments directory.
+*/
+type AttachmentMeta struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Date       string `json:"date"`
+	UploaderID string `json:"uploader_id"`
+	Uploader   string `json:"uploader,omitempty"` // username, when they are a member of the board
+	Bytes      int    `json:"bytes"`
+	MimeType   string `json:"mime_type"`
+	IsUpload   bool   `json:"is_upload"`
+	Cover      bool   `json:"cover"`
+	URL        string `json:"url"`
+	File       string `json:"file,omitempty"`
+	Preview    string `json:"preview,omitempty"`
+	Skipped    string `json:"skipped,omitempty"` // why an upload wasn't downloaded, see the Skip* reasons
+}
+
+// Why an upload was not downloaded, recorded in attachments.md/json and the run report
+const (
+	SkipTooLarge   = "larger than --max-attachment-mb"
+	SkipBudget     = "board attachment budget used up"
+	SkipDenied     = "type denied"
+	SkipNotAllowed = "type not in allow list"
+)
+
+// Attachment bytes downloaded (or reserved) per board ID this run, for --board-attachment-mb
+var boardAttachmentBytes sync.Map
+
+/*
+attachmentReservation
+
+	An upload that passed the size checks, holding its share of the board budget while it downloads.
+	limit is the most bytes it may stream (0 for no limit), limitReason the skip reason if it gets there.
+*/
+type attachmentReservation struct {
+	used        *atomic.Int64
+	reserved    int64
+	limit       int64
+	limitReason string
+}
+
+/*
+fixAttachmentBytes
+
+	The Trello client reads attachment sizes from the wrong JSON key, so Bytes is always 0.
+	Fill them in from the raw card JSON instead.
+*/
+func fixAttachmentBytes(raw []byte, attachments []*trello.Attachment) {
+	var sizes struct {
+		Attachments []struct {
+			ID    string `json:"id"`
+			Bytes int    `json:"bytes"`
+		} `json:"attachments"`
+	}
+	if err := json.Unmarshal(raw, &sizes); err != nil {
+		return
+	}
+
+	byID := make(map[string]int, len(sizes.Attachments))
+	for _, a := range sizes.Attachments {
+		byID[a.ID] = a.Bytes
+	}
+	for _, a := range attachments {
+		if a != nil {
+			a.Bytes = byID[a.ID]
+		}
+	}
+}
+
+/*
+newAttachmentMeta fills in the metadata for an attachment, before anything is downloaded
+*/
+func newAttachmentMeta(card *trello.Card, a *trello.Attachment) AttachmentMeta {
+	return AttachmentMeta{
+		ID:         a.ID,
+		Name:       a.Name,
+		Date:       a.Date,
+		UploaderID: a.IDMember,
+		Uploader:   memberUsername(a.IDMember),
+		Bytes:      a.Bytes,
+		MimeType:   a.MimeType,
+		IsUpload:   a.IsUpload,
+		Cover:      card.Cover != nil && card.Cover.IDAttachment == a.ID,
+		URL:        a.URL,
+	}
+}
+
+/*
+startAttachmentBudget starts a board's --board-attachment-mb count from nothing, called as each board is dumped
+*/
+func startAttachmentBudget(boardID string) {
+	boardAttachmentBytes.Store(boardID, &atomic.Int64{})
+}
+
+/*
+attachmentTypeSkip checks an attachment against --attachment-deny and --attachment-allow, "" if it can be downloaded
+*/
+func attachmentTypeSkip(a *trello.Attachment, args ARGS) string {
+	mimeType := strings.ToLower(a.MimeType)
+	ext := strings.ToLower(filepath.Ext(a.Name))
+
+	if matchesAttachmentType(args.AttachmentDeny, mimeType, ext) {
+		return SkipDenied
+	}
+	if len(args.AttachmentAllow) > 0 && !matchesAttachmentType(args.AttachmentAllow, mimeType, ext) {
+		return SkipNotAllowed
+	}
+	return ""
+}
+
+/*
+matchesAttachmentType is true if any pattern matches, patterns with a / are MIME types (image/* works), the rest extensions
+*/
+func matchesAttachmentType(patterns []string, mimeType string, ext string) bool {
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSpace(p))
+		switch {
+		case p == "":
+			continue
+		case strings.Contains(p, "/"):
+			if ok, _ := path.Match(p, mimeType); ok && mimeType != "" {
+				return true
+			}
+		case ext != "" && "."+strings.TrimPrefix(p, ".") == ext:
+			return true
+		}
+	}
+	return false
+}
+
+/*
+reserveAttachment
+
+	Check an upload's reported size against --max-attachment-mb and what is left of the
+	board's --board-attachment-mb, and hold that much of the budget for it.
+	Returns the skip reason if it shouldn't be downloaded at all.
+*/
+func reserveAttachment(boardID string, size int64, args ARGS) (*attachmentReservation, string) {
+
+	maxFile := int64(args.MaxAttachmentMB) * 1024 * 1024
+	if maxFile > 0 && size > maxFile {
+		return nil, SkipTooLarge
+	}
+	r := &attachmentReservation{limit: maxFile, limitReason: SkipTooLarge}
+
+	budget := int64(args.BoardAttachMB) * 1024 * 1024
+	if budget <= 0 {
+		return r, ""
+	}
+	v, _ := boardAttachmentBytes.LoadOrStore(boardID, &atomic.Int64{})
+	r.used = v.(*atomic.Int64)
+
+	for {
+		cur := r.used.Load()
+		if cur >= budget || cur+size > budget {
+			return nil, SkipBudget
+		}
+		if r.used.CompareAndSwap(cur, cur+size) {
+			r.reserved = size
+			// Trello's size can be missing or wrong, the stream is also held to what was left
+			if left := budget - cur; r.limit == 0 || left < r.limit {
+				r.limit, r.limitReason = left, SkipBudget
+			}
+			return r, ""
+		}
+	}
+}
+
+/*
+done swaps what was held of the budget for what was actually downloaded (0 if it failed)
+*/
+func (r *attachmentReservation) done(n int64) {
+	if r.used != nil {
+		r.used.Add(n - r.reserved)
+	}
+}
+
+/*
+skipAttachment logs and reports an upload that isn't being downloaded
+*/
+func skipAttachment(card *trello.Card, a *trello.Attachment, reason string, config Config) {
+	logger(fmt.Sprintf("Skipping attachment %s (%s) on card %s: %s", a.Name, formatBytes(a.Bytes), card.Name, reason), "warn", true, true, config, cardLogFields(card, "attachments")...)
+	reportSkippedAttachment(card.IDBoard, SkippedAttachment{CardID: card.ID, Card: card.Name, ID: a.ID, Name: a.Name, Bytes: a.Bytes, MimeType: a.MimeType, Reason: reason})
+}
+
+/*
+downloadLargestPreview saves the biggest preview Trello made of an attachment into attachments/previews,
+returning its path relative to the attachments directory, or "" if there are no previews
+*/
+func downloadLargestPreview(card *trello.Card, a *trello.Attachment, attachmentsDir string, config Config) string {
+
+	var best *trello.AttachmentPreview
+	for i := range a.Previews {
+		p := &a.Previews[i]
+		if p.URL != "" && (best == nil || p.Width*p.Height > best.Width*best.Height) {
+			best = p
+		}
+	}
+	if best == nil {
+		return ""
+	}
+
+	ext := ".png"
+	if u, err := url.Parse(best.URL); err == nil && path.Ext(u.Path) != "" {
+		ext = path.Ext(u.Path)
+	}
+	fileName := fmt.Sprintf("%s (preview %dx%d)%s", SanitizePathName(a.Name), best.Width, best.Height, ext)
+	localPath := filepath.Join(attachmentsDir, PreviewsDir, fileName)
+	dirCreate(filepath.Dir(localPath))
+
+	if _, err := download(downloadRequest{URL: best.URL, Path: localPath, Auth: isTrelloHosted(best.URL), Size: int64(best.Bytes)}); err != nil {
+		logger("Error downloading preview of attachment "+a.Name+": "+err.Error(), "err", true, false, config, cardLogFields(card, "previews")...)
+		return ""
+	}
+
+	return PreviewsDir + "/" + fileName
+}
+
+/*
+isTrelloHosted reports if a URL is on Trello itself, only those get the API key and token sent with them
+*/
+func isTrelloHosted(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	return host == "trello.com" || strings.HasSuffix(host, ".trello.com")
+}
+
+/*
+writeAttachmentMeta writes attachments.md and attachments.json into the card's attachments directory
+*/
+func writeAttachmentMeta(metas []AttachmentMeta, attachmentsDir string) error {
+
+	data, err := json.MarshalIndent(metas, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(attachmentsDir, AttachmentsJSONFile), data, SecureFileMode); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("| Name | ID | Date | Uploader | Size | MIME Type | Cover | Link | Preview |\n")
+	buf.WriteString("| --- | --- | --- | --- | --- | --- | --- | --- | --- |\n")
+	for _, m := range metas {
+		uploader := m.UploaderID
+		if m.Uploader != "" {
+			uploader = "@" + m.Uploader
+		}
+		cover := ""
+		if m.Cover {
+			cover = "yes"
+		}
+		link := fmt.Sprintf("[url](%s)", m.URL)
+		if m.File != "" {
+			link = fmt.Sprintf("[file](%s)", strings.ReplaceAll(m.File, " ", "%20"))
+		}
+		if m.Skipped != "" {
+			link = fmt.Sprintf("[url](%s) (skipped: %s)", m.URL, m.Skipped)
+		}
+		preview := ""
+		if m.Preview != "" {
+			preview = fmt.Sprintf("![preview](%s)", strings.ReplaceAll(m.Preview, " ", "%20"))
+		}
+		fmt.Fprintf(&buf, "| %s | %s | %s | %s | %s | %s | %s | %s | %s |\n",
+			mdCell(m.Name), m.ID, m.Date, mdCell(uploader), formatBytes(m.Bytes), mdCell(m.MimeType), cover, link, preview)
+	}
+
+	return os.WriteFile(filepath.Join(attachmentsDir, AttachmentsMDFile), buf.Bytes(), SecureFileMode)
+}
+
+/*
+formatBytes is a size for people to read, blank when Trello didn't say (links)
+*/
+func formatBytes(n int) string {
+	switch {
+	case n <= 0:
+		return ""
+	case n < 1024:
+		return strconv.Itoa(n) + " B"
+	case n < 1024*1024:
+		return fmt.Sprintf("%.1f KiB", float64(n)/1024)
+	case n < 1024*1024*1024:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1024*1024))
+	default:
+		return fmt.Sprintf("%.1f GiB", float64(n)/(1024*1024*1024))
+	}
+}
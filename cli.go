@@ -0,0 +1,523 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// Raw CLI flag values, merged with the config file in buildArgs
+type cliFlags struct {
+	boards      []string
+	archived    bool
+	split       bool
+	ordered     bool
+	onDeleted   string
+	previews    bool
+	maxAttachMB int
+	boardMB     int
+	allow       []string
+	deny        []string
+	formats     []string
+	csvChecks   bool
+	csvComments bool
+	migrateMap  string
+	doneLists   []string
+	startLists  []string
+	staleDays   int
+	qq          bool
+	loud        bool
+	storage     string
+	label       string
+	logFile     string
+	logLevel    string
+	logFormat   string
+	logMaxSize  int
+	logMaxAge   int
+	logBackups  int
+	logCompress bool
+	syslog      bool
+	configFile  string
+	report      string
+	reportMD    string
+	metricsFile string
+	lockFile    string
+}
+
+var flags cliFlags
+
+/*
+newRootCmd
+
+	Build the trellgo command tree
+*/
+func newRootCmd() *cobra.Command {
+
+	root := &cobra.Command{
+		Use:   "trellgo",
+		Short: "Dump Trello boards into a file system tree of Markdown files and attachments",
+		Long: "trellgo v" + version + " by srv1054 (github.com/srv1054/trellgo)\n\n" +
+			"Console output is minimal by default, with high level messages.  Use --loud to enable more verbose output.  Errors always print to console.",
+		Version:       version,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	root.SetVersionTemplate("trellgo v{{.Version}}\n")
+
+	pf := root.PersistentFlags()
+	pf.StringVar(&flags.configFile, "config", "", "YAML config file with defaults and per board settings. CLI flags override file values")
+	pf.BoolVar(&flags.loud, "loud", false, "Enable more verbose output")
+	pf.BoolVar(&flags.qq, "qq", false, "Suppress ALL console output.  Super Quiet mode.  Does not effect logging, just console.  Does not apply to labels or count")
+	pf.StringVar(&flags.logFile, "logs", "", "Specifies a log file to send all output. Off by default, if enabled, its not effected by --loud or --qq")
+	pf.StringVar(&flags.logLevel, "log-level", "info", "Lowest level written to the log file and syslog: debug, info, warn or error")
+	pf.StringVar(&flags.logFormat, "log-format", "logfmt", "Log file format: logfmt or json")
+	pf.IntVar(&flags.logMaxSize, "log-max-size", 100, "Rotate the log file when it reaches this many megabytes")
+	pf.IntVar(&flags.logMaxAge, "log-max-age", 0, "Delete rotated log files older than this many days (0 keeps them)")
+	pf.IntVar(&flags.logBackups, "log-max-backups", 0, "Number of rotated log files to keep (0 keeps them all)")
+	pf.BoolVar(&flags.logCompress, "log-compress", false, "gzip rotated log files")
+	pf.BoolVar(&flags.syslog, "syslog", false, "Also send log entries to the local syslog socket (facility local1, tag trellgo)")
+
+	root.AddCommand(
+		newDumpCmd(),
+		newLabelsCmd(),
+		newCountCmd(),
+		newVerifyCmd(),
+		newRestoreCmd(),
+		newListBoardsCmd(),
+		newConfigCmd(),
+		newDaemonCmd(),
+		newWebhookCmd(),
+		newSearchCmd(),
+		newReportCmd(),
+	)
+
+	return root
+}
+
+/*
+addBoardFlag adds -b to commands that work on one or more boards
+*/
+func addBoardFlag(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVarP(&flags.boards, "board", "b", nil, "Trello board ID to use (repeatable), or PIPE (|) IDs in one per line")
+}
+
+/*
+addDumpFlags adds the flags that control which cards are dumped and where to
+*/
+func addDumpFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&flags.archived, "archived", "a", false, "Include archived cards in dump")
+	cmd.Flags().StringVarP(&flags.label, "label", "l", "", "Only include cards with this label NAME (Does not work with -a. Requires NAME of label \"in quotes\", not ID)")
+	cmd.Flags().StringVarP(&flags.storage, "storage", "s", "", "Root Level path to store board information (REQUIRED unless set in --config)")
+	cmd.Flags().BoolVar(&flags.split, "split", false, "Separate archived cards into their own directory (instead of mixed in and labeled with -ARCHIVED)")
+	cmd.Flags().StringVar(&flags.onDeleted, "on-deleted", OnDeletedMove, "What to do with cards, lists and boards deleted in Trello since an earlier dump: move (under DELETED/) or tombstone (leave in place), both write a TOMBSTONE.md")
+	cmd.Flags().BoolVar(&flags.ordered, "ordered", false, "Prefix list and card directories with their zero padded Trello position so they sort in board order, and write CardOrder.md per list")
+	cmd.Flags().BoolVar(&flags.previews, "previews", false, "Also download the largest preview image Trello has of each attachment into attachments/previews")
+	cmd.Flags().IntVar(&flags.maxAttachMB, "max-attachment-mb", 0, "Skip attachments bigger than this many megabytes, by Trello's reported size and while downloading (0 is no limit)")
+	cmd.Flags().IntVar(&flags.boardMB, "board-attachment-mb", 0, "Stop downloading a board's attachments once this many megabytes have been downloaded this run (0 is no limit)")
+	cmd.Flags().StringSliceVar(&flags.allow, "attachment-allow", nil, "Only download attachments of these MIME types (image/*, application/pdf) or extensions (.pdf), repeatable")
+	cmd.Flags().StringSliceVar(&flags.deny, "attachment-deny", nil, "Never download attachments of these MIME types (video/*) or extensions (.mp4), repeatable.  Wins over --attachment-allow")
+	cmd.Flags().StringSliceVar(&flags.formats, "format", nil, "Output formats to write, repeatable: markdown (the file tree, always written) and sqlite ("+SQLiteFile+" in the storage path, upserted every run) and csv (cards.csv per board) and ics ("+CalendarFile+" per board and "+CombinedCalendarFile+" in the storage path) and jira, github, gitlab (issue import files per board) and wekan ("+WekanFile+" per board)")
+	cmd.Flags().BoolVar(&flags.csvChecks, "csv-checklists", false, "With --format csv, also write checklist_items.csv per board")
+	cmd.Flags().BoolVar(&flags.csvComments, "csv-comments", false, "With --format csv, also write comments.csv per board")
+	cmd.Flags().StringVar(&flags.migrateMap, "migration-map", "", "With --format jira, github or gitlab, YAML file mapping lists to statuses and members to users")
+}
+
+func newDumpCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dump [boardID...]",
+		Short: "Dump boards to the file system",
+		Example: "  trellgo dump -b c52d11s -s '/path/to/here'\n" +
+			"  trellgo dump -b c52d11s -a --split -s '/path/to/here'\n" +
+			"  trellgo dump -b c52d11s -l \"Completed Items\" -s '/path/to/here' --logs '/path/file.log'\n" +
+			"  cat boards.txt | trellgo dump -a --qq -s '/path/to/here'\n" +
+			"  trellgo dump --config '/path/trellgo.yaml'",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, boards, err := buildArgs(cmd, args)
+			if err != nil {
+				return err
+			}
+			if !storageForAll(a, boards) {
+				return fmt.Errorf("no storage path provided for every board, use -s or set storage in --config")
+			}
+			// Searching on a specific Label will not allow search of archives, need to inform user
+			if !labelArchivedOK(a, boards) {
+				return fmt.Errorf("cannot use -l with -a, use -l without -a to filter by label name")
+			}
+			// Same lock as the daemon, so a cron dump and a group run never write the same output at once
+			lockFile := flags.lockFile
+			if !a.cliSet["lock-file"] && a.fileConfig != nil && a.fileConfig.Daemon.LockFile != "" {
+				lockFile = a.fileConfig.Daemon.LockFile
+			}
+			release, err := acquireLock(lockFile)
+			if err != nil {
+				return err
+			}
+			defer release()
+
+			startRun(a)
+			err = runDump(boards)
+			if a.fileConfig != nil {
+				sendNotifications(a.fileConfig.Notify, runSummary("", dumpOutput(a)))
+			}
+			return err
+		},
+	}
+	addBoardFlag(cmd)
+	addDumpFlags(cmd)
+	cmd.Flags().StringVar(&flags.report, "report", "", "Write a JSON run report to this file")
+	cmd.Flags().StringVar(&flags.reportMD, "report-md", "", "Write a Markdown run report to this file")
+	cmd.Flags().StringVar(&flags.metricsFile, "metrics-textfile", "", "Write Prometheus metrics to this file for the node_exporter textfile collector (name it *.prom)")
+	cmd.Flags().StringVar(&flags.lockFile, "lock-file", DefaultLockFile, "Lock file shared with the daemon that stops two runs overlapping (overrides daemon.lock_file)")
+	return cmd
+}
+
+func newLabelsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "labels [boardID...]",
+		Short:   "List a board's labels and their IDs",
+		Example: "  trellgo labels -b t532aad",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, boards, err := buildArgs(cmd, args)
+			if err != nil {
+				return err
+			}
+			startRun(a)
+			runLabels(boards)
+			return nil
+		},
+	}
+	addBoardFlag(cmd)
+	return cmd
+}
+
+func newCountCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "count [boardID...]",
+		Short:   "Show total, open, archived and visible card counts for boards",
+		Example: "  trellgo count -b 5f3g1a2",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, boards, err := buildArgs(cmd, args)
+			if err != nil {
+				return err
+			}
+			startRun(a)
+			runCount(boards)
+			return nil
+		},
+	}
+	addBoardFlag(cmd)
+	return cmd
+}
+
+func newVerifyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "verify [boardID...]",
+		Short:   "Compare an existing dump on disk against the cards currently in Trello",
+		Example: "  trellgo verify -b c52d11s -a -s '/path/to/here'",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, boards, err := buildArgs(cmd, args)
+			if err != nil {
+				return err
+			}
+			if !storageForAll(a, boards) {
+				return fmt.Errorf("no storage path provided for every board, use -s or set storage in --config")
+			}
+			startRun(a)
+			if missing := runVerify(boards); missing > 0 {
+				// Partial failure exit code, the dump is there but incomplete
+				logger(fmt.Sprintf("%d card(s) missing from the dump", missing), "warn", true, false, config)
+				errorWarnOnCompletion = true
+			}
+			return nil
+		},
+	}
+	addBoardFlag(cmd)
+	addDumpFlags(cmd)
+	return cmd
+}
+
+func newRestoreCmd() *cobra.Command {
+	var (
+		from   string
+		name   string
+		dryRun bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Create a new Trello board from a dumped board directory",
+		Long: "Create a new Trello board from a dumped board directory.\n" +
+			"Lists, cards, descriptions, labels, checklists and attachments are recreated.  History, comments and members can not be restored.",
+		Example: "  trellgo restore --from '/path/to/here/My Board' --name 'My Board (restored)' --dry-run",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, err := baseArgs(cmd)
+			if err != nil {
+				return err
+			}
+			startRun(a)
+			return runRestore(from, name, dryRun)
+		},
+	}
+	cmd.Flags().StringVar(&from, "from", "", "Board directory from a previous dump (REQUIRED)")
+	cmd.Flags().StringVar(&name, "name", "", "Name for the new board (default is the directory name)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only print what would be created")
+	_ = cmd.MarkFlagRequired("from")
+	return cmd
+}
+
+func newListBoardsCmd() *cobra.Command {
+	var (
+		closed  bool
+		idsOnly bool
+	)
+
+	cmd := &cobra.Command{
+		Use:     "list-boards",
+		Short:   "List the boards the API token can see, with their IDs",
+		Example: "  trellgo list-boards\n  trellgo list-boards --ids > my.board.list",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, err := baseArgs(cmd)
+			if err != nil {
+				return err
+			}
+			startRun(a)
+			return runListBoards(closed, idsOnly)
+		},
+	}
+	cmd.Flags().BoolVar(&closed, "closed", false, "Include closed boards")
+	cmd.Flags().BoolVar(&idsOnly, "ids", false, "Print only board IDs, one per line (for piping into dump)")
+	return cmd
+}
+
+func newConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Work with the --config file",
+	}
+
+	validate := &cobra.Command{
+		Use:     "validate",
+		Short:   "Check a config file for problems without running a dump",
+		Example: "  trellgo config validate --config '/path/trellgo.yaml'",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, err := baseArgs(cmd)
+			if err != nil {
+				return err
+			}
+			return runConfigValidate(a.fileConfig, flags.storage)
+		},
+	}
+	validate.Flags().StringVarP(&flags.storage, "storage", "s", "", "Storage path that will be given with -s at run time, if any")
+
+	cmd.AddCommand(validate)
+	return cmd
+}
+
+/*
+baseArgs
+
+	Build ARGS from the persistent flags and the config file defaults
+*/
+func baseArgs(cmd *cobra.Command) (ARGS, error) {
+
+	var a ARGS
+
+	// Note which flags were given so they can override the config file
+	a.cliSet = make(map[string]bool)
+	cmd.Flags().Visit(func(f *pflag.Flag) { a.cliSet[f.Name] = true })
+
+	// Load config file defaults first, CLI flags are layered on top
+	a.Formats = []string{"markdown"}
+	a.StaleDays = DefaultStaleDays
+	a.ConfigFile = flags.configFile
+	if a.ConfigFile != "" {
+		fc, err := loadConfigFile(a.ConfigFile)
+		if err != nil {
+			return a, err
+		}
+		a.fileConfig = fc
+		a = applyProfile(a, fc.Defaults)
+	}
+
+	a.SuperQuiet = flags.qq
+	a.LogFile = flags.logFile
+	a.LogLevel = flags.logLevel
+	a.LogFormat = flags.logFormat
+	a.LogMaxSize = flags.logMaxSize
+	a.LogMaxAge = flags.logMaxAge
+	a.LogMaxBackups = flags.logBackups
+	a.LogCompress = flags.logCompress
+	a.Syslog = flags.syslog
+	if _, err := parseLogLevel(a.LogLevel); err != nil {
+		return a, err
+	}
+	ListLoud = flags.loud
+
+	return a, nil
+}
+
+/*
+buildArgs
+
+	Build ARGS for board commands, and work out which boards to run against.
+	Boards come from arguments/-b, stdin pipe, or the config file, in that order.
+*/
+func buildArgs(cmd *cobra.Command, args []string) (ARGS, []string, error) {
+
+	a, err := baseArgs(cmd)
+	if err != nil {
+		return a, nil, err
+	}
+
+	if a.cliSet["archived"] {
+		a.Archived = flags.archived
+	}
+	if a.cliSet["label"] {
+		a.LabelID = flags.label
+	}
+	if a.cliSet["storage"] {
+		a.StoragePath = flags.storage
+	}
+	if a.cliSet["split"] {
+		a.SeparateArchived = flags.split
+	}
+	if a.cliSet["ordered"] {
+		a.Ordered = flags.ordered
+	}
+	if a.cliSet["previews"] {
+		a.Previews = flags.previews
+	}
+	if a.cliSet["max-attachment-mb"] {
+		if flags.maxAttachMB < 0 {
+			return a, nil, fmt.Errorf("--max-attachment-mb can't be negative")
+		}
+		a.MaxAttachmentMB = flags.maxAttachMB
+	}
+	if a.cliSet["board-attachment-mb"] {
+		if flags.boardMB < 0 {
+			return a, nil, fmt.Errorf("--board-attachment-mb can't be negative")
+		}
+		a.BoardAttachMB = flags.boardMB
+	}
+	if a.cliSet["attachment-allow"] {
+		a.AttachmentAllow = flags.allow
+	}
+	if a.cliSet["attachment-deny"] {
+		a.AttachmentDeny = flags.deny
+	}
+	if a.cliSet["format"] {
+		for _, f := range flags.formats {
+			if !slices.Contains(knownFormats, f) {
+				return a, nil, fmt.Errorf("unknown --format %q (known: %v)", f, knownFormats)
+			}
+		}
+		a.Formats = flags.formats
+	}
+	if a.cliSet["csv-checklists"] {
+		a.CSVChecklists = flags.csvChecks
+	}
+	if a.cliSet["csv-comments"] {
+		a.CSVComments = flags.csvComments
+	}
+	if a.cliSet["migration-map"] {
+		if _, err := loadMigrationMap(flags.migrateMap); err != nil {
+			return a, nil, err
+		}
+		a.MigrationMap = flags.migrateMap
+	}
+	if a.cliSet["on-deleted"] {
+		if !slices.Contains(knownOnDeleted, flags.onDeleted) {
+			return a, nil, fmt.Errorf("unknown --on-deleted %q (known: %v)", flags.onDeleted, knownOnDeleted)
+		}
+		a.OnDeleted = flags.onDeleted
+	}
+	a.ReportFile = flags.report
+	a.ReportMarkdown = flags.reportMD
+	a.MetricsTextfile = flags.metricsFile
+
+	// Check if we need to use STDIN (Pipe) or -b for BoardIDs
+	boards, err := getBoardIDs(append(flags.boards, args...), os.Stdin)
+	if err != nil && len(configBoardIDs(a.fileConfig)) > 0 {
+		boards, err = configBoardIDs(a.fileConfig), nil
+	}
+	if err != nil {
+		return a, nil, err
+	}
+
+	return a, boards, nil
+}
+
+/*
+normalizeLegacyArgs
+
+	Keep the pre subcommand CLI working, ie `trellgo -b X -a -split -s /path` or `trellgo -b X -labels`.
+	Single dash long flags (-loud, -logs, -split) are turned into --flags, and a command
+	is picked from -labels / -count when no subcommand was given.
+*/
+func normalizeLegacyArgs(root *cobra.Command, args []string) []string {
+
+	// Every multi character flag name known to any command
+	longFlags := make(map[string]bool)
+	var walk func(c *cobra.Command)
+	walk = func(c *cobra.Command) {
+		c.LocalFlags().VisitAll(func(f *pflag.Flag) { longFlags[f.Name] = true })
+		c.PersistentFlags().VisitAll(func(f *pflag.Flag) { longFlags[f.Name] = true })
+		for _, sub := range c.Commands() {
+			walk(sub)
+		}
+	}
+	walk(root)
+	longFlags["labels"] = true
+	longFlags["count"] = true
+
+	var (
+		out     []string
+		command string
+	)
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && len(arg) > 2 {
+			name, _, _ := strings.Cut(arg[1:], "=")
+			if longFlags[name] {
+				arg = "-" + arg
+			}
+		}
+		switch arg {
+		case "--labels":
+			command = "labels"
+			continue
+		case "--count":
+			command = "count"
+			continue
+		}
+		out = append(out, arg)
+	}
+
+	// Already using a subcommand, or just asking for help/version
+	if len(out) == 0 || !strings.HasPrefix(out[0], "-") {
+		return out
+	}
+	switch out[0] {
+	case "-h", "--help", "-v", "--version":
+		return out
+	}
+
+	// Old style `-config file config validate`
+	for i := 0; i+1 < len(out); i++ {
+		if out[i] == "config" && out[i+1] == "validate" {
+			return append([]string{"config", "validate"}, append(out[:i:i], out[i+2:]...)...)
+		}
+	}
+
+	if command == "" {
+		command = "dump"
+	}
+
+	return append([]string{command}, out...)
+}
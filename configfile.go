@@ -0,0 +1,580 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// Output formats trellgo knows how to write for a board
+var knownFormats = []string{"markdown", "sqlite", "csv", "ics", "jira", "github", "gitlab", "wekan"}
+
+// Daemon group names end up in archive file names
+var groupNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+/*
+FileConfig
+
+	Layout of the -config YAML file.  Defaults apply to every board,
+	each entry under boards can override any of them for that board only.
+*/
+type FileConfig struct {
+	Defaults BoardProfile   `yaml:"defaults"`
+	Boards   []BoardProfile `yaml:"boards"`
+	Daemon   DaemonConfig   `yaml:"daemon"`
+	Notify   []NotifyConfig `yaml:"notify,omitempty"` // sent after dump, and after daemon groups without their own
+	Ship     []ShipConfig   `yaml:"ship,omitempty"`   // each board tree is shipped as soon as it is dumped
+}
+
+/*
+DaemonConfig
+
+	Settings for `trellgo daemon`, each group of boards runs on its own cron schedule
+*/
+type DaemonConfig struct {
+	LockFile      string       `yaml:"lock_file,omitempty"`
+	MetricsListen string       `yaml:"metrics_listen,omitempty"`
+	Groups        []BoardGroup `yaml:"groups"`
+}
+
+/*
+BoardGroup is a set of boards dumped together on a schedule, followed by the post run pipeline
+*/
+type BoardGroup struct {
+	Name     string         `yaml:"name"`
+	Schedule string         `yaml:"schedule"`         // standard 5 field cron expression, or @daily, @every 6h, etc
+	Boards   []string       `yaml:"boards,omitempty"` // empty means every board in the config file
+	Pipeline PipelineConfig `yaml:"pipeline,omitempty"`
+}
+
+/*
+PipelineConfig - what happens after a group has been dumped, in order: archive, ship, prune, notify
+*/
+type PipelineConfig struct {
+	Archive ArchiveConfig  `yaml:"archive,omitempty"`
+	Ship    []ShipConfig   `yaml:"ship,omitempty"`
+	Prune   PruneConfig    `yaml:"prune,omitempty"`
+	Notify  []NotifyConfig `yaml:"notify,omitempty"` // replaces the top level notify list for this group
+}
+
+type ArchiveConfig struct {
+	Dir  string `yaml:"dir,omitempty"`  // where the .tar.gz goes, archiving is off when empty
+	Name string `yaml:"name,omitempty"` // {group} and {date} are replaced, default trello-board-dump-{group}-{date}.tar.gz
+}
+
+/*
+ShipConfig - one place finished backups are copied to
+*/
+type ShipConfig struct {
+	Type        string `yaml:"type"`                   // dir, sftp or webdav
+	Path        string `yaml:"path,omitempty"`         // dir: destination directory (ie a mounted share), sftp: remote directory
+	URL         string `yaml:"url,omitempty"`          // webdav: collection to mirror into, password from TRELLGO_WEBDAV_PASSWORD
+	User        string `yaml:"user,omitempty"`         // sftp and webdav
+	DeleteLocal bool   `yaml:"delete_local,omitempty"` // pipeline only, remove the local archive once every ship entry has it
+	Mirror      bool   `yaml:"mirror,omitempty"`       // board trees only, remove what is no longer in the local tree from the destination
+
+	Host       string `yaml:"host,omitempty"` // sftp
+	Port       int    `yaml:"port,omitempty"`
+	KeyFile    string `yaml:"key_file,omitempty"`    // private key, passphrase from TRELLGO_SSH_PASSPHRASE if it has one
+	KnownHosts string `yaml:"known_hosts,omitempty"` // default ~/.ssh/known_hosts, the host key must be in it
+	Checksum   bool   `yaml:"checksum,omitempty"`    // read uploads back and compare sha256, size is always checked
+}
+
+/*
+NotifyConfig - one place a run summary is sent
+*/
+type NotifyConfig struct {
+	Type      string `yaml:"type"`                 // webhook, slack, mattermost or email
+	OnFailure bool   `yaml:"on_failure,omitempty"` // only send when the run had errors
+
+	URL      string `yaml:"url,omitempty"` // webhook, slack, mattermost
+	Channel  string `yaml:"channel,omitempty"`
+	Username string `yaml:"username,omitempty"`
+
+	SMTPHost string   `yaml:"smtp_host,omitempty"` // email, the password comes from TRELLGO_SMTP_PASSWORD
+	SMTPPort int      `yaml:"smtp_port,omitempty"`
+	SMTPUser string   `yaml:"smtp_user,omitempty"`
+	From     string   `yaml:"from,omitempty"`
+	To       []string `yaml:"to,omitempty"`
+}
+
+type PruneConfig struct {
+	RemoveDump   bool `yaml:"remove_dump,omitempty"`   // delete the dumped lists and cards once archived, the board state stays
+	KeepArchives int  `yaml:"keep_archives,omitempty"` // keep this many archives for the group, 0 keeps all
+}
+
+/*
+BoardProfile
+
+	Options that can be set globally or per board in the config file.
+	Pointers are used so an unset value can be told apart from false/empty.
+*/
+type BoardProfile struct {
+	ID               string   `yaml:"id,omitempty"`
+	Name             string   `yaml:"name,omitempty"`
+	Archived         *bool    `yaml:"archived,omitempty"`
+	SeparateArchived *bool    `yaml:"split,omitempty"`
+	Ordered          *bool    `yaml:"ordered,omitempty"`
+	OnDeleted        *string  `yaml:"on_deleted,omitempty"`
+	Previews         *bool    `yaml:"previews,omitempty"`
+	MaxAttachmentMB  *int     `yaml:"max_attachment_mb,omitempty"`
+	BoardAttachMB    *int     `yaml:"board_attachment_mb,omitempty"`
+	AttachmentAllow  []string `yaml:"attachment_allow,omitempty"`
+	AttachmentDeny   []string `yaml:"attachment_deny,omitempty"`
+	LabelID          *string  `yaml:"label,omitempty"`
+	StoragePath      *string  `yaml:"storage,omitempty"`
+	Formats          []string `yaml:"formats,omitempty"`
+	CSVChecklists    *bool    `yaml:"csv_checklists,omitempty"`
+	CSVComments      *bool    `yaml:"csv_comments,omitempty"`
+	MigrationMap     *string  `yaml:"migration_map,omitempty"`
+	DoneLists        []string `yaml:"done_lists,omitempty"`  // report
+	StartLists       []string `yaml:"start_lists,omitempty"` // report
+	StaleDays        *int     `yaml:"stale_days,omitempty"`  // report
+}
+
+/*
+loadConfigFile
+
+	Read and parse a YAML config file.  Unknown keys are rejected so typos don't silently do nothing.
+	Only YAML is read, a file named for another format (ie .toml or .json) is refused rather than
+	failing part way through parsing it.
+*/
+func loadConfigFile(fileName string) (*FileConfig, error) {
+
+	if ext := strings.ToLower(filepath.Ext(fileName)); ext != "" && ext != ".yaml" && ext != ".yml" {
+		return nil, fmt.Errorf("config file %s: only YAML config files are supported (.yaml or .yml), not %s", fileName, ext)
+	}
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file %s: %w", fileName, err)
+	}
+
+	var fc FileConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&fc); err != nil {
+		return nil, fmt.Errorf("parsing config file %s: %w", fileName, err)
+	}
+
+	return &fc, nil
+}
+
+/*
+validateConfigFile
+
+	Check a loaded config file for problems that would only show up part way through a run.
+	cliStorage is the -s value, if any, since it satisfies the storage requirement for every board.
+*/
+func validateConfigFile(fc *FileConfig, cliStorage string) []error {
+
+	var (
+		errs []error
+		seen = make(map[string]bool)
+	)
+
+	if err := validateProfile(fc.Defaults, "defaults"); err != nil {
+		errs = append(errs, err...)
+	}
+
+	for i, b := range fc.Boards {
+		where := fmt.Sprintf("boards[%d]", i)
+		if b.ID == "" {
+			errs = append(errs, fmt.Errorf("%s: missing board id", where))
+		} else {
+			where = fmt.Sprintf("boards[%d] (%s)", i, b.ID)
+			if seen[b.ID] {
+				errs = append(errs, fmt.Errorf("%s: board id listed more than once", where))
+			}
+			seen[b.ID] = true
+		}
+
+		if err := validateProfile(b, where); err != nil {
+			errs = append(errs, err...)
+		}
+
+		// Merged view is what the run will actually use
+		merged := mergeProfile(fc.Defaults, b)
+		if cliStorage == "" && (merged.StoragePath == nil || *merged.StoragePath == "") {
+			errs = append(errs, fmt.Errorf("%s: no storage path set here, in defaults, or with -s", where))
+		}
+		if merged.LabelID != nil && *merged.LabelID != "" && merged.Archived != nil && *merged.Archived {
+			errs = append(errs, fmt.Errorf("%s: label filter cannot be combined with archived", where))
+		}
+	}
+
+	errs = append(errs, validateNotify(fc.Notify, "notify")...)
+	for i, sc := range fc.Ship {
+		if _, err := newShipper(sc); err != nil {
+			errs = append(errs, fmt.Errorf("ship[%d]: %v", i, err))
+		}
+		// The board directory is the next dump's starting point (state, resume files, deleted history)
+		if sc.DeleteLocal {
+			errs = append(errs, fmt.Errorf("ship[%d]: delete_local only applies to a daemon group's pipeline archive, board directories are kept for the next dump", i))
+		}
+	}
+	errs = append(errs, validateDaemonConfig(fc, cliStorage)...)
+
+	return errs
+}
+
+/*
+validateDaemonConfig checks the daemon groups, schedules and pipeline settings
+*/
+func validateDaemonConfig(fc *FileConfig, cliStorage string) []error {
+	var (
+		errs  []error
+		names = make(map[string]bool)
+	)
+
+	for i, g := range fc.Daemon.Groups {
+		where := fmt.Sprintf("daemon.groups[%d]", i)
+		if g.Name == "" {
+			errs = append(errs, fmt.Errorf("%s: missing group name", where))
+		} else {
+			where = fmt.Sprintf("daemon.groups[%d] (%s)", i, g.Name)
+			if names[g.Name] {
+				errs = append(errs, fmt.Errorf("%s: group name used more than once", where))
+			}
+			names[g.Name] = true
+			if !groupNameRe.MatchString(g.Name) {
+				errs = append(errs, fmt.Errorf("%s: group name may only use letters, numbers, '.', '_' and '-'", where))
+			}
+		}
+
+		if _, err := cron.ParseStandard(g.Schedule); err != nil {
+			errs = append(errs, fmt.Errorf("%s: bad schedule %q: %v", where, g.Schedule, err))
+		}
+
+		boards := groupBoardIDs(g, fc)
+		if len(boards) == 0 {
+			errs = append(errs, fmt.Errorf("%s: no boards, list them here or under boards", where))
+		}
+		for _, id := range boards {
+			if slices.Contains(configBoardIDs(fc), id) {
+				continue // checked above with the rest of the boards
+			}
+			if cliStorage == "" && (fc.Defaults.StoragePath == nil || *fc.Defaults.StoragePath == "") {
+				errs = append(errs, fmt.Errorf("%s: board %s has no storage path, add it under boards or set storage in defaults", where, id))
+			}
+		}
+
+		p := g.Pipeline
+		if len(p.Ship) > 0 && p.Archive.Dir == "" {
+			errs = append(errs, fmt.Errorf("%s: ship needs pipeline.archive.dir, only archives are shipped", where))
+		}
+		if p.Prune.RemoveDump && p.Archive.Dir == "" {
+			errs = append(errs, fmt.Errorf("%s: prune.remove_dump without pipeline.archive.dir would delete the only copy", where))
+		}
+		if p.Prune.KeepArchives < 0 {
+			errs = append(errs, fmt.Errorf("%s: prune.keep_archives can not be negative", where))
+		}
+		for j, sc := range p.Ship {
+			if _, err := newShipper(sc); err != nil {
+				errs = append(errs, fmt.Errorf("%s: ship[%d]: %v", where, j, err))
+			}
+			// An archive is one file among the earlier ones, mirroring it would remove them
+			if sc.Mirror {
+				errs = append(errs, fmt.Errorf("%s: ship[%d]: mirror only applies to top level ship entries, which ship board directories", where, j))
+			}
+		}
+		errs = append(errs, validateNotify(p.Notify, where+".pipeline.notify")...)
+	}
+
+	return errs
+}
+
+/*
+validateNotify checks a list of notify entries
+*/
+func validateNotify(ncs []NotifyConfig, where string) []error {
+	var errs []error
+
+	for i, nc := range ncs {
+		if _, err := newNotifier(nc); err != nil {
+			errs = append(errs, fmt.Errorf("%s[%d]: %v", where, i, err))
+		}
+	}
+
+	return errs
+}
+
+/*
+groupBoardIDs returns the boards a daemon group dumps, every config file board when the group doesn't list any
+*/
+func groupBoardIDs(g BoardGroup, fc *FileConfig) []string {
+	if len(g.Boards) > 0 {
+		return g.Boards
+	}
+	return configBoardIDs(fc)
+}
+
+/*
+validateProfile checks the values of a single profile
+*/
+func validateProfile(p BoardProfile, where string) []error {
+	var errs []error
+
+	for _, f := range p.Formats {
+		if !slices.Contains(knownFormats, f) {
+			errs = append(errs, fmt.Errorf("%s: unknown output format %q (known: %v)", where, f, knownFormats))
+		}
+	}
+	if p.StoragePath != nil && *p.StoragePath == "" {
+		errs = append(errs, fmt.Errorf("%s: storage is set but empty", where))
+	}
+	if p.OnDeleted != nil && !slices.Contains(knownOnDeleted, *p.OnDeleted) {
+		errs = append(errs, fmt.Errorf("%s: unknown on_deleted %q (known: %v)", where, *p.OnDeleted, knownOnDeleted))
+	}
+	if p.MaxAttachmentMB != nil && *p.MaxAttachmentMB < 0 {
+		errs = append(errs, fmt.Errorf("%s: max_attachment_mb can't be negative", where))
+	}
+	if p.BoardAttachMB != nil && *p.BoardAttachMB < 0 {
+		errs = append(errs, fmt.Errorf("%s: board_attachment_mb can't be negative", where))
+	}
+	if p.StaleDays != nil && *p.StaleDays < 0 {
+		errs = append(errs, fmt.Errorf("%s: stale_days can't be negative", where))
+	}
+	if p.MigrationMap != nil {
+		if _, err := loadMigrationMap(*p.MigrationMap); err != nil {
+			errs = append(errs, fmt.Errorf("%s: migration_map: %w", where, err))
+		}
+	}
+
+	return errs
+}
+
+/*
+mergeProfile
+
+	Overlay a board profile on top of the defaults, board values win
+*/
+func mergeProfile(defaults BoardProfile, board BoardProfile) BoardProfile {
+
+	merged := defaults
+	merged.ID = board.ID
+	merged.Name = board.Name
+
+	if board.Archived != nil {
+		merged.Archived = board.Archived
+	}
+	if board.SeparateArchived != nil {
+		merged.SeparateArchived = board.SeparateArchived
+	}
+	if board.Ordered != nil {
+		merged.Ordered = board.Ordered
+	}
+	if board.OnDeleted != nil {
+		merged.OnDeleted = board.OnDeleted
+	}
+	if board.Previews != nil {
+		merged.Previews = board.Previews
+	}
+	if board.MaxAttachmentMB != nil {
+		merged.MaxAttachmentMB = board.MaxAttachmentMB
+	}
+	if board.BoardAttachMB != nil {
+		merged.BoardAttachMB = board.BoardAttachMB
+	}
+	if board.AttachmentAllow != nil {
+		merged.AttachmentAllow = board.AttachmentAllow
+	}
+	if board.AttachmentDeny != nil {
+		merged.AttachmentDeny = board.AttachmentDeny
+	}
+	if board.LabelID != nil {
+		merged.LabelID = board.LabelID
+	}
+	if board.StoragePath != nil {
+		merged.StoragePath = board.StoragePath
+	}
+	if board.Formats != nil {
+		merged.Formats = board.Formats
+	}
+	if board.CSVChecklists != nil {
+		merged.CSVChecklists = board.CSVChecklists
+	}
+	if board.CSVComments != nil {
+		merged.CSVComments = board.CSVComments
+	}
+	if board.MigrationMap != nil {
+		merged.MigrationMap = board.MigrationMap
+	}
+	if board.DoneLists != nil {
+		merged.DoneLists = board.DoneLists
+	}
+	if board.StartLists != nil {
+		merged.StartLists = board.StartLists
+	}
+	if board.StaleDays != nil {
+		merged.StaleDays = board.StaleDays
+	}
+
+	return merged
+}
+
+/*
+applyProfile
+
+	Apply profile values onto ARGS, skipping anything explicitly set on the CLI (CLI always wins)
+*/
+func applyProfile(args ARGS, p BoardProfile) ARGS {
+
+	if p.Archived != nil && !args.cliSet["archived"] {
+		args.Archived = *p.Archived
+	}
+	if p.SeparateArchived != nil && !args.cliSet["split"] {
+		args.SeparateArchived = *p.SeparateArchived
+	}
+	if p.Ordered != nil && !args.cliSet["ordered"] {
+		args.Ordered = *p.Ordered
+	}
+	if p.OnDeleted != nil && !args.cliSet["on-deleted"] {
+		args.OnDeleted = *p.OnDeleted
+	}
+	if p.Previews != nil && !args.cliSet["previews"] {
+		args.Previews = *p.Previews
+	}
+	if p.MaxAttachmentMB != nil && !args.cliSet["max-attachment-mb"] {
+		args.MaxAttachmentMB = *p.MaxAttachmentMB
+	}
+	if p.BoardAttachMB != nil && !args.cliSet["board-attachment-mb"] {
+		args.BoardAttachMB = *p.BoardAttachMB
+	}
+	if p.AttachmentAllow != nil && !args.cliSet["attachment-allow"] {
+		args.AttachmentAllow = p.AttachmentAllow
+	}
+	if p.AttachmentDeny != nil && !args.cliSet["attachment-deny"] {
+		args.AttachmentDeny = p.AttachmentDeny
+	}
+	if p.LabelID != nil && !args.cliSet["label"] {
+		args.LabelID = *p.LabelID
+	}
+	if p.StoragePath != nil && !args.cliSet["storage"] {
+		args.StoragePath = *p.StoragePath
+	}
+	if p.Formats != nil && !args.cliSet["format"] {
+		args.Formats = p.Formats
+	}
+	if p.CSVChecklists != nil && !args.cliSet["csv-checklists"] {
+		args.CSVChecklists = *p.CSVChecklists
+	}
+	if p.CSVComments != nil && !args.cliSet["csv-comments"] {
+		args.CSVComments = *p.CSVComments
+	}
+	if p.MigrationMap != nil && !args.cliSet["migration-map"] {
+		args.MigrationMap = *p.MigrationMap
+	}
+	if p.DoneLists != nil && !args.cliSet["done"] {
+		args.DoneLists = p.DoneLists
+	}
+	if p.StartLists != nil && !args.cliSet["start"] {
+		args.StartLists = p.StartLists
+	}
+	if p.StaleDays != nil && !args.cliSet["stale-days"] {
+		args.StaleDays = *p.StaleDays
+	}
+
+	return args
+}
+
+/*
+boardArgs
+
+	Returns the ARGS to use for a specific board, with that board's config file section applied
+*/
+func boardArgs(args ARGS, boardID string) ARGS {
+
+	if args.fileConfig == nil {
+		return args
+	}
+
+	for _, b := range args.fileConfig.Boards {
+		if b.ID == boardID {
+			return applyProfile(args, mergeProfile(args.fileConfig.Defaults, b))
+		}
+	}
+
+	return args
+}
+
+/*
+configBoardIDs returns the board IDs listed in the config file, in file order
+*/
+func configBoardIDs(fc *FileConfig) []string {
+	var ids []string
+
+	if fc == nil {
+		return ids
+	}
+	for _, b := range fc.Boards {
+		if b.ID != "" {
+			ids = append(ids, b.ID)
+		}
+	}
+
+	return ids
+}
+
+/*
+hasFormat reports whether an output format is turned on, markdown always is
+*/
+func hasFormat(args ARGS, format string) bool {
+	return format == "markdown" || slices.Contains(args.Formats, format)
+}
+
+/*
+storageForAll reports whether every board ends up with a storage path
+*/
+func storageForAll(args ARGS, boards []string) bool {
+	for _, id := range boards {
+		if boardArgs(args, id).StoragePath == "" {
+			return false
+		}
+	}
+	return len(boards) > 0
+}
+
+/*
+labelArchivedOK reports false if any board ends up with both a label filter and archived cards enabled
+*/
+func labelArchivedOK(args ARGS, boards []string) bool {
+	for _, id := range boards {
+		b := boardArgs(args, id)
+		if b.LabelID != "" && b.Archived {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+runConfigValidate
+
+	Handles `trellgo config validate --config file`.  Prints any problems found.
+*/
+func runConfigValidate(fc *FileConfig, cliStorage string) error {
+
+	if fc == nil {
+		return fmt.Errorf("config validate requires --config \"file\"")
+	}
+
+	errs := validateConfigFile(fc, cliStorage)
+	if len(errs) > 0 {
+		fmt.Printf("Config file has %d problem(s):\n", len(errs))
+		for _, err := range errs {
+			fmt.Println("  - " + err.Error())
+		}
+		return fmt.Errorf("config file is not valid")
+	}
+
+	fmt.Printf("Config file OK: %d board(s) configured\n", len(fc.Boards))
+	return nil
+}
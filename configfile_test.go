@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigFileYAMLOnly(t *testing.T) {
+	dir := t.TempDir()
+	yml := "defaults:\n  storage: /opt/trellgo\n"
+
+	for _, name := range []string{"trellgo.yaml", "trellgo.YML", "trellgo"} {
+		fileName := filepath.Join(dir, name)
+		if err := os.WriteFile(fileName, []byte(yml), 0600); err != nil {
+			t.Fatal(err)
+		}
+		fc, err := loadConfigFile(fileName)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if fc.Defaults.StoragePath == nil || *fc.Defaults.StoragePath != "/opt/trellgo" {
+			t.Errorf("%s: storage not read", name)
+		}
+	}
+
+	// Even YAML content is refused under another format's name, it would be edited as that format
+	for _, name := range []string{"trellgo.toml", "trellgo.json"} {
+		fileName := filepath.Join(dir, name)
+		if err := os.WriteFile(fileName, []byte(yml), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadConfigFile(fileName); err == nil || !strings.Contains(err.Error(), "only YAML config files are supported") {
+			t.Errorf("%s: err = %v, want it refused as not YAML", name, err)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adlio/trello"
+)
+
+// Written in each board directory with --format csv, the optional two only when asked for
+const (
+	CardsCSVFile      = "cards.csv"
+	ChecklistsCSVFile = "checklist_items.csv"
+	CommentsCSVFile   = "comments.csv"
+)
+
+var (
+	cardsCSVHeader = []string{"id", "short_link", "name", "list", "list_id", "position", "labels", "members", "due", "start", "due_complete",
+		"closed", "created", "last_activity", "checklist_items", "checklist_items_done", "comments", "attachments", "url"}
+	checklistsCSVHeader = []string{"card_id", "card", "list", "checklist", "checklist_position", "item_id", "item", "item_position", "state"}
+	commentsCSVHeader   = []string{"card_id", "card", "list", "comment_id", "date", "author", "text"}
+)
+
+/*
+csvExport
+
+	A board's CSV files.  Card workers add rows as they go, and the files are written
+	once the whole board has been processed, in board order (list position, then card position).
+*/
+type csvExport struct {
+	mu         sync.Mutex
+	dir        string
+	lists      map[string]*trello.List
+	checklists bool
+	comments   bool
+	cards      []csvCard
+}
+
+// The rows for one card, with what they sort by
+type csvCard struct {
+	listPos    float64
+	pos        float64
+	row        []string
+	checkItems [][]string
+	comments   [][]string
+}
+
+/*
+newCSVExport is nil when the csv format isn't on for the board
+*/
+func newCSVExport(config Config, boardDir string, lists map[string]*trello.List) *csvExport {
+	if !hasFormat(config.ARGS, "csv") {
+		return nil
+	}
+	return &csvExport{dir: boardDir, lists: lists, checklists: config.ARGS.CSVChecklists, comments: config.ARGS.CSVComments}
+}
+
+/*
+addCard
+
+	Add a card's rows.  Checklist items and comments come from getComprehensiveCardData,
+	a card without them (link cards, or the fetch failed) still gets its cards.csv row from the badges.
+*/
+func (c *csvExport) addCard(card *trello.Card) {
+	if c == nil {
+		return
+	}
+
+	entry := csvCard{pos: card.Pos}
+	listName := ""
+	if list, ok := c.lists[card.IDList]; ok {
+		listName = list.Name
+		entry.listPos = float64(list.Pos)
+	}
+
+	var labels []string
+	for _, l := range card.Labels {
+		if l.Name != "" {
+			labels = append(labels, l.Name)
+		} else {
+			labels = append(labels, l.Color)
+		}
+	}
+	var members []string
+	if len(card.Members) > 0 {
+		for _, m := range card.Members {
+			members = append(members, m.Username)
+		}
+	} else {
+		for _, id := range card.IDMembers {
+			members = append(members, memberUsername(id))
+		}
+	}
+
+	created := card.CreatedAt()
+	entry.row = []string{
+		card.ID,
+		card.ShortLink,
+		csvText(card.Name),
+		csvText(listName),
+		card.IDList,
+		strconv.FormatFloat(card.Pos, 'f', -1, 64),
+		csvText(strings.Join(labels, "; ")),
+		strings.Join(members, "; "),
+		csvTime(card.Due),
+		csvTime(card.Start),
+		strconv.FormatBool(card.DueComplete),
+		strconv.FormatBool(card.Closed),
+		csvTime(&created),
+		csvTime(card.DateLastActivity),
+		strconv.Itoa(card.Badges.CheckItems),
+		strconv.Itoa(card.Badges.CheckItemsChecked),
+		strconv.Itoa(card.Badges.Comments),
+		strconv.Itoa(card.Badges.Attachments),
+		card.URL,
+	}
+
+	if c.checklists {
+		for _, cl := range card.Checklists {
+			for _, item := range cl.CheckItems {
+				entry.checkItems = append(entry.checkItems, []string{
+					card.ID, csvText(card.Name), csvText(listName),
+					csvText(cl.Name), strconv.FormatFloat(cl.Pos, 'f', -1, 64),
+					item.ID, csvText(item.Name), strconv.FormatFloat(item.Pos, 'f', -1, 64), item.State,
+				})
+			}
+		}
+	}
+
+	if c.comments {
+		var comments []*trello.Action
+		for _, a := range card.Actions {
+			if a != nil && a.Type == "commentCard" && a.Data != nil {
+				comments = append(comments, a)
+			}
+		}
+		// Trello returns newest first
+		slices.SortStableFunc(comments, func(a, b *trello.Action) int { return a.Date.Compare(b.Date) })
+		for _, a := range comments {
+			author := a.IDMemberCreator
+			if a.MemberCreator != nil && a.MemberCreator.Username != "" {
+				author = a.MemberCreator.Username
+			}
+			entry.comments = append(entry.comments, []string{
+				card.ID, csvText(card.Name), csvText(listName),
+				a.ID, a.Date.UTC().Format(time.RFC3339), author, csvText(a.Data.Text),
+			})
+		}
+	}
+
+	c.mu.Lock()
+	c.cards = append(c.cards, entry)
+	c.mu.Unlock()
+}
+
+/*
+write
+
+	Write the board's CSV files.  Called once every card has been added, a run that
+	stopped part way leaves the files from the last complete run in place.
+*/
+func (c *csvExport) write(board *trello.Board, config Config) {
+	if c == nil {
+		return
+	}
+	if runCtx.Err() != nil {
+		logger("Shutting down, not rewriting the CSV files for board "+board.Name, "warn", true, false, config, LogFieldBoard, board.ID, LogFieldOp, "csv")
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	slices.SortStableFunc(c.cards, func(a, b csvCard) int {
+		return cmp.Or(cmp.Compare(a.listPos, b.listPos), cmp.Compare(a.pos, b.pos))
+	})
+
+	files := []struct {
+		name   string
+		header []string
+		rows   func(csvCard) [][]string
+		on     bool
+	}{
+		{CardsCSVFile, cardsCSVHeader, func(e csvCard) [][]string { return [][]string{e.row} }, true},
+		{ChecklistsCSVFile, checklistsCSVHeader, func(e csvCard) [][]string { return e.checkItems }, c.checklists},
+		{CommentsCSVFile, commentsCSVHeader, func(e csvCard) [][]string { return e.comments }, c.comments},
+	}
+
+	for _, f := range files {
+		if !f.on {
+			continue
+		}
+
+		var rows [][]string
+		for _, e := range c.cards {
+			rows = append(rows, f.rows(e)...)
+		}
+
+		fileName := filepath.Join(c.dir, f.name)
+		if err := writeCSVFile(fileName, f.header, rows); err != nil {
+			logger("CRITICAL - Unable to write "+fileName+" Error: "+err.Error(), "err", true, false, config, LogFieldBoard, board.ID, LogFieldOp, "csv")
+			errorWarnOnCompletion = true
+			continue
+		}
+		logger(fmt.Sprintf("Wrote %d rows to %s", len(rows), fileName), "info", true, true, config, LogFieldBoard, board.ID, LogFieldOp, "csv")
+	}
+}
+
+/*
+writeCSVFile writes a header and rows as a CSV file
+*/
+func writeCSVFile(fileName string, header []string, rows [][]string) error {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(header)
+	w.WriteAll(rows)
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, buf.Bytes(), SecureFileMode)
+}
+
+// csvTime is a Trello time as RFC 3339 UTC, empty when not set
+func csvTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+/*
+csvText guards free text against spreadsheet formula injection, a cell starting
+with = + - @ (or a tab/CR) would be run as a formula by Excel and friends, so it gets a leading '
+*/
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/cobra"
+)
+
+// Only one group runs at a time, the run report and board tracker are process wide
+var groupMu sync.Mutex
+
+// Taken by every daemon group run and dump, unless --lock-file or daemon.lock_file say otherwise
+var DefaultLockFile = filepath.Join(os.TempDir(), "trellgo.lock")
+
+func newDaemonCmd() *cobra.Command {
+	var (
+		metricsListen string
+		lockFile      string
+		runNow        bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "daemon",
+		Short: "Run board groups on their cron schedules from the config file",
+		Long: "Run board groups on their cron schedules from the daemon section of --config.\n" +
+			"After each group is dumped it is archived, shipped, pruned and notified in process.\n" +
+			"SIGTERM/SIGINT finish the card in progress and the rest of that group's pipeline is skipped.",
+		Example: "  trellgo daemon --config '/etc/trellgo.yaml' --logs '/var/log/trellgo.log'\n" +
+			"  trellgo daemon --config '/etc/trellgo.yaml' --metrics-listen ':9465' --run-now",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, err := baseArgs(cmd)
+			if err != nil {
+				return err
+			}
+			if a.fileConfig == nil {
+				return fmt.Errorf("daemon requires --config \"file\"")
+			}
+			if errs := validateConfigFile(a.fileConfig, ""); len(errs) > 0 {
+				return runConfigValidate(a.fileConfig, "")
+			}
+
+			dc := a.fileConfig.Daemon
+			if !a.cliSet["metrics-listen"] {
+				metricsListen = dc.MetricsListen
+			}
+			if !a.cliSet["lock-file"] && dc.LockFile != "" {
+				lockFile = dc.LockFile
+			}
+
+			startRun(a)
+			return runDaemon(dc.Groups, metricsListen, lockFile, runNow)
+		},
+	}
+	cmd.Flags().StringVar(&metricsListen, "metrics-listen", "", "Serve Prometheus metrics on this address, ie :9465 (overrides daemon.metrics_listen)")
+	cmd.Flags().StringVar(&lockFile, "lock-file", DefaultLockFile, "Lock file that stops two runs overlapping (overrides daemon.lock_file)")
+	cmd.Flags().BoolVar(&runNow, "run-now", false, "Run every group once at start up, then follow the schedules")
+	return cmd
+}
+
+/*
+runDaemon
+
+	Schedule every group and block until SIGTERM/SIGINT.
+	One Trello client (and its 429 handling) is shared by every run.
+*/
+func runDaemon(groups []BoardGroup, metricsListen string, lockFile string, runNow bool) error {
+
+	if len(groups) == 0 {
+		return fmt.Errorf("no daemon groups in %s", config.ARGS.ConfigFile)
+	}
+
+	c := cron.New()
+	for _, g := range groups {
+		if _, err := c.AddFunc(g.Schedule, func() { runGroup(g, lockFile) }); err != nil {
+			return fmt.Errorf("group %s: %w", g.Name, err)
+		}
+	}
+
+	var srv *http.Server
+	if metricsListen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metricsHandler())
+		srv = &http.Server{Addr: metricsListen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger("Error: Metrics listener on "+metricsListen+" stopped: "+err.Error(), "err", true, false, config, LogFieldOp, "daemon")
+			}
+		}()
+		logger("Serving metrics on "+metricsListen+"/metrics", "info", true, false, config, LogFieldOp, "daemon")
+	}
+
+	c.Start()
+	for _, e := range c.Entries() {
+		logger("Next scheduled run: "+e.Next.Format("2006-01-02 15:04:05"), "info", true, true, config, LogFieldOp, "daemon")
+	}
+	logger(fmt.Sprintf("Daemon started with %d group(s), lock file %s", len(groups), lockFile), "info", true, false, config, LogFieldOp, "daemon")
+
+	if runNow {
+		go func() {
+			for _, g := range groups {
+				runGroup(g, lockFile)
+			}
+		}()
+	}
+
+	<-runCtx.Done()
+	logger("Shutdown requested, waiting for the running group to finish its current cards", "info", true, false, config, LogFieldOp, "daemon")
+
+	// Stop scheduling and wait for a running job, it stops taking new cards on its own
+	<-c.Stop().Done()
+	groupMu.Lock()
+	defer groupMu.Unlock()
+
+	if srv != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}
+
+	logger("Daemon stopped", "info", true, false, config, LogFieldOp, "daemon")
+	return nil
+}
+
+/*
+runGroup
+
+	One scheduled run of a group: dump its boards, then the post run pipeline.
+	Skipped if another trellgo process holds the lock file.
+*/
+func runGroup(g BoardGroup, lockFile string) {
+
+	groupMu.Lock()
+	defer groupMu.Unlock()
+
+	if runCtx.Err() != nil {
+		return
+	}
+
+	release, err := acquireLock(lockFile)
+	if err != nil {
+		logger("Skipping run of group "+g.Name+": "+err.Error(), "warn", true, false, config, LogFieldOp, "daemon")
+		return
+	}
+	defer release()
+
+	// Fresh per run state, metrics keep counting for the life of the process
+	boardTracker = nil
+	errorWarnOnCompletion = false
+	runReport = newRunReport()
+	sftpMtimeWarned.Store(false)
+
+	boards := groupBoardIDs(g, config.ARGS.fileConfig)
+	logger(fmt.Sprintf("Starting run of group %s (%d board(s))", g.Name, len(boards)), "info", true, false, config, LogFieldOp, "daemon")
+
+	runErr := runDump(boards)
+	if runErr != nil {
+		logger("Error: Group "+g.Name+": "+runErr.Error(), "err", true, false, config, LogFieldOp, "daemon")
+	}
+
+	// A half finished dump isn't worth archiving over the last good one
+	if runCtx.Err() != nil {
+		logger("Shutting down, skipping the pipeline for group "+g.Name, "warn", true, false, config, LogFieldOp, "daemon")
+		return
+	}
+
+	runPipeline(g, runErr)
+}
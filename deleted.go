@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/adlio/trello"
+)
+
+// Written into (or next to) anything found deleted in Trello
+const TombstoneFile = "TOMBSTONE.md"
+
+// Kept in each storage path, which board was dumped into which directory
+const BoardIndexFile = ".trellgo-boards.json"
+
+// What can be done with cards, lists and boards that are gone from Trello (--on-deleted)
+const (
+	OnDeletedMove      = "move"      // move under DELETED/ with a tombstone (default)
+	OnDeletedTombstone = "tombstone" // leave in place with a tombstone
+)
+
+var knownOnDeleted = []string{OnDeletedMove, OnDeletedTombstone}
+
+/*
+DeletedItem
+
+	A card, list or board that was dumped on an earlier run and is no longer in Trello.
+	Kept in the board state (and board index) so it is only reported once, and listed in the run report.
+*/
+type DeletedItem struct {
+	Type       string    `json:"type"` // card, list or board
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Path       string    `json:"path"`   // where it is now, relative to the board directory (storage path for a board)
+	Reason     string    `json:"reason"` // deleted, or moved to another board
+	Action     string    `json:"action"` // moved or tombstoned
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+/*
+goneReason asks Trello why something dumped before wasn't seen this run.
+Returns "" if it still exists here (archived, filtered out, etc) or can't be told.
+*/
+func goneReason(err error, idBoard string, boardID string, what string, fields ...any) string {
+	switch {
+	case err == nil && idBoard == boardID:
+		return ""
+	case err == nil:
+		return "moved to another board"
+	case trello.IsNotFound(err):
+		return "deleted"
+	default:
+		logger("Warning: Unable to check if "+what+" still exists, keeping it: "+err.Error(), "warn", true, false, config, append(fields, LogFieldOp, "deleted")...)
+		return ""
+	}
+}
+
+/*
+retire
+
+	Apply --on-deleted to a directory or file (path relative to root) that is gone from Trello.
+	move puts it at the same place under root/DELETED/, tombstone leaves it.  Either way a
+	tombstone records when it was noticed, inside a directory or as name.TOMBSTONE.md beside a file.
+*/
+func retire(item DeletedItem, root string, mode string, fields ...any) (DeletedItem, error) {
+
+	item.DetectedAt = time.Now().UTC()
+	item.Action = "tombstoned"
+
+	oldPath := filepath.Join(root, filepath.FromSlash(item.Path))
+	newPath := filepath.Join(root, DeletedDir, filepath.FromSlash(item.Path))
+
+	info, err := os.Stat(oldPath)
+	if err != nil && mode != OnDeletedTombstone {
+		// Already there, ie a list whose cards were each moved just before it
+		if moved, merr := os.Stat(newPath); merr == nil {
+			info, err, oldPath = moved, nil, newPath
+		}
+	}
+	if os.IsNotExist(err) && item.Type == "card" {
+		// Removed after it was archived (prune.remove_dump), the tombstone is all that is left of it
+		if mode != OnDeletedTombstone {
+			oldPath = newPath
+		}
+		if err := os.MkdirAll(oldPath, SecureDirMode); err != nil {
+			return item, err
+		}
+		info, err = os.Stat(oldPath)
+	}
+	if err != nil {
+		return item, err
+	}
+
+	if mode != OnDeletedTombstone {
+		if oldPath != newPath {
+			if err := os.MkdirAll(filepath.Dir(newPath), SecureDirMode); err != nil {
+				return item, err
+			}
+			if err := mergeMove(oldPath, newPath); err != nil {
+				return item, err
+			}
+			removeEmptyDirs(filepath.Dir(oldPath), root)
+			oldPath = newPath
+		}
+		item.Path = filepath.ToSlash(filepath.Join(DeletedDir, filepath.FromSlash(item.Path)))
+		item.Action = "moved"
+	}
+
+	tombstone := filepath.Join(oldPath, TombstoneFile)
+	if !info.IsDir() {
+		tombstone = oldPath + "." + TombstoneFile
+	}
+	if err := os.WriteFile(tombstone, tombstoneMarkdown(item), SecureFileMode); err != nil {
+		return item, err
+	}
+
+	logger(fmt.Sprintf("The %s %s (%s) is gone from Trello (%s), %s: %s", item.Type, item.Name, item.ID, item.Reason, item.Action, item.Path), "info", true, false, config, append(fields, LogFieldOp, "deleted")...)
+
+	return item, nil
+}
+
+/*
+mergeMove moves oldPath to newPath.  Directories already there are merged (a gone list
+into the DELETED/ directory its cards were just moved to), anything else is replaced by the newer copy.
+*/
+func mergeMove(oldPath string, newPath string) error {
+
+	oldInfo, err := os.Stat(oldPath)
+	if err != nil {
+		return err
+	}
+	newInfo, err := os.Stat(newPath)
+	if err != nil {
+		return os.Rename(oldPath, newPath)
+	}
+
+	if oldInfo.IsDir() && newInfo.IsDir() {
+		entries, err := os.ReadDir(oldPath)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := mergeMove(filepath.Join(oldPath, e.Name()), filepath.Join(newPath, e.Name())); err != nil {
+				return err
+			}
+		}
+		return os.Remove(oldPath)
+	}
+
+	if err := os.RemoveAll(newPath); err != nil {
+		return err
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+func tombstoneMarkdown(item DeletedItem) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "# %s no longer in Trello\n\n", item.Name)
+	fmt.Fprintf(&buf, "- **Type:** %s\n", item.Type)
+	fmt.Fprintf(&buf, "- **ID:** %s\n", item.ID)
+	fmt.Fprintf(&buf, "- **Reason:** %s\n", item.Reason)
+	fmt.Fprintf(&buf, "- **Detected:** %s\n", item.DetectedAt.Format(time.RFC3339))
+	buf.WriteString("\nThis is the last copy dumped before it was noticed missing.\n")
+
+	return buf.Bytes()
+}
+
+/*
+BoardIndex
+
+	Which board was dumped into which directory under a storage path.  Boards that are
+	deleted in Trello stop being asked for, this is how their directories are still found.
+*/
+type BoardIndex struct {
+	Boards  map[string]BoardIndexEntry `json:"boards"`
+	Deleted []DeletedItem              `json:"deleted,omitempty"`
+}
+
+type BoardIndexEntry struct {
+	Name       string    `json:"name"`
+	Path       string    `json:"path"` // directory name under the storage path
+	LastDumped time.Time `json:"last_dumped"`
+}
+
+func loadBoardIndex(root string) *BoardIndex {
+	idx := &BoardIndex{Boards: make(map[string]BoardIndexEntry)}
+	if data, err := os.ReadFile(filepath.Join(root, BoardIndexFile)); err == nil {
+		_ = json.Unmarshal(data, idx)
+	}
+	if idx.Boards == nil {
+		idx.Boards = make(map[string]BoardIndexEntry)
+	}
+	return idx
+}
+
+func (idx *BoardIndex) save(root string) error {
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(root, BoardIndexFile), data, SecureFileMode)
+}
+
+/*
+recordBoardDir notes in the storage path's board index where a board is being dumped
+*/
+func recordBoardDir(root string, board *trello.Board, boardPath string) {
+	idx := loadBoardIndex(root)
+	idx.Boards[board.ID] = BoardIndexEntry{Name: board.Name, Path: boardPath, LastDumped: time.Now().UTC()}
+	if err := idx.save(root); err != nil {
+		logger("Error: Unable to save board index in "+root+": "+err.Error(), "err", true, false, config, LogFieldBoard, board.ID, LogFieldOp, "state")
+	}
+}
+
+/*
+retireDeletedBoards
+
+	Look at every board in the storage paths' indexes that wasn't dumped this run, and
+	apply --on-deleted to the ones Trello says are gone.  Only boards you can no longer
+	see at all count, a closed board still exists.
+*/
+func retireDeletedBoards(roots []string, dumped []string) {
+
+	for _, root := range roots {
+		idx := loadBoardIndex(root)
+		changed := false
+
+		for id, entry := range idx.Boards {
+			if slices.Contains(dumped, id) {
+				continue
+			}
+			_, err := client.GetBoard(id, trello.Arguments{"fields": "name,closed"})
+			if err == nil || !trello.IsNotFound(err) {
+				if err != nil {
+					logger("Warning: Unable to check if board "+entry.Name+" still exists: "+err.Error(), "warn", true, false, config, LogFieldBoard, id, LogFieldOp, "deleted")
+				}
+				continue
+			}
+
+			mode := boardArgs(config.ARGS, id).OnDeleted
+			item, err := retire(DeletedItem{Type: "board", ID: id, Name: entry.Name, Path: entry.Path, Reason: "deleted"}, root, mode, LogFieldBoard, id)
+			if err != nil && !os.IsNotExist(err) {
+				logger("Error: Unable to handle deleted board "+entry.Name+": "+err.Error(), "err", true, false, config, LogFieldBoard, id, LogFieldOp, "deleted")
+				continue
+			}
+			delete(idx.Boards, id)
+			changed = true
+			if err == nil {
+				idx.Deleted = append(idx.Deleted, item)
+				reportDeletedBoard(item)
+			}
+		}
+
+		if changed {
+			if err := idx.save(root); err != nil {
+				logger("Error: Unable to save board index in "+root+": "+err.Error(), "err", true, false, config, LogFieldOp, "state")
+			}
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Download subsystem, every file trellgo saves (attachments, previews, avatars, board backgrounds) goes through download()
+const (
+	MaxDownloads        = 8                // concurrent downloads across all card workers
+	DownloadTimeout     = 30 * time.Second // to connect, to get response headers, and the longest a body may go without sending anything
+	MaxDownloadAttempts = 4                // tries per file, a partial file is resumed from where it stopped
+	PartSuffix          = ".part"          // written to while downloading, renamed when complete
+)
+
+// Returned by a download that went over its size limit
+var errTooLarge = errors.New("file is over the size limit")
+
+// Bounded pool, a slot is held for the whole of one download
+var downloadSlots = make(chan struct{}, MaxDownloads)
+
+// HTTP client for file downloads, requests are counted/timed and 429s retried.
+// No overall timeout, a big file can take as long as it needs, a stalled one is cut off by stallReader.
+var downloadClient = &http.Client{Transport: apiTransport{next: &http.Transport{
+	Proxy:                 http.ProxyFromEnvironment,
+	DialContext:           (&net.Dialer{Timeout: DownloadTimeout, KeepAlive: 30 * time.Second}).DialContext,
+	TLSHandshakeTimeout:   DownloadTimeout,
+	ResponseHeaderTimeout: DownloadTimeout,
+	IdleConnTimeout:       90 * time.Second,
+	MaxIdleConnsPerHost:   MaxDownloads,
+}}}
+
+/*
+downloadRequest
+
+	One file to fetch.  Size is what Trello says the file is, when set the finished
+	file has to be exactly that.  MaxBytes stops it with errTooLarge, 0 is no limit.
+*/
+type downloadRequest struct {
+	URL      string
+	Path     string
+	Auth     bool // send the Trello API key and token, only for files on Trello itself
+	Size     int64
+	MaxBytes int64
+}
+
+// A download failure that is worth another try
+type retryableError struct{ err error }
+
+func (e retryableError) Error() string { return e.err.Error() }
+func (e retryableError) Unwrap() error { return e.err }
+
+/*
+download
+
+	Fetch a file into Path through Path.part, which is only renamed once the whole file
+	is there (and the right size, if it is known).  A .part left by a failed try, or an
+	earlier run, is resumed with a Range request.  Network errors, stalls, 5xx and short
+	files are retried with backoff.  Returns the size of the finished file.
+*/
+func download(req downloadRequest) (int64, error) {
+
+	select {
+	case downloadSlots <- struct{}{}:
+	case <-runCtx.Done():
+		return 0, runCtx.Err()
+	}
+	defer func() { <-downloadSlots }()
+
+	logger("Downloading file from URL: "+sanitizeURLForLogging(req.URL)+" to local path: "+req.Path, "info", true, true, config)
+
+	var err error
+	for attempt := 0; attempt < MaxDownloadAttempts; attempt++ {
+		if attempt > 0 {
+			wait := time.Second << (attempt - 1)
+			logger(fmt.Sprintf("Download of %s failed (%s), retrying in %s", req.Path, err, wait), "warn", true, true, config, LogFieldOp, "download")
+			select {
+			case <-runCtx.Done():
+				return 0, runCtx.Err()
+			case <-time.After(wait):
+			}
+		}
+
+		var n int64
+		n, err = downloadOnce(req)
+		if err == nil {
+			return n, nil
+		}
+		var retry retryableError
+		if !errors.As(err, &retry) {
+			return 0, err
+		}
+	}
+
+	return 0, err
+}
+
+/*
+downloadOnce is a single try at a download, picking up from any .part already there
+*/
+func downloadOnce(req downloadRequest) (int64, error) {
+
+	partPath := req.Path + PartSuffix
+
+	var have int64
+	if info, err := os.Stat(partPath); err == nil {
+		have = info.Size()
+	}
+	// A .part bigger than the file should be is from something else
+	if (req.Size > 0 && have > req.Size) || (req.MaxBytes > 0 && have > req.MaxBytes) {
+		os.Remove(partPath)
+		have = 0
+	}
+
+	// Already all there, ie the rename failed last time
+	if have > 0 && have == req.Size {
+		return have, finishPart(partPath, req.Path)
+	}
+
+	ctx, cancel := context.WithCancel(runCtx)
+	defer cancel()
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, req.URL, nil)
+	if err != nil {
+		return 0, err
+	}
+	if req.Auth {
+		httpReq.Header.Set("Authorization", fmt.Sprintf("OAuth oauth_consumer_key=\"%s\", oauth_token=\"%s\"", config.ENV.TRELLOAPIKEY, config.ENV.TRELLOAPITOK))
+	}
+	if have > 0 {
+		httpReq.Header.Set("Range", "bytes="+strconv.FormatInt(have, 10)+"-")
+	}
+
+	resp, err := downloadClient.Do(httpReq)
+	if err != nil {
+		if runCtx.Err() != nil {
+			return 0, err
+		}
+		return 0, retryableError{err}
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE
+	total := int64(-1) // size of the whole file if the server said
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && have > 0:
+		start, size, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != have {
+			os.Remove(partPath)
+			return 0, retryableError{fmt.Errorf("server resumed at the wrong place, starting over")}
+		}
+		flags |= os.O_APPEND
+		total = size
+	case resp.StatusCode == http.StatusOK:
+		// No resume (or no .part), start over
+		flags |= os.O_TRUNC
+		have = 0
+		total = resp.ContentLength
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && have > 0:
+		// The .part doesn't fit what is there now, throw it away and go again
+		os.Remove(partPath)
+		return 0, retryableError{fmt.Errorf("partial file does not match, starting over")}
+	case resp.StatusCode >= 500:
+		return 0, retryableError{fmt.Errorf("bad status: %s", resp.Status)}
+	default:
+		return 0, fmt.Errorf("failed to download file: %s (status: %d)", sanitizeURLForLogging(req.URL), resp.StatusCode)
+	}
+
+	if req.MaxBytes > 0 && total > req.MaxBytes {
+		os.Remove(partPath)
+		return 0, errTooLarge
+	}
+
+	out, err := os.OpenFile(partPath, flags, SecureFileMode)
+	if err != nil {
+		return 0, err
+	}
+
+	var body io.Reader = newStallReader(resp.Body, cancel)
+	if req.MaxBytes > 0 {
+		body = io.LimitReader(body, req.MaxBytes-have+1)
+	}
+	n, copyErr := io.Copy(out, body)
+	if err := out.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	got := have + n
+
+	if req.MaxBytes > 0 && got > req.MaxBytes {
+		os.Remove(partPath)
+		return 0, errTooLarge
+	}
+	if copyErr != nil {
+		// What did arrive stays in the .part for the next try
+		if runCtx.Err() != nil {
+			return 0, copyErr
+		}
+		if ctx.Err() != nil {
+			copyErr = fmt.Errorf("nothing received for %s", DownloadTimeout)
+		}
+		return 0, retryableError{copyErr}
+	}
+
+	want := req.Size
+	if want <= 0 && total > 0 {
+		want = total
+	}
+	if want > 0 && got != want {
+		if got > want {
+			os.Remove(partPath)
+		}
+		return 0, retryableError{fmt.Errorf("size mismatch, got %d bytes, expected %d", got, want)}
+	}
+
+	return got, finishPart(partPath, req.Path)
+}
+
+/*
+finishPart makes a completed .part the real file
+*/
+func finishPart(partPath string, finalPath string) error {
+	if err := os.Chmod(partPath, SecureFileMode); err != nil {
+		return err
+	}
+	return os.Rename(partPath, finalPath)
+}
+
+// parseContentRange reads where a 206 response starts and the whole file size (-1 if not known) from "bytes 100-199/200"
+func parseContentRange(s string) (start int64, total int64, ok bool) {
+	s, found := strings.CutPrefix(s, "bytes ")
+	if !found {
+		return -1, -1, false
+	}
+	rng, size, found := strings.Cut(s, "/")
+	if !found {
+		return -1, -1, false
+	}
+	first, _, found := strings.Cut(rng, "-")
+	if !found {
+		return -1, -1, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return -1, -1, false
+	}
+	total, err = strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		total = -1 // "*", size not known
+	}
+	return start, total, true
+}
+
+/*
+stallReader cancels a download's request when its body goes DownloadTimeout without sending anything
+*/
+type stallReader struct {
+	r     io.Reader
+	timer *time.Timer
+}
+
+func newStallReader(r io.Reader, cancel context.CancelFunc) *stallReader {
+	return &stallReader{r: r, timer: time.AfterFunc(DownloadTimeout, cancel)}
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err != nil {
+		s.timer.Stop()
+	} else {
+		s.timer.Reset(DownloadTimeout)
+	}
+	return n, err
+}
+
+/*
+urlFileName is the file name at the end of a URL's path, fallback if it has none
+*/
+func urlFileName(fileURL string, fallback string) string {
+	u, err := url.Parse(fileURL)
+	if err != nil {
+		return fallback
+	}
+	name := path.Base(u.Path)
+	if name == "" || name == "." || name == "/" {
+		return fallback
+	}
+	return name
+}
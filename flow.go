@@ -0,0 +1,769 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adlio/trello"
+	"github.com/spf13/cobra"
+)
+
+// Written per board by the report command, into <out>/<board name>/
+const (
+	FlowReportFile    = "flow-report.md"
+	FlowCardsCSV      = "flow-cards.csv"
+	FlowListTimeCSV   = "flow-time-in-list.csv"
+	FlowThroughputCSV = "flow-throughput.csv"
+	FlowCFDCSV        = "flow-cfd.csv"
+	FlowStaleCSV      = "flow-stale.csv"
+)
+
+// Cards with no activity in this many days are stale, unless --stale-days or stale_days says otherwise
+const DefaultStaleDays = 14
+
+/*
+flowStay is a stretch of time a card spent in one list, open is the stay it is in now
+*/
+type flowStay struct {
+	List string
+	From time.Time
+	To   time.Time
+	Open bool
+}
+
+/*
+flowCard
+
+	One card's trip across the board, worked out from its history.  Zero times are unknown / not yet.
+*/
+type flowCard struct {
+	Card         *trello.Card
+	Stays        []flowStay
+	Created      time.Time
+	Started      time.Time // first time in a start list
+	Done         time.Time // start of its current stay in the done lists
+	Archived     time.Time
+	LastActivity time.Time
+}
+
+// lead is created to done, cycle is started to done, ok is false when there isn't one
+func (f flowCard) lead() (time.Duration, bool) {
+	return f.Done.Sub(f.Created), !f.Done.IsZero()
+}
+
+func (f flowCard) cycle() (time.Duration, bool) {
+	return f.Done.Sub(f.Started), !f.Done.IsZero() && !f.Started.IsZero()
+}
+
+/*
+FlowReport
+
+	A board's flow metrics.  done and start are list IDs, lists is every list ID in board order.
+*/
+type FlowReport struct {
+	History   *BoardHistory
+	Cards     []flowCard
+	ListNames map[string]string
+	lists     []string
+	done      map[string]bool
+	start     map[string]bool
+	staleDays int
+}
+
+func newReportCmd() *cobra.Command {
+	var (
+		fromJSON []string
+		opts     reportOptions
+		since    string
+		until    string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "report [boardID...]",
+		Short: "Cycle time, throughput, cumulative flow and stale card reports for boards",
+		Long: "Work out how cards flow across boards from their history of list moves: lead and cycle time per card, time spent in each list,\n" +
+			"weekly throughput into the done list(s), a cumulative flow table and cards with no recent activity.\n" +
+			"Each board gets a Markdown report and CSV files in <out>/<board name>/.  Boards come from the API (-b, a pipe or --config)\n" +
+			"or from Trello's JSON export of a board (--from-json, no API keys needed).\n" +
+			"--members adds what each member did per week, month or quarter, and the open and overdue cards assigned to them.",
+		Example: "  trellgo report -b c52d11s --done Done -o '/path/to/reports'\n" +
+			"  trellgo report -b c52d11s --start Doing --done Shipped --done Released --stale-days 30\n" +
+			"  trellgo report --from-json 'board-export.json' --done Done -o '/path/to/reports'\n" +
+			"  trellgo report -b c52d11s -b 5f3g1a2 --members --window quarter --since 2024-01-01 --anonymize",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var (
+				a      ARGS
+				boards []string
+				err    error
+			)
+			if len(fromJSON) > 0 {
+				a, err = baseArgs(cmd)
+			} else {
+				a, boards, err = buildArgs(cmd, args)
+			}
+			if err != nil {
+				return err
+			}
+			if a, err = applyReportFlags(a); err != nil {
+				return err
+			}
+			if !slices.Contains(knownWindows, opts.window) {
+				return fmt.Errorf("unknown --window %q (known: %v)", opts.window, knownWindows)
+			}
+			if since != "" {
+				if opts.since, err = time.ParseInLocation("2006-01-02", since, time.UTC); err != nil {
+					return fmt.Errorf("--since must be YYYY-MM-DD: %w", err)
+				}
+			}
+			if until != "" {
+				if opts.until, err = time.ParseInLocation("2006-01-02", until, time.UTC); err != nil {
+					return fmt.Errorf("--until must be YYYY-MM-DD: %w", err)
+				}
+				opts.until = opts.until.AddDate(0, 0, 1) // the whole of that day
+			}
+
+			// A JSON backup is read offline, so no startRun and no API keys needed
+			if len(fromJSON) > 0 {
+				config.ARGS = a
+			} else {
+				startRun(a)
+			}
+			return runFlowReport(boards, fromJSON, opts)
+		},
+	}
+	addBoardFlag(cmd)
+	cmd.Flags().StringSliceVar(&fromJSON, "from-json", nil, "Read boards from Trello JSON exports instead of the API, repeatable")
+	cmd.Flags().StringVarP(&opts.outDir, "out", "o", ".", "Directory to write the reports into, one directory per board")
+	cmd.Flags().BoolVar(&opts.members, "members", false, "Also write the member activity and workload report")
+	cmd.Flags().StringVar(&opts.window, "window", "month", "Member activity is grouped by week, month or quarter")
+	cmd.Flags().StringVar(&since, "since", "", "Only count member activity on or after this date (YYYY-MM-DD, UTC)")
+	cmd.Flags().StringVar(&until, "until", "", "Only count member activity on or before this date (YYYY-MM-DD, UTC)")
+	cmd.Flags().BoolVar(&opts.anonymize, "anonymize", false, "Show members as Member 1, Member 2, ... in the member report")
+	addReportFlags(cmd)
+	return cmd
+}
+
+/*
+reportOptions are the report command's own flags
+*/
+type reportOptions struct {
+	outDir    string
+	members   bool
+	window    string
+	since     time.Time
+	until     time.Time // exclusive, zero is no end
+	anonymize bool
+}
+
+/*
+addReportFlags adds the flags that say how a board's lists are read for the reports
+*/
+func addReportFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&flags.doneLists, "done", nil, "Name of a list cards are finished in, repeatable (default is the board's last list)")
+	cmd.Flags().StringSliceVar(&flags.startLists, "start", nil, "Name of a list that starts work on a card, repeatable (default is every list but the first and the done lists)")
+	cmd.Flags().IntVar(&flags.staleDays, "stale-days", DefaultStaleDays, "Open cards with no activity in this many days are listed as stale (0 to not list them)")
+}
+
+/*
+applyReportFlags copies the report flags given on the CLI over the config file values
+*/
+func applyReportFlags(a ARGS) (ARGS, error) {
+	if a.cliSet["done"] {
+		a.DoneLists = flags.doneLists
+	}
+	if a.cliSet["start"] {
+		a.StartLists = flags.startLists
+	}
+	if a.cliSet["stale-days"] {
+		if flags.staleDays < 0 {
+			return a, fmt.Errorf("--stale-days can't be negative")
+		}
+		a.StaleDays = flags.staleDays
+	}
+	return a, nil
+}
+
+/*
+runFlowReport
+
+	Write the flow report (and member report) for each board, from the API or JSON backups.
+	Returns an error only when no report at all could be written.
+*/
+func runFlowReport(boards []string, files []string, opts reportOptions) error {
+
+	var sources []func() (*BoardHistory, error)
+	for _, id := range boards {
+		sources = append(sources, func() (*BoardHistory, error) { return loadHistoryAPI(id) })
+	}
+	for _, f := range files {
+		sources = append(sources, func() (*BoardHistory, error) { return loadHistoryJSON(f) })
+	}
+
+	var (
+		written  int
+		activity []*ActivityReport
+		names    = newMemberNamer(opts.anonymize)
+	)
+	for _, load := range sources {
+		if runCtx.Err() != nil {
+			logger("Shutting down, not writing any more reports", "warn", true, false, config, LogFieldOp, "shutdown")
+			errorWarnOnCompletion = true
+			break
+		}
+
+		h, err := load()
+		if err != nil {
+			logger("Error: "+err.Error(), "err", true, false, config, LogFieldOp, "report")
+			errorWarnOnCompletion = true
+			continue
+		}
+
+		r := newFlowReport(h, boardArgs(config.ARGS, h.ID))
+		dir := filepath.Join(opts.outDir, SanitizePathName(h.Name))
+		dirCreate(dir)
+		err = r.write(dir)
+		if err == nil && opts.members {
+			a := newActivityReport(r, names, opts)
+			activity = append(activity, a)
+			err = a.write(dir)
+		}
+		if err != nil {
+			logger("Error: Unable to write the report for board "+h.Name+": "+err.Error(), "err", true, false, config, LogFieldBoard, h.ID, LogFieldOp, "report")
+			errorWarnOnCompletion = true
+			continue
+		}
+		logger("Report for board "+h.Name+" written to "+dir, "info", true, false, config, LogFieldBoard, h.ID, LogFieldOp, "report")
+		written++
+	}
+
+	if written == 0 {
+		return fmt.Errorf("none of the %d board(s) could be reported on", len(sources))
+	}
+
+	// Everyone across every board, for when more than one board was reported on
+	if len(activity) > 1 {
+		if err := writeActivitySummary(activity, opts.outDir); err != nil {
+			logger("Error: Unable to write the member report for all boards: "+err.Error(), "err", true, false, config, LogFieldOp, "report")
+			errorWarnOnCompletion = true
+		}
+	}
+	return nil
+}
+
+/*
+newFlowReport works out every card's stays, start and done from the board history
+*/
+func newFlowReport(h *BoardHistory, args ARGS) *FlowReport {
+
+	r := &FlowReport{
+		History:   h,
+		ListNames: make(map[string]string),
+		done:      make(map[string]bool),
+		start:     make(map[string]bool),
+		staleDays: args.StaleDays,
+	}
+
+	all := slices.Clone(h.Lists)
+	slices.SortStableFunc(all, func(a, b *trello.List) int { return cmp.Compare(a.Pos, b.Pos) })
+	for _, l := range all {
+		r.lists = append(r.lists, l.ID)
+		r.ListNames[l.ID] = l.Name
+	}
+
+	open := h.openLists()
+	r.done = r.listIDs(args.DoneLists, "--done")
+	if len(r.done) == 0 && len(open) > 0 {
+		r.done[open[len(open)-1].ID] = true
+	}
+	r.start = r.listIDs(args.StartLists, "--start")
+	if len(args.StartLists) == 0 {
+		for i, l := range open {
+			if i > 0 && !r.done[l.ID] {
+				r.start[l.ID] = true
+			}
+		}
+	}
+
+	actions := h.cardActions()
+	for _, card := range h.Cards {
+		r.Cards = append(r.Cards, r.cardFlow(card, actions[card.ID]))
+	}
+	slices.SortStableFunc(r.Cards, func(a, b flowCard) int { return a.Created.Compare(b.Created) })
+
+	return r
+}
+
+/*
+listIDs turns list names (any case) into the IDs of the lists with those names
+*/
+func (r *FlowReport) listIDs(names []string, what string) map[string]bool {
+	ids := make(map[string]bool)
+	for _, name := range names {
+		found := false
+		for id, listName := range r.ListNames {
+			if strings.EqualFold(strings.TrimSpace(listName), strings.TrimSpace(name)) {
+				ids[id] = true
+				found = true
+			}
+		}
+		if !found {
+			logger("Warning: "+what+" list \""+name+"\" is not on board "+r.History.Name, "warn", true, false, config, LogFieldBoard, r.History.ID, LogFieldOp, "report")
+		}
+	}
+	return ids
+}
+
+/*
+cardFlow
+
+	Replay a card's history (oldest first) into the lists it stayed in.  The first move says
+	where it started, a card with no history has been in its list since it was created.
+*/
+func (r *FlowReport) cardFlow(card *trello.Card, actions []*trello.Action) flowCard {
+
+	asOf := r.History.AsOf
+	f := flowCard{Card: card, Created: card.CreatedAt(), LastActivity: card.CreatedAt()}
+	if card.DateLastActivity != nil {
+		f.LastActivity = *card.DateLastActivity
+	}
+	// Never later than its first history entry, the time in the ID and the history can disagree a little
+	if len(actions) > 0 && actions[0].Date.Before(f.Created) {
+		f.Created = actions[0].Date
+	}
+
+	list := card.IDList
+	for _, a := range actions {
+		if a.Data.ListBefore != nil && a.Data.ListAfter != nil {
+			list = a.Data.ListBefore.ID
+			break
+		}
+		if isArrival(a) {
+			break
+		}
+	}
+
+	var (
+		from = f.Created
+		open = true
+	)
+	stop := func(at time.Time) {
+		if open {
+			f.Stays = append(f.Stays, flowStay{List: list, From: from, To: at})
+			open = false
+		}
+	}
+
+	for _, a := range actions {
+		d := a.Data
+		if a.Date.After(f.LastActivity) {
+			f.LastActivity = a.Date
+		}
+		switch {
+		case isArrival(a):
+			// Arrived on the board, any time before that wasn't spent here
+			if d.List != nil {
+				list = d.List.ID
+			}
+			from, open = a.Date, true
+		case a.Type == "moveCardFromBoard":
+			stop(a.Date)
+		case d.ListAfter != nil:
+			stop(a.Date)
+			list, from, open = d.ListAfter.ID, a.Date, true
+		case a.Type == "updateCard" && d.Card != nil && d.Card.Closed:
+			stop(a.Date)
+			f.Archived = a.Date
+		case a.Type == "updateCard" && d.Old != nil && d.Old.Closed:
+			from, open = a.Date, true
+			f.Archived = time.Time{}
+		}
+	}
+
+	if open {
+		if card.Closed {
+			// Archived before the history we have
+			f.Archived = f.LastActivity
+			stop(f.LastActivity)
+		} else {
+			f.Stays = append(f.Stays, flowStay{List: list, From: from, To: asOf, Open: true})
+		}
+	}
+
+	// Done is the unbroken run of done list stays it ended with
+	for i := len(f.Stays) - 1; i >= 0 && r.done[f.Stays[i].List]; i-- {
+		f.Done = f.Stays[i].From
+	}
+	for _, s := range f.Stays {
+		if r.start[s.List] && (f.Done.IsZero() || s.From.Before(f.Done)) {
+			f.Started = s.From
+			break
+		}
+	}
+
+	return f
+}
+
+/*
+isArrival is an action that put a card on the board
+*/
+func isArrival(a *trello.Action) bool {
+	switch a.Type {
+	case "createCard", "copyCard", "convertToCardFromCheckItem", "moveCardToBoard":
+		return true
+	}
+	return false
+}
+
+/*
+timeInLists is how long the card spent in each list, lists in the order it first went into them
+*/
+func (f flowCard) timeInLists() ([]string, map[string]time.Duration) {
+	var order []string
+	spent := make(map[string]time.Duration)
+	for _, s := range f.Stays {
+		if _, ok := spent[s.List]; !ok {
+			order = append(order, s.List)
+		}
+		spent[s.List] += s.To.Sub(s.From)
+	}
+	return order, spent
+}
+
+/*
+listAt is the list a card counts in at a point in time, cards archived once done stay counted as done
+*/
+func (f flowCard) listAt(t time.Time, done map[string]bool) (string, bool) {
+	for _, s := range f.Stays {
+		if !s.From.After(t) && (t.Before(s.To) || s.Open) {
+			return s.List, true
+		}
+	}
+	if n := len(f.Stays); n > 0 && !f.Archived.IsZero() && !f.Archived.After(t) && done[f.Stays[n-1].List] {
+		return f.Stays[n-1].List, true
+	}
+	return "", false
+}
+
+/*
+weeks is the Monday (UTC) of every week from the oldest card to the end of the history
+*/
+func (r *FlowReport) weeks() []time.Time {
+	if len(r.Cards) == 0 {
+		return nil
+	}
+	var out []time.Time
+	last := weekStart(r.History.AsOf)
+	for w := weekStart(r.Cards[0].Created); !w.After(last); w = w.AddDate(0, 0, 7) {
+		out = append(out, w)
+	}
+	return out
+}
+
+func weekStart(t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+}
+
+/*
+Tables, each is written as a CSV file and a Markdown table in the report
+*/
+
+func (r *FlowReport) cardsTable() ([]string, [][]string) {
+	header := []string{"card_id", "card", "list", "closed", "created", "started", "done", "lead_days", "cycle_days", "url"}
+	var rows [][]string
+	for _, f := range r.Cards {
+		lead, cycle := "", ""
+		if d, ok := f.lead(); ok {
+			lead = days(d)
+		}
+		if d, ok := f.cycle(); ok {
+			cycle = days(d)
+		}
+		rows = append(rows, []string{f.Card.ID, csvText(f.Card.Name), csvText(r.listName(f.Card.IDList)), strconv.FormatBool(f.Card.Closed),
+			reportDate(f.Created), reportDate(f.Started), reportDate(f.Done), lead, cycle, f.Card.URL})
+	}
+	return header, rows
+}
+
+func (r *FlowReport) listTimeTable() ([]string, [][]string) {
+	header := []string{"card_id", "card", "list", "days"}
+	var rows [][]string
+	for _, f := range r.Cards {
+		order, spent := f.timeInLists()
+		for _, list := range order {
+			rows = append(rows, []string{f.Card.ID, csvText(f.Card.Name), csvText(r.listName(list)), days(spent[list])})
+		}
+	}
+	return header, rows
+}
+
+func (r *FlowReport) throughputTable() ([]string, [][]string) {
+	header := []string{"week_starting", "cards_done"}
+	count := make(map[time.Time]int)
+	for _, f := range r.Cards {
+		if !f.Done.IsZero() {
+			count[weekStart(f.Done)]++
+		}
+	}
+	var rows [][]string
+	for _, w := range r.weeks() {
+		rows = append(rows, []string{w.Format("2006-01-02"), strconv.Itoa(count[w])})
+	}
+	return header, rows
+}
+
+func (r *FlowReport) cfdTable() ([]string, [][]string) {
+	weeks := r.weeks()
+	counts := make([]map[string]int, len(weeks))
+	used := make(map[string]bool)
+	for i, w := range weeks {
+		at := w.AddDate(0, 0, 7)
+		if at.After(r.History.AsOf) {
+			at = r.History.AsOf
+		}
+		counts[i] = make(map[string]int)
+		for _, f := range r.Cards {
+			if list, ok := f.listAt(at, r.done); ok {
+				if _, known := r.ListNames[list]; !known {
+					list = ""
+				}
+				counts[i][list]++
+				used[list] = true
+			}
+		}
+	}
+
+	// Lists in board order, ones never holding a card left out, cards in lists of other boards last
+	var cols []string
+	for _, id := range r.lists {
+		if used[id] {
+			cols = append(cols, id)
+		}
+	}
+	if used[""] {
+		cols = append(cols, "")
+	}
+
+	header := []string{"week_ending"}
+	for _, id := range cols {
+		header = append(header, csvText(r.listName(id)))
+	}
+	var rows [][]string
+	for i, w := range weeks {
+		row := []string{w.AddDate(0, 0, 6).Format("2006-01-02")}
+		for _, id := range cols {
+			row = append(row, strconv.Itoa(counts[i][id]))
+		}
+		rows = append(rows, row)
+	}
+	return header, rows
+}
+
+func (r *FlowReport) staleTable() ([]string, [][]string) {
+	header := []string{"card_id", "card", "list", "last_activity", "days_idle", "url"}
+	if r.staleDays == 0 {
+		return header, nil
+	}
+	cutoff := r.History.AsOf.AddDate(0, 0, -r.staleDays)
+
+	var stale []flowCard
+	for _, f := range r.Cards {
+		if !f.Card.Closed && f.Done.IsZero() && f.LastActivity.Before(cutoff) {
+			stale = append(stale, f)
+		}
+	}
+	slices.SortStableFunc(stale, func(a, b flowCard) int { return a.LastActivity.Compare(b.LastActivity) })
+
+	var rows [][]string
+	for _, f := range stale {
+		rows = append(rows, []string{f.Card.ID, csvText(f.Card.Name), csvText(r.listName(f.Card.IDList)), reportDate(f.LastActivity),
+			strconv.Itoa(int(r.History.AsOf.Sub(f.LastActivity).Hours() / 24)), f.Card.URL})
+	}
+	return header, rows
+}
+
+/*
+write puts the CSV files and the Markdown report in dir
+*/
+func (r *FlowReport) write(dir string) error {
+
+	type table struct {
+		file   string
+		title  string
+		header []string
+		rows   [][]string
+	}
+	var tables []table
+	for _, t := range []struct {
+		file, title string
+		build       func() ([]string, [][]string)
+	}{
+		{FlowThroughputCSV, "Weekly throughput into " + r.doneNames(), r.throughputTable},
+		{FlowCFDCSV, "Cumulative flow, cards in each list at the end of the week", r.cfdTable},
+		{FlowStaleCSV, fmt.Sprintf("Stale cards, no activity in %d days", r.staleDays), r.staleTable},
+		{FlowCardsCSV, "Cards", r.cardsTable},
+		{FlowListTimeCSV, "Time in each list per card (days)", r.listTimeTable},
+	} {
+		header, rows := t.build()
+		if err := writeCSVFile(filepath.Join(dir, t.file), header, rows); err != nil {
+			return err
+		}
+		tables = append(tables, table{t.file, t.title, header, rows})
+	}
+
+	h := r.History
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Flow report: %s\n\n", h.Name)
+	fmt.Fprintf(&buf, "- **Board:** %s (%s)\n", h.Name, h.ID)
+	fmt.Fprintf(&buf, "- **As of:** %s\n", h.AsOf.UTC().Format("2006-01-02 15:04 MST"))
+	fmt.Fprintf(&buf, "- **From:** %s\n", h.Source)
+	fmt.Fprintf(&buf, "- **Cards:** %d (%d done, %d archived)\n", len(r.Cards), r.countDone(), r.countArchived())
+	fmt.Fprintf(&buf, "- **Work starts in:** %s\n", r.namesOf(r.start))
+	fmt.Fprintf(&buf, "- **Done in:** %s\n\n", r.doneNames())
+
+	fmt.Fprintf(&buf, "## Lead and cycle time (days)\n\n")
+	var lead, cycle []float64
+	for _, f := range r.Cards {
+		if d, ok := f.lead(); ok {
+			lead = append(lead, d.Hours()/24)
+		}
+		if d, ok := f.cycle(); ok {
+			cycle = append(cycle, d.Hours()/24)
+		}
+	}
+	markdownTable(&buf, []string{"", "Cards", "Average", "Median", "85th percentile"}, [][]string{
+		append([]string{"Lead time (created to done)"}, statsRow(lead)...),
+		append([]string{"Cycle time (started to done)"}, statsRow(cycle)...),
+	})
+
+	fmt.Fprintf(&buf, "\n## Time in list (days)\n\n")
+	perList := make(map[string][]float64)
+	for _, f := range r.Cards {
+		_, spent := f.timeInLists()
+		for list, d := range spent {
+			perList[list] = append(perList[list], d.Hours()/24)
+		}
+	}
+	var listStats [][]string
+	for _, id := range r.lists {
+		if v, ok := perList[id]; ok {
+			listStats = append(listStats, append([]string{r.ListNames[id]}, statsRow(v)...))
+		}
+	}
+	markdownTable(&buf, []string{"List", "Cards", "Average", "Median", "85th percentile"}, listStats)
+
+	for _, t := range tables {
+		fmt.Fprintf(&buf, "\n## %s\n\n", t.title)
+		if len(t.rows) == 0 {
+			fmt.Fprintf(&buf, "None.\n")
+		} else {
+			markdownTable(&buf, t.header, t.rows)
+		}
+		fmt.Fprintf(&buf, "\nAlso in `%s`.\n", t.file)
+	}
+
+	return os.WriteFile(filepath.Join(dir, FlowReportFile), buf.Bytes(), SecureFileMode)
+}
+
+func (r *FlowReport) listName(id string) string {
+	if name, ok := r.ListNames[id]; ok {
+		return name
+	}
+	return "(another board)"
+}
+
+func (r *FlowReport) namesOf(ids map[string]bool) string {
+	var names []string
+	for _, id := range r.lists {
+		if ids[id] {
+			names = append(names, r.ListNames[id])
+		}
+	}
+	if len(names) == 0 {
+		return "(none)"
+	}
+	return strings.Join(names, ", ")
+}
+
+func (r *FlowReport) doneNames() string { return r.namesOf(r.done) }
+
+func (r *FlowReport) countDone() int {
+	n := 0
+	for _, f := range r.Cards {
+		if !f.Done.IsZero() {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *FlowReport) countArchived() int {
+	n := 0
+	for _, f := range r.Cards {
+		if f.Card.Closed {
+			n++
+		}
+	}
+	return n
+}
+
+/*
+statsRow is count, average, median and 85th percentile (nearest rank) of a set of day counts
+*/
+func statsRow(v []float64) []string {
+	if len(v) == 0 {
+		return []string{"0", "", "", ""}
+	}
+	v = slices.Clone(v)
+	slices.Sort(v)
+	sum := 0.0
+	for _, x := range v {
+		sum += x
+	}
+	median := v[len(v)/2]
+	if len(v)%2 == 0 {
+		median = (v[len(v)/2-1] + v[len(v)/2]) / 2
+	}
+	p85 := v[(len(v)*85+99)/100-1]
+	return []string{strconv.Itoa(len(v)), fmt.Sprintf("%.1f", sum/float64(len(v))), fmt.Sprintf("%.1f", median), fmt.Sprintf("%.1f", p85)}
+}
+
+/*
+markdownTable writes a Markdown table, pipes and line breaks in cells are escaped
+*/
+func markdownTable(buf *bytes.Buffer, header []string, rows [][]string) {
+	cell := strings.NewReplacer("|", `\|`, "\r\n", " ", "\n", " ")
+	line := func(cells []string) {
+		buf.WriteString("|")
+		for _, c := range cells {
+			buf.WriteString(" " + cell.Replace(c) + " |")
+		}
+		buf.WriteString("\n")
+	}
+	line(header)
+	buf.WriteString("|" + strings.Repeat(" --- |", len(header)) + "\n")
+	for _, row := range rows {
+		line(row)
+	}
+}
+
+// days is a duration in days to one decimal place
+func days(d time.Duration) string {
+	return fmt.Sprintf("%.1f", d.Hours()/24)
+}
+
+// reportDate is a date for the reports, empty when not set
+func reportDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format("2006-01-02")
+}
@@ -3,18 +3,15 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"flag"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
-	"net/url"
 	"os"
-	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/adlio/trello"
 	"github.com/jedib0t/go-pretty/v6/table"
@@ -24,91 +21,50 @@ import (
 
 type ARGS struct {
 	Archived         bool
-	ListLabelIDs     bool
-	ListTotalCards   bool
 	SeparateArchived bool
+	Ordered          bool     // prefix list and card directories with their Trello position
+	OnDeleted        string   // move or tombstone cards, lists and boards gone from Trello
+	Previews         bool     // also download the largest preview Trello has of each attachment
+	MaxAttachmentMB  int      // skip attachments bigger than this, 0 is no limit
+	BoardAttachMB    int      // most attachment megabytes downloaded per board per run, 0 is no limit
+	AttachmentAllow  []string // only download attachments of these MIME types/extensions
+	AttachmentDeny   []string // never download attachments of these MIME types/extensions
 	SuperQuiet       bool
 	LoggingEnabled   bool
 	StoragePath      string
 	LabelID          string
 	LogFile          string
+	LogLevel         string
+	LogFormat        string
+	LogMaxSize       int // megabytes before the log file is rotated
+	LogMaxAge        int // days to keep rotated log files
+	LogMaxBackups    int
+	LogCompress      bool
+	Syslog           bool
+	ReportFile       string
+	ReportMarkdown   string
+	MetricsTextfile  string
+	ConfigFile       string
+	Formats          []string
+	CSVChecklists    bool     // with the csv format, also write checklist_items.csv
+	CSVComments      bool     // with the csv format, also write comments.csv
+	MigrationMap     string   // with the jira, github and gitlab formats, the list/member mapping file
+	DoneLists        []string // report: names of the lists cards are finished in
+	StartLists       []string // report: names of the lists work on a card starts in
+	StaleDays        int      // report: open cards idle this long are stale, 0 to not list them
+
+	cliSet     map[string]bool // flags explicitly set on the CLI, these beat the config file
+	fileConfig *FileConfig
 }
 
 type ENV struct {
-	TRELLOAPIKEY string
-	TRELLOAPITOK string
-	TRELLOAPIURL string
-}
-
-/*
-getCLIArgs
-
-	Get CLI arguments and flags
-*/
-func getCLIArgs() (config ARGS, boards []string) {
-
-	var (
-		// CLI Flags
-		Archived         = flag.Bool("a", false, "")
-		BoardID          = flag.String("b", "", "")
-		ListTotalCards   = flag.Bool("count", false, "")
-		LabelID          = flag.String("l", "", "")
-		ListLabelIDs     = flag.Bool("labels", false, "")
-		LogFile          = flag.String("logs", "", "")
-		Loud             = flag.Bool("loud", false, "")
-		QQ               = flag.Bool("qq", false, "")
-		StoragePath      = flag.String("s", "", "n")
-		SeparateArchived = flag.Bool("split", false, "")
-		ver              = flag.Bool("v", false, "")
-	)
-
-	// Handle -h help
-	flag.Usage = func() { printHelp(version) }
-
-	// Parse CLI flags
-	flag.Parse()
-
-	// Set config values
-	config.Archived = *Archived
-	config.LabelID = *LabelID
-	config.ListLabelIDs = *ListLabelIDs
-	config.ListTotalCards = *ListTotalCards
-	config.StoragePath = *StoragePath
-	config.SeparateArchived = *SeparateArchived
-	config.SuperQuiet = *QQ
-	config.LogFile = *LogFile
-
-	ListLoud = *Loud
-
-	// Handle -v version
-	if *ver {
-		fmt.Printf("trellgo v%s\n", version)
-		os.Exit(0)
-	}
-
-	// Check if we need to use STDIN (Pipe) or -b for BoardIDs
-	boards, err := getBoardIDs(*BoardID, os.Stdin)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n\n", err)
-		flag.Usage()
-		os.Exit(1)
-	}
-
-	// Check for required flag of Storage Path if not using -labels or -count
-	if !*ListLabelIDs && !*ListTotalCards && *StoragePath == "" {
-		fmt.Println("Error: No Storage Path provided. REQUIRED")
-		printHelp(version)
-		os.Exit(1)
-	}
-
-	// Searching on a specific Label will not allow search of archives, need to inform user
-	if *LabelID != "" && *Archived {
-		fmt.Println("Error: Cannot use -l flag with -a flag. Use -l without -a to filter by label ID")
-		printHelp(version)
-		os.Exit(1)
-	}
-
-	return config, boards
+	TRELLOAPIKEY    string
+	TRELLOAPITOK    string
+	TRELLOAPIURL    string
+	TRELLOAPISECRET string // only needed to check webhook signatures
+	SMTPPASSWORD    string // for email notifications
+	SSHPASSPHRASE   string // for an encrypted sftp key_file
+	WEBDAVPASSWORD  string // for webdav shipping (a Nextcloud app password)
 }
 
 /*
@@ -131,6 +87,10 @@ func getOSENV() (config ENV) {
 	config.TRELLOAPIKEY = os.Getenv("TRELLGO_APIKEY")
 	config.TRELLOAPITOK = os.Getenv("TRELLGO_APITOK")
 	config.TRELLOAPIURL = os.Getenv("TRELLGO_APIURL")
+	config.TRELLOAPISECRET = os.Getenv("TRELLGO_APISECRET")
+	config.SMTPPASSWORD = os.Getenv("TRELLGO_SMTP_PASSWORD")
+	config.SSHPASSPHRASE = os.Getenv("TRELLGO_SSH_PASSPHRASE")
+	config.WEBDAVPASSWORD = os.Getenv("TRELLGO_WEBDAV_PASSWORD")
 
 	if config.TRELLOAPIKEY == "" || config.TRELLOAPITOK == "" {
 		fmt.Println("Error: No Trello API Key or Token provided in OS Environment")
@@ -144,10 +104,10 @@ func getOSENV() (config ENV) {
 /*
 getBoardIDs
 
-	Get Board IDs from CLI flag or stdin
-	If the -b flag is used, it will return that ID.
+	Get Board IDs from CLI args/flags or stdin
+	If board IDs were given on the CLI (-b or as arguments), those are used.
 */
-func getBoardIDs(boardFlag string, stdin io.Reader) ([]string, error) {
+func getBoardIDs(boardFlags []string, stdin io.Reader) ([]string, error) {
 	fi, err := os.Stdin.Stat()
 	if err != nil {
 		return nil, err
@@ -158,7 +118,7 @@ func getBoardIDs(boardFlag string, stdin io.Reader) ([]string, error) {
 	if fi.Mode()&os.ModeCharDevice == 0 {
 		scanner := bufio.NewScanner(stdin)
 		for scanner.Scan() {
-			if line := scanner.Text(); line != "" {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
 				ids = append(ids, line)
 			}
 		}
@@ -167,50 +127,17 @@ func getBoardIDs(boardFlag string, stdin io.Reader) ([]string, error) {
 		}
 	}
 
-	// if nothing came in on stdin, use the -b flag
+	// if nothing came in on stdin, use the -b flag / arguments
 	if len(ids) == 0 {
-		if boardFlag == "" {
-			return nil, fmt.Errorf("no board IDs provided (pipe them in or use -b)")
+		if len(boardFlags) == 0 {
+			return nil, fmt.Errorf("no board IDs provided (pipe them in, use -b, or list them in -config)")
 		}
-		ids = append(ids, boardFlag)
+		ids = append(ids, boardFlags...)
 	}
 
 	return ids, nil
 }
 
-/*
-printHelp
-
-	Prints help menu when -h is used on CLI
-*/
-func printHelp(version string) {
-	fmt.Printf("\ttrellgo v%s by srv1054 (github.com/srv1054/trellgo)\n", version)
-	fmt.Println()
-	fmt.Println("Usage: ./trellgo [options]")
-	fmt.Println("Options:")
-	fmt.Printf("  -a\t\tInclude archived cards in dump\n")
-	fmt.Printf("  -b\t\tTrello board to dump BoardID or PIPE (|) IDs in one per line. (REQUIRED if not piping from STDIN)\n")
-	fmt.Printf("  -count\tList total number of cards in the board\n")
-	fmt.Printf("  -l\t\tOnly include cards with this label NAME (Does not work with -a flag. Requires NAME of label \"in quotes\", not ID)\n")
-	fmt.Printf("  -labels\tRetrieve boards list of Label IDs\n")
-	fmt.Printf("  -loud\t\tEnable more verbose output\n")
-	fmt.Printf("  -logs \"file\"\tSpecifies a log file to send all output. Off by default, if enabled, its not effected by -loud or -qq parameters.\n")
-	fmt.Printf("  -qq\t\tSuppress ALL console output.  Super Quiet mode.  Does not effect logging, just console.  Does not apply to -labels or -count\n")
-	fmt.Printf("  -s\t\tRoot Level path to store board information (REQUIRED)\n")
-	fmt.Printf("  -split\tSeparate archived cards into their own directory (instead of mixed in and labeled with -ARCHIVED)\n")
-	fmt.Printf("  -v\t\tPrints version and exits\n")
-	fmt.Println()
-	fmt.Println("Console output is minimal by default, with high level messages.  Use -loud to enable more verbose output.  Errors always print to console.")
-	fmt.Println()
-	fmt.Printf("Example: trellgo -b c52d11s -l ff3sg135 -s '/path/to/here'\n")
-	fmt.Printf("Example: trellgo -b c52d11s -a -split -s '/path/to/here'\n")
-	fmt.Printf("Example: trellgo -b c52d11s -s '/path/to/here' -logs '/path/file.log'\n")
-	fmt.Printf("Example: trellgo -b t532aad -labels\n")
-	fmt.Printf("Example: trellgo -b 5f3g1a2 -count\n")
-	fmt.Println()
-	os.Exit(0)
-}
-
 // Secure directory permissions - owner access only
 const SecureDirMode = 0700
 
@@ -321,20 +248,31 @@ func SanitizePathName(name string) string {
 
 	// Ensure it is not empty
 	if cleaned == "" {
-		logger("Requested path name "+name+" is empty after sanitization", "error", true, false, config)
+		logger("Requested path name "+name+" is empty after sanitization", "err", true, false, config)
 		cleaned = fmt.Sprintf("Board-Was-Illegal-Characters-%s", time.Now().Format("20060102-150405"))
 		logger("Using fallback name: "+cleaned, "info", true, false, config)
 	}
 
 	// Limit length to 240 characters
 	// This leaves 15 chars for outside func appends without cause panic "filename to long"
-	if len(cleaned) > 240 {
-		cleaned = cleaned[:240]
-	}
+	cleaned = truncateName(cleaned, 240)
 
 	return cleaned
 }
 
+/*
+truncateName cuts name to at most n bytes without splitting a multi-byte character
+*/
+func truncateName(name string, n int) string {
+	if len(name) <= n {
+		return name
+	}
+	for n > 0 && !utf8.RuneStart(name[n]) {
+		n--
+	}
+	return name[:n]
+}
+
 /*
 isReservedName checks for Windows reserved filenames that could cause issues
 */
@@ -349,117 +287,18 @@ func isReservedName(name string) bool {
 	return false
 }
 
-/*
-downLoadFile
-
-	Download a remote file to the local drive for certain types of attachments, like board background images
-*/
-func downLoadFile(fileURL string, localFilePath string) error {
-
-	var (
-		filePath string
-	)
-
-	u, err := url.Parse(fileURL)
-	if err != nil {
-		logger("Fatal Download attempt, invalid URL. Err: "+err.Error(), "err", true, true, config)
-		return err
-	}
-
-	// Extract filename from the URL
-	fileName := path.Base(u.Path)
-	if fileName == "" {
-		filePath = localFilePath + "UnknownFile"
-	} else {
-		filePath = localFilePath + fileName
-	}
-
-	logger("Downloading file named "+fileName+" from URL: "+sanitizeURLForLogging(fileURL)+" to local path: "+filePath, "info", true, true, config)
-
-	// Create the file
-	out, err := os.Create(filePath)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-
-	// Get the data
-	resp, err := http.Get(fileURL)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	// Check server response
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status: %s", resp.Status)
-	}
-
-	// Write the body to file
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		return err
-	}
-	logger("Downloaded: "+filePath, "info", true, true, config)
-
-	return nil
-}
-
 /*
 sanitizeURLForLogging removes sensitive credentials from URLs before logging
 Prevents API keys and tokens from appearing in logs
 */
 func sanitizeURLForLogging(url string) string {
 	// Remove key and token parameters from URLs
-	re := regexp.MustCompile(`(key|token)=[^&\s]*`)
+	re := regexp.MustCompile(`(key|token)=[^&\s"]*`)
 	sanitized := re.ReplaceAllString(url, "$1=***")
 
 	// Also handle OAuth-style credentials in URLs
-	re2 := regexp.MustCompile(`oauth_[^=]*=[^&\s]*`)
+	re2 := regexp.MustCompile(`oauth_[^=]*=[^&\s"]*`)
 	sanitized = re2.ReplaceAllString(sanitized, "oauth_***=***")
 
 	return sanitized
 }
-
-/*
-downloadFileAuthHeader
-
-	Download file from URL to local file system when trello requires API authentication, likfe files attached to cards (PDF, etc)
-*/
-func downloadFileAuthHeader(fileURL string, localFilePath string, apiKey string, apiToken string) error {
-
-	logger("Downloading file from URL: "+sanitizeURLForLogging(fileURL)+" to local path: "+localFilePath, "info", true, true, config)
-
-	// Create a new HTTP request with Authorization header
-	req, err := http.NewRequest("GET", fileURL, nil)
-	if err != nil {
-		return err
-	}
-
-	// Add Authorization token
-	req.Header.Set("Authorization", fmt.Sprintf("OAuth oauth_consumer_key=\"%s\", oauth_token=\"%s\"", apiKey, apiToken))
-
-	// Execute the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	// Check if the response is OK
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to download file: %s (status: %d)", fileURL, resp.StatusCode)
-	}
-
-	// Create the file
-	out, err := os.Create(localFilePath)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-
-	// Copy the response body to the file
-	_, err = io.Copy(out, resp.Body)
-	return err
-}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/adlio/trello"
+)
+
+// Board actions the reports are built from, the card history that moves, archives and creates cards, comments and ticked checklist items
+const historyActionFilter = "createCard,copyCard,convertToCardFromCheckItem,moveCardToBoard,moveCardFromBoard,updateCard:idList,updateCard:closed,commentCard,updateCheckItemStateOnCard"
+
+// Most actions Trello returns per request, older ones are paged with before=
+const historyPageSize = 1000
+
+/*
+BoardHistory
+
+	Everything a report needs about one board.  Loaded from the API, or from the JSON
+	backup Trello makes of a board (Menu > Print, export and share > Export as JSON).
+	Actions are oldest first.
+*/
+type BoardHistory struct {
+	ID      string           `json:"id"`
+	Name    string           `json:"name"`
+	URL     string           `json:"url"`
+	Lists   []*trello.List   `json:"lists"`
+	Cards   []*trello.Card   `json:"cards"`
+	Members []BoardMember    `json:"members"`
+	Actions []*trello.Action `json:"actions"`
+
+	AsOf   time.Time `json:"-"` // when the data is from, now for the API or the newest date in a backup
+	Source string    `json:"-"` // the board ID or the backup file
+}
+
+/*
+loadHistoryAPI fetches a board's lists, cards (archived too), members and card history
+*/
+func loadHistoryAPI(boardID string) (*BoardHistory, error) {
+
+	board, err := client.GetBoard(boardID, trello.Defaults())
+	if err != nil {
+		return nil, fmt.Errorf("getting board %s: %w", boardID, err)
+	}
+
+	h := &BoardHistory{ID: board.ID, Name: board.Name, URL: board.URL, AsOf: time.Now(), Source: boardID}
+
+	if h.Lists, err = board.GetLists(trello.Arguments{"filter": "all"}); err != nil {
+		return nil, fmt.Errorf("getting lists for board %s: %w", board.Name, err)
+	}
+	if h.Cards, err = board.GetCards(trello.Arguments{"filter": "all"}); err != nil {
+		return nil, fmt.Errorf("getting cards for board %s: %w", board.Name, err)
+	}
+	if h.Members, err = fetchBoardMembers(board, client); err != nil {
+		return nil, fmt.Errorf("getting members for board %s: %w", board.Name, err)
+	}
+
+	// Newest first a page at a time, until a short page
+	before := ""
+	for {
+		args := trello.Arguments{"filter": historyActionFilter, "limit": fmt.Sprint(historyPageSize)}
+		if before != "" {
+			args["before"] = before
+		}
+		page, err := board.GetActions(args)
+		if err != nil {
+			return nil, fmt.Errorf("getting history for board %s: %w", board.Name, err)
+		}
+		h.Actions = append(h.Actions, page...)
+		if len(page) < historyPageSize {
+			break
+		}
+		if runCtx.Err() != nil {
+			return nil, runCtx.Err()
+		}
+		before = page[len(page)-1].ID
+	}
+	logger(fmt.Sprintf("Fetched %d lists, %d cards and %d history entries for board %s", len(h.Lists), len(h.Cards), len(h.Actions), board.Name), "info", true, true, config, LogFieldBoard, board.ID, LogFieldOp, "history")
+
+	h.sortActions()
+	return h, nil
+}
+
+/*
+loadHistoryJSON reads a board from a Trello JSON backup.  Trello only puts the newest 1000 actions in one.
+*/
+func loadHistoryJSON(fileName string) (*BoardHistory, error) {
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var h BoardHistory
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil, fmt.Errorf("reading %s, is it a Trello board JSON export? %w", fileName, err)
+	}
+	if h.ID == "" {
+		return nil, fmt.Errorf("%s has no board ID, is it a Trello board JSON export?", fileName)
+	}
+	h.Source = fileName
+	if len(h.Actions) >= historyPageSize {
+		logger(fmt.Sprintf("Warning: %s has %d history entries, Trello's limit for a backup, anything older is missing", fileName, len(h.Actions)), "warn", true, false, config, LogFieldBoard, h.ID, LogFieldOp, "history")
+	}
+
+	// Reported as of when the backup was taken
+	for _, c := range h.Cards {
+		if c.DateLastActivity != nil && c.DateLastActivity.After(h.AsOf) {
+			h.AsOf = *c.DateLastActivity
+		}
+	}
+	for _, a := range h.Actions {
+		if a.Date.After(h.AsOf) {
+			h.AsOf = a.Date
+		}
+	}
+
+	h.sortActions()
+	return &h, nil
+}
+
+/*
+sortActions puts the actions oldest first, Trello gives them newest first
+*/
+func (h *BoardHistory) sortActions() {
+	slices.Reverse(h.Actions)
+	slices.SortStableFunc(h.Actions, func(a, b *trello.Action) int { return a.Date.Compare(b.Date) })
+}
+
+/*
+cardActions groups the actions by card ID, oldest first
+*/
+func (h *BoardHistory) cardActions() map[string][]*trello.Action {
+	byCard := make(map[string][]*trello.Action)
+	for _, a := range h.Actions {
+		if a.Data != nil && a.Data.Card != nil {
+			byCard[a.Data.Card.ID] = append(byCard[a.Data.Card.ID], a)
+		}
+	}
+	return byCard
+}
+
+/*
+openLists is the board's lists in board order, open ones only
+*/
+func (h *BoardHistory) openLists() []*trello.List {
+	var lists []*trello.List
+	for _, l := range h.Lists {
+		if !l.Closed {
+			lists = append(lists, l)
+		}
+	}
+	slices.SortStableFunc(lists, func(a, b *trello.List) int { return cmp.Compare(a.Pos, b.Pos) })
+	return lists
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adlio/trello"
+)
+
+// Written in each board directory with --format ics, and in the storage path with every board's entries
+const (
+	CalendarFile         = "calendar.ics"
+	CombinedCalendarFile = "trellgo.ics"
+)
+
+// Calendar apps want these as-is, times are written in UTC
+const (
+	icsTimeFormat = "20060102T150405Z"
+	icsLineLimit  = 75 // octets per line before folding, RFC 5545 3.1
+)
+
+/*
+icsExport
+
+	A board's calendar.  Card due dates and checklist item due dates are to-dos (VTODO)
+	that carry whether they are complete, start dates are events (VEVENT), running to the
+	due date when the card has one.  Written once the whole board has been processed.
+*/
+type icsExport struct {
+	mu      sync.Mutex
+	dir     string
+	lists   map[string]*trello.List
+	entries []icsEntry
+}
+
+// One VEVENT or VTODO, sorted by when
+type icsEntry struct {
+	uid   string
+	at    time.Time
+	lines []string // the component, BEGIN to END, unfolded
+}
+
+/*
+newICSExport is nil when the ics format isn't on for the board
+*/
+func newICSExport(config Config, boardDir string, lists map[string]*trello.List) *icsExport {
+	if !hasFormat(config.ARGS, "ics") {
+		return nil
+	}
+	return &icsExport{dir: boardDir, lists: lists}
+}
+
+/*
+addCard
+
+	Add a card's dates.  raw is the card JSON from getComprehensiveCardData, checklist
+	item due dates are only in there, nil (link cards, or the fetch failed) leaves them out.
+*/
+func (c *icsExport) addCard(card *trello.Card, raw []byte) {
+	if c == nil {
+		return
+	}
+
+	listName := ""
+	if list, ok := c.lists[card.IDList]; ok {
+		listName = list.Name
+	}
+	stamp := card.CreatedAt()
+	if card.DateLastActivity != nil {
+		stamp = *card.DateLastActivity
+	}
+	var desc []string
+	if card.Closed {
+		desc = append(desc, "Archived card")
+	}
+	if listName != "" {
+		desc = append(desc, "List: "+listName)
+	}
+	if card.URL != "" {
+		desc = append(desc, card.URL)
+	}
+	// What every entry for the card ends with
+	about := func(extra ...string) []string {
+		lines := []string{"DESCRIPTION:" + icsText(strings.Join(append(extra, desc...), "\n"))}
+		if listName != "" {
+			lines = append(lines, "CATEGORIES:"+icsText(listName))
+		}
+		if card.URL != "" {
+			lines = append(lines, "URL:"+card.URL)
+		}
+		return lines
+	}
+
+	var entries []icsEntry
+	if card.Start != nil {
+		lines := []string{
+			"BEGIN:VEVENT",
+			"UID:" + card.ID + "-start@trellgo",
+			"DTSTAMP:" + icsTime(stamp),
+			"DTSTART:" + icsTime(*card.Start),
+		}
+		if card.Due != nil && card.Due.After(*card.Start) {
+			lines = append(lines, "DTEND:"+icsTime(*card.Due))
+		}
+		lines = append(append(lines, "SUMMARY:"+icsText("Start: "+card.Name)), about()...)
+		lines = append(lines, "END:VEVENT")
+		entries = append(entries, icsEntry{uid: card.ID + "-start", at: *card.Start, lines: lines})
+	}
+	if card.Due != nil {
+		lines := []string{
+			"BEGIN:VTODO",
+			"UID:" + card.ID + "-due@trellgo",
+			"DTSTAMP:" + icsTime(stamp),
+		}
+		if card.Start != nil && card.Start.Before(*card.Due) {
+			lines = append(lines, "DTSTART:"+icsTime(*card.Start))
+		}
+		lines = append(lines, "DUE:"+icsTime(*card.Due), "SUMMARY:"+icsText(card.Name))
+		lines = append(append(lines, about()...), icsStatus(card.DueComplete, card.Closed)...)
+		lines = append(lines, "END:VTODO")
+		entries = append(entries, icsEntry{uid: card.ID + "-due", at: *card.Due, lines: lines})
+	}
+
+	dues := checkItemDues(raw)
+	for _, cl := range card.Checklists {
+		for _, item := range cl.CheckItems {
+			due, ok := dues[item.ID]
+			if !ok {
+				continue
+			}
+			lines := []string{
+				"BEGIN:VTODO",
+				"UID:" + item.ID + "-due@trellgo",
+				"DTSTAMP:" + icsTime(stamp),
+				"DUE:" + icsTime(due),
+				"SUMMARY:" + icsText(item.Name+" ("+card.Name+")"),
+			}
+			lines = append(append(lines, about("Checklist: "+cl.Name)...), icsStatus(item.State == "complete", card.Closed)...)
+			if card.Due != nil {
+				lines = append(lines, "RELATED-TO:"+card.ID+"-due@trellgo")
+			}
+			lines = append(lines, "END:VTODO")
+			entries = append(entries, icsEntry{uid: item.ID + "-due", at: due, lines: lines})
+		}
+	}
+
+	c.mu.Lock()
+	c.entries = append(c.entries, entries...)
+	c.mu.Unlock()
+}
+
+/*
+write
+
+	Write the board's calendar.  Called once every card has been added, a run that
+	stopped part way leaves the calendar from the last complete run in place.
+*/
+func (c *icsExport) write(board *trello.Board, config Config) {
+	if c == nil {
+		return
+	}
+	if runCtx.Err() != nil {
+		logger("Shutting down, not rewriting the calendar for board "+board.Name, "warn", true, false, config, LogFieldBoard, board.ID, LogFieldOp, "ics")
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	slices.SortStableFunc(c.entries, func(a, b icsEntry) int {
+		return cmp.Or(a.at.Compare(b.at), cmp.Compare(a.uid, b.uid))
+	})
+	var components [][]string
+	for _, e := range c.entries {
+		components = append(components, e.lines)
+	}
+
+	fileName := filepath.Join(c.dir, CalendarFile)
+	if err := os.WriteFile(fileName, icsCalendar(board.Name, components), SecureFileMode); err != nil {
+		logger("CRITICAL - Unable to write "+fileName+" Error: "+err.Error(), "err", true, false, config, LogFieldBoard, board.ID, LogFieldOp, "ics")
+		errorWarnOnCompletion = true
+		return
+	}
+	logger(fmt.Sprintf("Wrote %d calendar entries to %s", len(c.entries), fileName), "info", true, true, config, LogFieldBoard, board.ID, LogFieldOp, "ics")
+}
+
+/*
+writeCombinedCalendars
+
+	Put every board's calendar together into trellgo.ics in each storage path.  Boards
+	come from the board index, so ones not dumped this run are still in it, a board
+	without a calendar.ics (ics isn't on for it) is left out.
+*/
+func writeCombinedCalendars(roots []string) {
+
+	for _, root := range roots {
+		idx := loadBoardIndex(root)
+		entries := slices.Collect(maps.Values(idx.Boards))
+		slices.SortFunc(entries, func(a, b BoardIndexEntry) int { return cmp.Compare(a.Path, b.Path) })
+
+		var (
+			components [][]string
+			boards     int
+		)
+		for _, entry := range entries {
+			data, err := os.ReadFile(filepath.Join(root, entry.Path, CalendarFile))
+			if err != nil {
+				continue
+			}
+			components = append(components, icsComponents(data)...)
+			boards++
+		}
+		if boards == 0 {
+			continue
+		}
+
+		fileName := filepath.Join(root, CombinedCalendarFile)
+		if err := os.WriteFile(fileName, icsCalendar("Trello", components), SecureFileMode); err != nil {
+			logger("Error: Unable to write "+fileName+": "+err.Error(), "err", true, false, config, LogFieldOp, "ics")
+			errorWarnOnCompletion = true
+			continue
+		}
+		logger(fmt.Sprintf("Wrote %d calendar entries from %d board(s) to %s", len(components), boards, fileName), "info", true, true, config, LogFieldOp, "ics")
+	}
+}
+
+/*
+checkItemDues reads the checklist item due dates the Trello client drops out of the raw card JSON
+*/
+func checkItemDues(raw []byte) map[string]time.Time {
+	var card struct {
+		Checklists []struct {
+			CheckItems []struct {
+				ID  string     `json:"id"`
+				Due *time.Time `json:"due"`
+			} `json:"checkItems"`
+		} `json:"checklists"`
+	}
+	if len(raw) == 0 || json.Unmarshal(raw, &card) != nil {
+		return nil
+	}
+
+	dues := make(map[string]time.Time)
+	for _, cl := range card.Checklists {
+		for _, item := range cl.CheckItems {
+			if item.Due != nil {
+				dues[item.ID] = *item.Due
+			}
+		}
+	}
+	return dues
+}
+
+/*
+icsCalendar wraps components in a VCALENDAR, folded with CRLF line endings
+*/
+func icsCalendar(name string, components [][]string) []byte {
+	var buf bytes.Buffer
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//trellgo//trellgo " + version + "//EN",
+		"CALSCALE:GREGORIAN",
+		"X-WR-CALNAME:" + icsText(name),
+	}
+	for _, c := range components {
+		lines = append(lines, c...)
+	}
+	lines = append(lines, "END:VCALENDAR")
+
+	for _, l := range lines {
+		buf.WriteString(icsFold(l))
+		buf.WriteString("\r\n")
+	}
+	return buf.Bytes()
+}
+
+/*
+icsComponents reads the VEVENT/VTODO components back out of a calendar icsCalendar wrote, unfolded
+*/
+func icsComponents(data []byte) [][]string {
+	unfolded := strings.ReplaceAll(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n ", "")
+
+	var (
+		components [][]string
+		current    []string
+	)
+	for _, l := range strings.Split(unfolded, "\n") {
+		switch {
+		case l == "BEGIN:VEVENT" || l == "BEGIN:VTODO":
+			current = []string{l}
+		case current == nil:
+		case l == "END:VEVENT" || l == "END:VTODO":
+			components = append(components, append(current, l))
+			current = nil
+		default:
+			current = append(current, l)
+		}
+	}
+	return components
+}
+
+// icsStatus is a to-do's STATUS, COMPLETED, or CANCELLED when the card was archived before it was
+func icsStatus(complete bool, archived bool) []string {
+	switch {
+	case complete:
+		return []string{"STATUS:COMPLETED", "PERCENT-COMPLETE:100"}
+	case archived:
+		return []string{"STATUS:CANCELLED"}
+	default:
+		return []string{"STATUS:NEEDS-ACTION"}
+	}
+}
+
+// icsTime is a time as an iCalendar UTC date-time
+func icsTime(t time.Time) string {
+	return t.UTC().Format(icsTimeFormat)
+}
+
+// icsText escapes a TEXT value, RFC 5545 3.3.11
+func icsText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", "").Replace(s)
+}
+
+/*
+icsFold splits a content line longer than 75 octets, each continuation starts with a space.
+Never splits a UTF-8 character.
+*/
+func icsFold(line string) string {
+	if len(line) <= icsLineLimit {
+		return line
+	}
+
+	var b strings.Builder
+	limit := icsLineLimit
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		limit = icsLineLimit - 1 // the leading space counts
+	}
+	b.WriteString(line)
+	return b.String()
+}
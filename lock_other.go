@@ -0,0 +1,32 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+/*
+acquireLock
+
+	Take an exclusive lock on fileName so two runs never overlap.
+	No flock here, so the file itself is the lock and is removed on release.
+*/
+func acquireLock(fileName string) (release func(), err error) {
+
+	f, err := os.OpenFile(fileName, os.O_CREATE|os.O_EXCL|os.O_WRONLY, SecureFileMode)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, fmt.Errorf("%s exists, another trellgo run is in progress (remove it if that run died)", fileName)
+		}
+		return nil, err
+	}
+	_, _ = f.WriteString(strconv.Itoa(os.Getpid()) + "\n")
+	f.Close()
+
+	return func() {
+		_ = os.Remove(fileName)
+	}, nil
+}
@@ -0,0 +1,38 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+/*
+acquireLock
+
+	Take an exclusive, non blocking lock on fileName so two runs never overlap.
+	The lock is released by the kernel if the process dies, so a stale file never blocks a run.
+*/
+func acquireLock(fileName string) (release func(), err error) {
+
+	f, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR, SecureFileMode)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s is locked by another trellgo run", fileName)
+	}
+
+	// PID is only informational, the flock is what matters
+	_ = f.Truncate(0)
+	_, _ = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
@@ -1,26 +1,40 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
-	"os"
+	"io"
+	"log/slog"
+	"strings"
+
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-var (
-	WarningLogger *log.Logger
-	InfoLogger    *log.Logger
-	ErrorLogger   *log.Logger
+// Structured logger for the log file and syslog, nil until startLog succeeds
+var fileLogger *slog.Logger
+
+// Field names used on structured log entries
+const (
+	LogFieldBoard = "board_id"
+	LogFieldCard  = "card_id"
+	LogFieldList  = "list"
+	LogFieldOp    = "op"
 )
 
 /*
 logger
 
 	Deal with outputs, console, log files, -qq, -loud, etc
+	state - "debug", "info", "warn" or "err" ("error" is accepted as well)
 	console - true to send to console
 	honorLoud - honor the global variable ListLoud (-loud cli parameter)
 	config - Send in our config struct
+	fields - optional structured key/value pairs for the log file, ie LogFieldCard, card.ID
 */
-func logger(message string, state string, console bool, honorLoud bool, config Config) {
+func logger(message string, state string, console bool, honorLoud bool, config Config, fields ...any) {
+
+	// Never let API keys or tokens out, errors from the Trello client include full URLs
+	message = sanitizeURLForLogging(message)
 
 	// should we send to console
 	if console {
@@ -36,43 +50,147 @@ func logger(message string, state string, console bool, honorLoud bool, config C
 		}
 	}
 
-	// If logging is enabled, send everything to logs regardless of CLI parameters
-	if config.ARGS.LoggingEnabled {
-		// Looks like we are logging
-		switch state {
-		case "warn":
-			WarningLogger.Println(message)
-		case "info":
-			InfoLogger.Println(message)
-		case "err":
-			ErrorLogger.Println(message)
-		}
+	// Errors always make it into the run report
+	if level := logLevel(state); level >= slog.LevelError {
+		reportLoggedError(strings.TrimSpace(message), fields)
+	}
+
+	// If logging is enabled, send everything at or above -log-level to logs regardless of CLI parameters
+	if config.ARGS.LoggingEnabled && fileLogger != nil {
+		fileLogger.Log(context.Background(), logLevel(state), strings.TrimSpace(message), fields...)
 	}
 }
 
 /*
-startLog - create log file if enabled
-returns true or false depending on successful log file creation
+logLevel maps logger() states onto slog levels
 */
-func startLog(config Config) bool {
+func logLevel(state string) slog.Level {
+	switch strings.ToLower(state) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "err", "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
 
-	if config.ARGS.LogFile == "" {
-		return false
+/*
+parseLogLevel parses the -log-level flag
+*/
+func parseLogLevel(level string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return l, fmt.Errorf("invalid log level %q (use debug, info, warn or error)", level)
 	}
+	return l, nil
+}
+
+/*
+startLog - create log file and/or syslog output if enabled
+returns true or false depending on successful log creation
+*/
+func startLog(config Config) bool {
 
-	filename := config.ARGS.LogFile
+	var handlers []slog.Handler
 
-	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	level, err := parseLogLevel(config.ARGS.LogLevel)
 	if err != nil {
-		fmt.Println("Failed to initiate log file, specified in -logs called: " + filename)
 		fmt.Println(err)
+		return false
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	if config.ARGS.LogFile != "" {
+		// Rotated files are kept next to the log as name-<timestamp>.ext
+		var out io.Writer = &lumberjack.Logger{
+			Filename:   config.ARGS.LogFile,
+			MaxSize:    config.ARGS.LogMaxSize,
+			MaxAge:     config.ARGS.LogMaxAge,
+			MaxBackups: config.ARGS.LogMaxBackups,
+			LocalTime:  true,
+			Compress:   config.ARGS.LogCompress,
+		}
+
+		// Make sure we can actually write there before claiming logging is on
+		if _, err := out.Write(nil); err != nil {
+			fmt.Println("Failed to initiate log file, specified in -logs called: " + config.ARGS.LogFile)
+			fmt.Println(err)
+			return false
+		}
+
+		switch config.ARGS.LogFormat {
+		case "json":
+			handlers = append(handlers, slog.NewJSONHandler(out, opts))
+		case "logfmt", "":
+			handlers = append(handlers, slog.NewTextHandler(out, opts))
+		default:
+			fmt.Println("Unknown -log-format " + config.ARGS.LogFormat + " (use json or logfmt)")
+			return false
+		}
+	}
+
+	if config.ARGS.Syslog {
+		h, err := newSyslogHandler(level)
+		if err != nil {
+			fmt.Println("Failed to connect to local syslog: " + err.Error())
+		} else {
+			handlers = append(handlers, h)
+		}
+	}
 
+	if len(handlers) == 0 {
 		return false
 	}
 
-	InfoLogger = log.New(file, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile)
-	WarningLogger = log.New(file, "WARNING: ", log.Ldate|log.Ltime|log.Lshortfile)
-	ErrorLogger = log.New(file, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
+	fileLogger = slog.New(multiHandler(handlers)).With("run_version", version)
 
 	return true
 }
+
+/*
+multiHandler
+
+	Fan a log record out to several slog handlers (log file and syslog)
+*/
+type multiHandler []slog.Handler
+
+func (m multiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range m {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m multiHandler) Handle(ctx context.Context, r slog.Record) error {
+	var firstErr error
+	for _, h := range m {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		if err := h.Handle(ctx, r.Clone()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (m multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(multiHandler, len(m))
+	for i, h := range m {
+		out[i] = h.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (m multiHandler) WithGroup(name string) slog.Handler {
+	out := make(multiHandler, len(m))
+	for i, h := range m {
+		out[i] = h.WithGroup(name)
+	}
+	return out
+}
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"syscall"
 
 	"github.com/adlio/trello"
 	"github.com/jedib0t/go-pretty/v6/table"
@@ -12,14 +18,19 @@ import (
 // GLobal
 var (
 	version               string
-	listOfBoards          []string // manage boards that are piped in from a file
 	boardTracker          []string // Used to track boards that have been processed to reference at the end of the run
 	errorWarnOnCompletion bool
 	ListLoud              bool
 	config                Config
 	client                *trello.Client
+
+	// Cancelled on SIGINT/SIGTERM, card workers finish the card in hand and stop
+	runCtx = context.Background()
 )
 
+// Returned for cards that were never started because of a shutdown signal
+var errShutdown = errors.New("not processed, shutting down")
+
 type Config struct {
 	ARGS ARGS
 	ENV  ENV
@@ -28,21 +39,45 @@ type Config struct {
 func main() {
 
 	// Major.Feature.Patch
-	version = "0.3.15"
+	version = "0.9.0"
 
 	// No errors so far!
 	errorWarnOnCompletion = false
 
-	// Load CLI arguments and OS ENV
-	// This also must handle stdin Pipe input
-	config.ARGS, listOfBoards = getCLIArgs()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	runCtx = ctx
+
+	// Load CLI arguments, old style flags are mapped onto subcommands
+	root := newRootCmd()
+	root.SetArgs(normalizeLegacyArgs(root, os.Args[1:]))
+
+	err := root.Execute()
+	stop()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+
+	// 0 success, 1 fatal, 2 partial failure (see report.go)
+	os.Exit(exitCode(err))
+}
+
+/*
+startRun
+
+	Common setup for every command that talks to Trello: log file, OS ENV and the API client
+*/
+func startRun(args ARGS) {
+
+	config.ARGS = args
 	config.ENV = getOSENV()
 
-	// Create Log File if Enabled
-	if config.ARGS.LogFile != "" {
+	// Create Log File and/or syslog output if Enabled
+	if config.ARGS.LogFile != "" || config.ARGS.Syslog {
 		if startLog(config) {
 			config.ARGS.LoggingEnabled = true
-			logger("Successfully started log file: "+config.ARGS.LogFile, "info", true, false, config)
+			if config.ARGS.LogFile != "" {
+				logger("Successfully started log file: "+config.ARGS.LogFile, "info", true, false, config)
+			}
 		} else {
 			config.ARGS.LoggingEnabled = false
 		}
@@ -51,86 +86,96 @@ func main() {
 	// Announce we are starting, this will only go to logfile if its enabled
 	logger("Starting New Trellgo (v"+version+") run.", "info", false, false, config)
 
-	// Create Trello Client
+	// Create Trello Client, requests are counted/timed for the report and metrics and 429s retried
 	client = trello.NewClient(config.ENV.TRELLOAPIKEY, config.ENV.TRELLOAPITOK)
+	client.Client = &http.Client{Transport: apiTransport{next: http.DefaultTransport}}
+}
 
-	// Message this once outside the loop, rather than for each board on multiple board input
-	if config.ARGS.ListTotalCards {
-		logger("\n\nLarge Boards will take a moment to retreive this data...\n\n", "info", true, false, config)
+/*
+getBoard validates a board ID by getting the board data, logs on failure
+*/
+func getBoard(boardID string) (*trello.Board, bool) {
+	board, err := client.GetBoard(boardID, trello.Defaults())
+	if err != nil {
+		errorWarnOnCompletion = true
+		logger("Error: Unable to get board data for board ID "+boardID+": "+err.Error(), "err", true, false, config, LogFieldBoard, boardID, LogFieldOp, "get_board")
+		return nil, false
 	}
+	return board, true
+}
 
-	// Range through board IDs.  Came in via CLI args or stdin pipe
-	for _, boardID := range listOfBoards {
+/*
+runDump
 
-		// validate board ID by getting the board data
-		board, err := client.GetBoard(boardID, trello.Defaults())
-		if err != nil {
-			logger("Error: Unable to get board data for board ID"+boardID+": "+err.Error(), "err", true, false, config)
-			continue
-		}
+	Process board data (dump command, or stdin pipe)
+	Returns an error only when no board at all could be dumped
+*/
+func runDump(boards []string) error {
 
-		/* Process Label List Request (-labels) */
-		if config.ARGS.ListLabelIDs {
+	var (
+		roots  []string // storage paths used, their board indexes are checked for deleted boards
+		dumped []string
+	)
 
-			labels, err := board.GetLabels(trello.Defaults())
-			if err != nil {
-				logger("Error: Unable to get label data for board ID "+board.ID+" ("+board.Name+"): "+err.Error(), "err", true, false, config)
-				continue
-			}
+	// Range through board IDs.  Came in via CLI args, stdin pipe or config file
+	for _, boardID := range boards {
 
-			fmt.Printf("\n\nLabel IDs for Board: %s (%s)\n\n", board.Name, board.ID)
-			prettyPrintLabels(labels, false)
+		if root := boardArgs(config.ARGS, boardID).StoragePath; root != "" && !slices.Contains(roots, root) {
+			roots = append(roots, root)
+		}
 
+		if runCtx.Err() != nil {
+			logger("Shutting down, skipping board "+boardID, "warn", true, false, config, LogFieldBoard, boardID, LogFieldOp, "shutdown")
+			errorWarnOnCompletion = true
 			continue
 		}
 
-		/* Process Card Counts Request (-count) */
-		if config.ARGS.ListTotalCards {
-
-			totalCards, _ := board.GetCards(trello.Arguments{"filter": "all"})
-			openCards, _ := board.GetCards(trello.Arguments{"filter": "open"})
-			closedCards, _ := board.GetCards(trello.Arguments{"filter": "closed"})
-			visibleCards, _ := board.GetCards(trello.Arguments{"filter": "visible"}) // Visible cards are open and not archived
-
-			t := table.NewWriter()
-			t.SetOutputMirror(os.Stdout)
-			t.AppendRow([]interface{}{"Total Cards", len(totalCards)})
-			t.AppendSeparator()
-			t.AppendRow([]interface{}{"Open Cards", len(openCards)})
-			t.AppendSeparator()
-			t.AppendRow([]interface{}{"Archived Cards", len(closedCards)})
-			t.AppendSeparator()
-			t.AppendRow([]interface{}{"Visible Cards", len(visibleCards)})
-
-			t.SetStyle(table.StyleLight)
-			t.Style().Color.Header = text.Colors{text.FgHiGreen, text.Bold}
-
-			fmt.Printf("\n\nCard Counts for Board: %s (%s)\n\n", board.Name, board.ID)
-
-			t.Render()
+		board, ok := getBoard(boardID)
+		if !ok {
+			continue
+		}
+		dumped = append(dumped, board.ID, boardID)
 
+		if !config.ARGS.SuperQuiet {
 			fmt.Println()
+		}
+		logger("Processing Board Name: "+board.Name, "info", true, false, config, LogFieldBoard, board.ID, LogFieldOp, "dump")
 
-			continue
+		// Per board config file settings (storage, archive, label, etc)
+		boardConfig := config
+		boardConfig.ARGS = boardArgs(config.ARGS, boardID)
+		if boardConfig.ARGS.StoragePath != config.ARGS.StoragePath {
+			logger("Using board specific storage path: "+boardConfig.ARGS.StoragePath, "info", true, false, config)
 		}
+		dumpABoard(boardConfig, board, client)
 
-		/* Process board data (-b) or (stdin pipe) */
-		if !config.ARGS.ListLabelIDs && !config.ARGS.ListTotalCards {
-			if !config.ARGS.SuperQuiet {
-				fmt.Println()
+		// Ship the board tree as soon as it is written
+		if fc := config.ARGS.fileConfig; fc != nil && len(fc.Ship) > 0 {
+			if b := reportBoard(board.ID); b != nil && !shipAll(fc.Ship, b.Path, LogFieldBoard, board.ID) {
+				errorWarnOnCompletion = true
 			}
-			logger("Processing Board Name: "+board.Name, "info", true, false, config)
-			dumpABoard(config, board, client)
+		}
 
-			if !config.ARGS.SuperQuiet {
-				fmt.Println()
-			}
-			logger("Processing Complete", "info", true, false, config)
+		if !config.ARGS.SuperQuiet {
+			fmt.Println()
 		}
+		logger("Processing Complete", "info", true, false, config)
 	}
 
-	if !config.ARGS.ListLabelIDs && !config.ARGS.ListTotalCards {
+	closeSQLiteExports()
+
+	// Boards dumped before that weren't asked for (or couldn't be found) may be gone from Trello
+	if runCtx.Err() == nil {
+		retireDeletedBoards(roots, dumped)
+	}
+
+	// Every board's calendar in one file per storage path, to subscribe to all of them at once
+	writeCombinedCalendars(roots)
+
+	if config.ARGS.StoragePath != "" {
 		logger("Your board backups are in the directory:"+config.ARGS.StoragePath, "info", true, false, config)
+	} else {
+		logger("Your board backups are in the per board storage paths from "+config.ARGS.ConfigFile, "info", true, false, config)
 	}
 
 	// If we have processed boards, print them out
@@ -140,9 +185,138 @@ func main() {
 			logger(" - "+boardName, "info", true, false, config)
 		}
 	}
+	if skipped := skippedSummary(); len(skipped) > 0 {
+		logger("Attachments skipped by the size and type limits (see attachments.md on each card):", "info", true, false, config)
+		for _, line := range skipped {
+			logger(" - "+line, "info", true, false, config)
+		}
+	}
+	if deleted := deletedSummary(); len(deleted) > 0 {
+		logger("Gone from Trello since the last run:", "info", true, false, config)
+		for _, line := range deleted {
+			logger(" - "+line, "info", true, false, config)
+		}
+	}
 
 	if errorWarnOnCompletion {
 		logger("========== WARNING ==========", "warn", true, true, config)
 		logger("There was CRITICAL errors during the process.  Please see log files and search for CRITICAL.", "warn", true, true, config)
 	}
+
+	// Not a single board could be dumped, thats a fatal run rather than a partial one
+	var fatal error
+	if len(boardTracker) == 0 {
+		fatal = fmt.Errorf("none of the %d board(s) could be dumped", len(boards))
+	}
+
+	finishReport("dump", exitCode(fatal))
+	writeReports(config.ARGS)
+
+	return fatal
+}
+
+/*
+runLabels
+
+	Process Label List Request (labels command)
+*/
+func runLabels(boards []string) {
+
+	for _, boardID := range boards {
+
+		board, ok := getBoard(boardID)
+		if !ok {
+			continue
+		}
+
+		labels, err := board.GetLabels(trello.Defaults())
+		if err != nil {
+			logger("Error: Unable to get label data for board ID "+board.ID+" ("+board.Name+"): "+err.Error(), "err", true, false, config)
+			continue
+		}
+
+		fmt.Printf("\n\nLabel IDs for Board: %s (%s)\n\n", board.Name, board.ID)
+		prettyPrintLabels(labels, false)
+	}
+}
+
+/*
+runCount
+
+	Process Card Counts Request (count command)
+*/
+func runCount(boards []string) {
+
+	// Message this once outside the loop, rather than for each board on multiple board input
+	logger("\n\nLarge Boards will take a moment to retreive this data...\n\n", "info", true, false, config)
+
+	for _, boardID := range boards {
+
+		board, ok := getBoard(boardID)
+		if !ok {
+			continue
+		}
+
+		totalCards, _ := board.GetCards(trello.Arguments{"filter": "all"})
+		openCards, _ := board.GetCards(trello.Arguments{"filter": "open"})
+		closedCards, _ := board.GetCards(trello.Arguments{"filter": "closed"})
+		visibleCards, _ := board.GetCards(trello.Arguments{"filter": "visible"}) // Visible cards are open and not archived
+
+		t := table.NewWriter()
+		t.SetOutputMirror(os.Stdout)
+		t.AppendRow([]interface{}{"Total Cards", len(totalCards)})
+		t.AppendSeparator()
+		t.AppendRow([]interface{}{"Open Cards", len(openCards)})
+		t.AppendSeparator()
+		t.AppendRow([]interface{}{"Archived Cards", len(closedCards)})
+		t.AppendSeparator()
+		t.AppendRow([]interface{}{"Visible Cards", len(visibleCards)})
+
+		t.SetStyle(table.StyleLight)
+		t.Style().Color.Header = text.Colors{text.FgHiGreen, text.Bold}
+
+		fmt.Printf("\n\nCard Counts for Board: %s (%s)\n\n", board.Name, board.ID)
+
+		t.Render()
+
+		fmt.Println()
+	}
+}
+
+/*
+runListBoards
+
+	List boards visible to the API token (list-boards command)
+*/
+func runListBoards(closed bool, idsOnly bool) error {
+
+	filter := "open"
+	if closed {
+		filter = "all"
+	}
+
+	boards, err := client.GetMyBoards(trello.Arguments{"filter": filter})
+	if err != nil {
+		return fmt.Errorf("unable to get boards for this token: %w", err)
+	}
+
+	if idsOnly {
+		for _, b := range boards {
+			fmt.Println(b.ID)
+		}
+		return nil
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Board Name", "Board ID", "Closed", "URL"})
+	for _, b := range boards {
+		t.AppendRow([]interface{}{b.Name, b.ID, b.Closed, b.ShortURL})
+		t.AppendSeparator()
+	}
+	t.SetStyle(table.StyleLight)
+	t.Style().Color.Header = text.Colors{text.FgHiGreen, text.Bold}
+	t.Render()
+
+	return nil
 }
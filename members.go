@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/adlio/trello"
+)
+
+// Board level directory holding member avatars, shared by every card on the board
+const MembersDir = "members"
+
+// Size Trello serves avatars at, 30, 50 and 170 are available
+const AvatarSize = "170"
+
+// Usernames of every member seen this run by ID, for naming attachment uploaders
+var memberNames sync.Map
+
+/*
+BoardMember
+
+	A board member with what is needed to still know who they were once Trello is gone.
+	MemberType is the Trello account type, Role is the member's role on this board (admin/normal/observer).
+*/
+type BoardMember struct {
+	ID         string `json:"id"`
+	Username   string `json:"username"`
+	FullName   string `json:"fullName"`
+	Initials   string `json:"initials"`
+	AvatarHash string `json:"avatarHash"`
+	AvatarURL  string `json:"avatarUrl"`
+	MemberType string `json:"memberType"`
+	Role       string `json:"-"`
+}
+
+/*
+fetchBoardMembers gets the board's members with their board role filled in from the memberships
+*/
+func fetchBoardMembers(board *trello.Board, client *trello.Client) ([]BoardMember, error) {
+
+	var members []BoardMember
+	args := trello.Arguments{"fields": "username,fullName,initials,avatarHash,avatarUrl,memberType"}
+	if err := client.Get("boards/"+board.ID+"/members", args, &members); err != nil {
+		return nil, err
+	}
+
+	var memberships []trello.Membership
+	if err := client.Get("boards/"+board.ID+"/memberships", trello.Defaults(), &memberships); err != nil {
+		logger("Warning: Unable to get member roles for board ID "+board.ID+": "+err.Error(), "warn", true, false, config, LogFieldBoard, board.ID, LogFieldOp, "members")
+	}
+	roles := make(map[string]string, len(memberships))
+	for _, m := range memberships {
+		roles[m.MemberID] = m.Type
+	}
+
+	for i := range members {
+		members[i].Role = roles[members[i].ID]
+		rememberMember(members[i].ID, members[i].Username)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return strings.ToLower(members[i].Username) < strings.ToLower(members[j].Username)
+	})
+
+	return members, nil
+}
+
+/*
+rememberMember notes a member's username for memberUsername
+*/
+func rememberMember(id string, username string) {
+	if id != "" && username != "" {
+		memberNames.Store(id, username)
+	}
+}
+
+/*
+memberUsername is the username of a member seen this run, "" if they haven't been
+*/
+func memberUsername(id string) string {
+	if name, ok := memberNames.Load(id); ok {
+		return name.(string)
+	}
+	return ""
+}
+
+/*
+avatarFileName is the name a member's avatar is saved as under members/, empty if they have none.
+The avatar hash is part of the name so a new avatar is fetched and an unchanged one never is.
+*/
+func avatarFileName(username string, avatarHash string) string {
+	if avatarHash == "" {
+		return ""
+	}
+	if username == "" {
+		username = "unknown"
+	}
+	hash := avatarHash
+	if len(hash) > 8 {
+		hash = hash[:8]
+	}
+	return SanitizePathName(username) + "-" + hash + ".png"
+}
+
+/*
+downloadAvatars saves each member's avatar into the board's members directory, skipping ones already there
+*/
+func downloadAvatars(members []BoardMember, boardDir string, boardID string) {
+
+	dir := filepath.Join(boardDir, MembersDir)
+	dirCreate(dir)
+
+	for _, m := range members {
+		fileName := avatarFileName(m.Username, m.AvatarHash)
+		if fileName == "" {
+			continue
+		}
+		localPath := filepath.Join(dir, fileName)
+		if _, err := os.Stat(localPath); err == nil {
+			continue
+		}
+
+		avatarURL := m.AvatarURL
+		if avatarURL == "" {
+			avatarURL = "https://trello-members.s3.amazonaws.com/" + m.ID + "/" + m.AvatarHash
+		}
+		if _, err := download(downloadRequest{URL: avatarURL + "/" + AvatarSize + ".png", Path: localPath}); err != nil {
+			logger("Error: Unable to download avatar for "+m.Username+": "+err.Error(), "err", true, false, config, LogFieldBoard, boardID, LogFieldOp, "members")
+			continue
+		}
+		logger("Saved avatar for "+m.Username+" to "+localPath, "info", true, true, config, LogFieldBoard, boardID, LogFieldOp, "members")
+	}
+}
+
+/*
+boardMembersMarkdown builds BoardMembers.md, a table of members with their avatars
+*/
+func boardMembersMarkdown(members []BoardMember, boardDir string) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+
+	buf.WriteString("| Avatar | Username | Full Name | Initials | Board Role | Member Type | ID |\n")
+	buf.WriteString("| --- | --- | --- | --- | --- | --- | --- |\n")
+	for _, m := range members {
+		fmt.Fprintf(buf, "| %s | @%s | %s | %s | %s | %s | %s |\n",
+			avatarMarkdown(m.Username, m.AvatarHash, boardDir, boardDir),
+			mdCell(m.Username), mdCell(m.FullName), mdCell(m.Initials), mdCell(m.Role), mdCell(m.MemberType), m.ID)
+	}
+
+	return buf
+}
+
+/*
+avatarMarkdown is an image link to a member's saved avatar, relative to fromDir.  Empty if it wasn't saved.
+*/
+func avatarMarkdown(username string, avatarHash string, boardDir string, fromDir string) string {
+	fileName := avatarFileName(username, avatarHash)
+	if fileName == "" {
+		return ""
+	}
+	localPath := filepath.Join(boardDir, MembersDir, fileName)
+	if _, err := os.Stat(localPath); err != nil {
+		return ""
+	}
+	rel, err := filepath.Rel(fromDir, localPath)
+	if err != nil {
+		return ""
+	}
+	// Markdown links want forward slashes and no bare spaces
+	rel = strings.ReplaceAll(filepath.ToSlash(rel), " ", "%20")
+	return fmt.Sprintf("![%s](%s)", username, rel)
+}
+
+// mdCell keeps a value from breaking a markdown table row
+func mdCell(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "|", "\\|"), "\n", " ")
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Latency buckets (seconds) for the API request histogram
+var apiLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// How many times a request that got HTTP 429 (Too Many Requests) is retried
+const MaxRateLimitRetries = 5
+
+/*
+runMetrics
+
+	Prometheus style metrics for the process.  Written as a node_exporter textfile at
+	the end of a run (-metrics-textfile), or served on /metrics in daemon mode.
+*/
+type runMetrics struct {
+	mu sync.Mutex
+
+	cardsProcessed  map[string]float64 // board_id
+	cardsFailed     map[string]float64 // board_id
+	attachments     map[string]float64 // board_id
+	attachmentBytes map[string]float64 // board_id
+	errors          map[string]float64 // op
+	apiRequests     map[string]float64 // status code
+	retries429      float64
+
+	latencyCounts []float64 // per apiLatencyBuckets, cumulative on output
+	latencySum    float64
+	latencyCount  float64
+
+	lastSuccess map[string]boardSuccess // board_id
+}
+
+type boardSuccess struct {
+	name string
+	ts   float64
+}
+
+var metrics = newRunMetrics()
+
+func newRunMetrics() *runMetrics {
+	return &runMetrics{
+		cardsProcessed:  make(map[string]float64),
+		cardsFailed:     make(map[string]float64),
+		attachments:     make(map[string]float64),
+		attachmentBytes: make(map[string]float64),
+		errors:          make(map[string]float64),
+		apiRequests:     make(map[string]float64),
+		latencyCounts:   make([]float64, len(apiLatencyBuckets)),
+		lastSuccess:     make(map[string]boardSuccess),
+	}
+}
+
+/*
+metricCard records a card finished by the card worker pool
+*/
+func metricCard(boardID string, err error) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	if err != nil {
+		metrics.cardsFailed[boardID]++
+	} else {
+		metrics.cardsProcessed[boardID]++
+	}
+}
+
+/*
+metricAttachment records a downloaded attachment
+*/
+func metricAttachment(boardID string, size int64) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	metrics.attachments[boardID]++
+	metrics.attachmentBytes[boardID] += float64(size)
+}
+
+/*
+metricError records a logged error by the operation (op log field) it happened in
+*/
+func metricError(op string) {
+	if op == "" {
+		op = "other"
+	}
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	metrics.errors[op]++
+}
+
+/*
+metricAPIRequest records one HTTP request and how long it took
+*/
+func metricAPIRequest(code int, d time.Duration) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	label := "error"
+	if code > 0 {
+		label = strconv.Itoa(code)
+	}
+	metrics.apiRequests[label]++
+
+	secs := d.Seconds()
+	metrics.latencySum += secs
+	metrics.latencyCount++
+	for i, b := range apiLatencyBuckets {
+		if secs <= b {
+			metrics.latencyCounts[i]++
+			break
+		}
+	}
+}
+
+/*
+metricBoardSuccess records a board that was backed up without any errors
+*/
+func metricBoardSuccess(boardID string, name string, t time.Time) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	metrics.lastSuccess[boardID] = boardSuccess{name: name, ts: float64(t.Unix())}
+}
+
+/*
+apiTransport
+
+	http.RoundTripper used for every Trello request (API and downloads).
+	Counts and times requests, and waits/retries when Trello answers 429 Too Many Requests.
+*/
+type apiTransport struct {
+	next http.RoundTripper
+}
+
+func (t apiTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	for attempt := 0; ; attempt++ {
+		apiCalls.Add(1)
+		start := time.Now()
+		resp, err := t.next.RoundTrip(req)
+
+		code := 0
+		if err == nil {
+			code = resp.StatusCode
+		}
+		metricAPIRequest(code, time.Since(start))
+
+		// Only requests without a body can be safely sent again
+		if err != nil || code != http.StatusTooManyRequests || attempt >= MaxRateLimitRetries || req.Body != nil {
+			return resp, err
+		}
+
+		wait := retryAfter(resp, attempt)
+		resp.Body.Close()
+
+		metrics.mu.Lock()
+		metrics.retries429++
+		metrics.mu.Unlock()
+		logger(fmt.Sprintf("Rate limited by Trello, retrying in %s", wait), "warn", true, true, config, LogFieldOp, "rate_limit")
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+/*
+retryAfter works out how long to wait before retrying a 429, honoring Retry-After when sent
+*/
+func retryAfter(resp *http.Response, attempt int) time.Duration {
+	if s := resp.Header.Get("Retry-After"); s != "" {
+		if secs, err := strconv.Atoi(s); err == nil && secs >= 0 {
+			return time.Duration(secs) * time.Second
+		}
+	}
+	// 1s, 2s, 4s, 8s...
+	return time.Second << attempt
+}
+
+/*
+writeMetrics writes all metrics in the Prometheus text exposition format
+*/
+func writeMetrics(w io.Writer) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	writeFamily := func(name, typ, help string, values map[string]float64, label string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		for _, k := range sortedKeys(values) {
+			fmt.Fprintf(w, "%s{%s=\"%s\"} %s\n", name, label, escapeLabel(k), formatMetric(values[k]))
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP trellgo_last_success_timestamp_seconds Unix time of the last backup of a board that finished without errors.\n")
+	fmt.Fprintf(w, "# TYPE trellgo_last_success_timestamp_seconds gauge\n")
+	ids := make([]string, 0, len(metrics.lastSuccess))
+	for id := range metrics.lastSuccess {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		s := metrics.lastSuccess[id]
+		fmt.Fprintf(w, "trellgo_last_success_timestamp_seconds{board_id=\"%s\",board_name=\"%s\"} %s\n", escapeLabel(id), escapeLabel(s.name), formatMetric(s.ts))
+	}
+
+	writeFamily("trellgo_cards_processed_total", "counter", "Cards written to disk.", metrics.cardsProcessed, "board_id")
+	writeFamily("trellgo_cards_failed_total", "counter", "Cards that failed to be written.", metrics.cardsFailed, "board_id")
+	writeFamily("trellgo_attachments_downloaded_total", "counter", "Attachments downloaded.", metrics.attachments, "board_id")
+	writeFamily("trellgo_attachment_bytes_downloaded_total", "counter", "Attachment bytes downloaded.", metrics.attachmentBytes, "board_id")
+	writeFamily("trellgo_errors_total", "counter", "Errors logged, by operation.", metrics.errors, "op")
+	writeFamily("trellgo_api_requests_total", "counter", "HTTP requests made to Trello, by status code.", metrics.apiRequests, "code")
+
+	fmt.Fprintf(w, "# HELP trellgo_api_rate_limit_retries_total Requests retried after HTTP 429 from Trello.\n")
+	fmt.Fprintf(w, "# TYPE trellgo_api_rate_limit_retries_total counter\n")
+	fmt.Fprintf(w, "trellgo_api_rate_limit_retries_total %s\n", formatMetric(metrics.retries429))
+
+	fmt.Fprintf(w, "# HELP trellgo_api_request_duration_seconds Latency of HTTP requests made to Trello.\n")
+	fmt.Fprintf(w, "# TYPE trellgo_api_request_duration_seconds histogram\n")
+	var cumulative float64
+	for i, b := range apiLatencyBuckets {
+		cumulative += metrics.latencyCounts[i]
+		fmt.Fprintf(w, "trellgo_api_request_duration_seconds_bucket{le=\"%s\"} %s\n", formatMetric(b), formatMetric(cumulative))
+	}
+	fmt.Fprintf(w, "trellgo_api_request_duration_seconds_bucket{le=\"+Inf\"} %s\n", formatMetric(metrics.latencyCount))
+	fmt.Fprintf(w, "trellgo_api_request_duration_seconds_sum %s\n", formatMetric(metrics.latencySum))
+	fmt.Fprintf(w, "trellgo_api_request_duration_seconds_count %s\n", formatMetric(metrics.latencyCount))
+}
+
+/*
+metricsHandler serves /metrics
+*/
+func metricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetrics(w)
+	})
+}
+
+/*
+writeMetricsTextfile
+
+	Write metrics for the node_exporter textfile collector.  The file is written to a temp
+	file and renamed so node_exporter never reads half of it.  Last success timestamps for
+	boards that failed (or weren't in) this run are carried over from the previous file.
+*/
+func writeMetricsTextfile(fileName string) error {
+
+	loadPreviousSuccess(fileName)
+
+	tmp, err := os.CreateTemp(filepath.Dir(fileName), ".trellgo-metrics-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	bw := bufio.NewWriter(tmp)
+	writeMetrics(bw)
+	if err := bw.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// node_exporter runs as its own user and must be able to read this
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), fileName)
+}
+
+/*
+loadPreviousSuccess reads last success timestamps from an existing textfile, keeping any newer ones we already have
+*/
+func loadPreviousSuccess(fileName string) {
+
+	for _, line := range readLines(fileName) {
+		if !strings.HasPrefix(line, "trellgo_last_success_timestamp_seconds{") {
+			continue
+		}
+		labels, value, ok := strings.Cut(strings.TrimPrefix(line, "trellgo_last_success_timestamp_seconds{"), "} ")
+		if !ok {
+			continue
+		}
+		ts, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		id, name := parseSuccessLabels(labels)
+		if id == "" {
+			continue
+		}
+
+		metrics.mu.Lock()
+		if cur, ok := metrics.lastSuccess[id]; !ok || cur.ts < ts {
+			metrics.lastSuccess[id] = boardSuccess{name: name, ts: ts}
+		}
+		metrics.mu.Unlock()
+	}
+}
+
+/*
+parseSuccessLabels pulls board_id and board_name out of a label set written by writeMetrics
+*/
+func parseSuccessLabels(labels string) (id string, name string) {
+	for _, part := range strings.Split(labels, "\",") {
+		k, v, ok := strings.Cut(part, "=\"")
+		if !ok {
+			continue
+		}
+		v = strings.TrimSuffix(v, "\"")
+		v = strings.NewReplacer(`\"`, `"`, `\n`, "\n", `\\`, `\`).Replace(v)
+		switch k {
+		case "board_id":
+			id = v
+		case "board_name":
+			name = v
+		}
+	}
+	return id, name
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func formatMetric(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,509 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adlio/trello"
+	"gopkg.in/yaml.v3"
+)
+
+// Written in each board directory with --format jira, github and/or gitlab
+const (
+	JiraCSVFile      = "jira-import.csv"
+	GitHubIssuesFile = "github-issues.json"
+	GitLabCSVFile    = "gitlab-issues.csv"
+)
+
+// The formats that turn a board into another tracker's issues
+var migrationFormats = []string{"jira", "github", "gitlab"}
+
+// Dates in the Jira CSV, set the importer's date format to yyyy-MM-dd HH:mm
+const jiraTimeFormat = "2006-01-02 15:04"
+
+/*
+MigrationMap
+
+	How a board maps onto the tracker it is moving to, read from --migration-map.
+	Lists become statuses, an unmapped list keeps its name.  Members become users,
+	the per tracker maps win over users, an unmapped member keeps their Trello username.
+*/
+type MigrationMap struct {
+	Statuses    map[string]string `yaml:"statuses,omitempty"`     // list name -> status
+	Closed      []string          `yaml:"closed,omitempty"`       // statuses (or list names) that close the issue
+	Users       map[string]string `yaml:"users,omitempty"`        // Trello username -> user
+	JiraUsers   map[string]string `yaml:"jira_users,omitempty"`   // Trello username -> Jira user, usually an email address
+	GitHubUsers map[string]string `yaml:"github_users,omitempty"` // Trello username -> GitHub login
+	GitLabUsers map[string]string `yaml:"gitlab_users,omitempty"` // Trello username -> GitLab username
+	IssueType   string            `yaml:"issue_type,omitempty"`   // Jira issue type, Task if not set
+}
+
+/*
+loadMigrationMap reads a mapping file, unknown keys are rejected like in the config file
+*/
+func loadMigrationMap(fileName string) (*MigrationMap, error) {
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("reading migration map %s: %w", fileName, err)
+	}
+
+	var m MigrationMap
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&m); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parsing migration map %s: %w", fileName, err)
+	}
+
+	return &m, nil
+}
+
+/*
+status is the status a list maps to, list names match in any case
+*/
+func (m *MigrationMap) status(listName string) string {
+	for list, status := range m.Statuses {
+		if strings.EqualFold(list, listName) {
+			return status
+		}
+	}
+	return listName
+}
+
+/*
+closes is when an issue in this list (with this status) is closed
+*/
+func (m *MigrationMap) closes(listName string) bool {
+	status := m.status(listName)
+	return slices.ContainsFunc(m.Closed, func(c string) bool {
+		return strings.EqualFold(c, status) || strings.EqualFold(c, listName)
+	})
+}
+
+/*
+user is who a Trello username is in the tracker, and false when the map doesn't say
+*/
+func (m *MigrationMap) user(tracker string, username string) (string, bool) {
+	byTracker := map[string]map[string]string{"jira": m.JiraUsers, "github": m.GitHubUsers, "gitlab": m.GitLabUsers}
+	if u, ok := byTracker[tracker][username]; ok {
+		return u, true
+	}
+	if u, ok := m.Users[username]; ok {
+		return u, true
+	}
+	return username, false
+}
+
+/*
+migrationExport
+
+	A board as issues for other trackers.  Card workers add issues as they go, and the
+	files are written once the whole board has been processed, in board order.
+*/
+type migrationExport struct {
+	mu       sync.Mutex
+	dir      string
+	lists    map[string]*trello.List
+	formats  []string // which of migrationFormats are on
+	mapping  *MigrationMap
+	issues   []migrationIssue
+	unmapped map[string]bool // Trello usernames the map has no user for
+}
+
+// One card as an issue, users are still Trello usernames
+type migrationIssue struct {
+	listPos   float64
+	pos       float64
+	url       string
+	title     string
+	body      string // Markdown: description, checklists, attachments and a link back to the card
+	list      string
+	labels    []string
+	assignees []string
+	archived  bool
+	created   time.Time
+	updated   *time.Time
+	due       *time.Time
+	comments  []migrationComment
+}
+
+type migrationComment struct {
+	author string
+	date   time.Time
+	text   string
+}
+
+/*
+newMigrationExport is nil when none of the jira, github or gitlab formats are on for the board
+*/
+func newMigrationExport(config Config, boardDir string, lists map[string]*trello.List) *migrationExport {
+
+	var formats []string
+	for _, f := range migrationFormats {
+		if hasFormat(config.ARGS, f) {
+			formats = append(formats, f)
+		}
+	}
+	if len(formats) == 0 {
+		return nil
+	}
+
+	// Without a map lists and members carry over by name
+	mapping := &MigrationMap{}
+	if config.ARGS.MigrationMap != "" {
+		m, err := loadMigrationMap(config.ARGS.MigrationMap)
+		if err != nil {
+			logger("Error: "+err.Error()+", lists and members are exported unmapped", "err", true, false, config, LogFieldOp, "migrate")
+			errorWarnOnCompletion = true
+		} else {
+			mapping = m
+		}
+	}
+
+	return &migrationExport{dir: boardDir, lists: lists, formats: formats, mapping: mapping, unmapped: make(map[string]bool)}
+}
+
+/*
+addCard
+
+	Add a card as an issue.  Checklists, attachments and comments come from
+	getComprehensiveCardData, a card without them still gets its issue.
+*/
+func (m *migrationExport) addCard(card *trello.Card) {
+	if m == nil {
+		return
+	}
+
+	issue := migrationIssue{
+		pos:      card.Pos,
+		url:      card.URL,
+		title:    card.Name,
+		archived: card.Closed,
+		created:  card.CreatedAt(),
+		updated:  card.DateLastActivity,
+		due:      card.Due,
+	}
+	if list, ok := m.lists[card.IDList]; ok {
+		issue.list = list.Name
+		issue.listPos = float64(list.Pos)
+	}
+
+	for _, l := range card.Labels {
+		if l.Name != "" {
+			issue.labels = append(issue.labels, l.Name)
+		} else {
+			issue.labels = append(issue.labels, l.Color)
+		}
+	}
+	if len(card.Members) > 0 {
+		for _, mem := range card.Members {
+			issue.assignees = append(issue.assignees, mem.Username)
+		}
+	} else {
+		for _, id := range card.IDMembers {
+			if username := memberUsername(id); username != "" {
+				issue.assignees = append(issue.assignees, username)
+			}
+		}
+	}
+
+	// Trello returns newest first
+	for _, a := range card.Actions {
+		if a == nil || a.Type != "commentCard" || a.Data == nil {
+			continue
+		}
+		author := a.IDMemberCreator
+		if a.MemberCreator != nil && a.MemberCreator.Username != "" {
+			author = a.MemberCreator.Username
+		}
+		issue.comments = append(issue.comments, migrationComment{author: author, date: a.Date, text: a.Data.Text})
+	}
+	slices.SortStableFunc(issue.comments, func(a, b migrationComment) int { return a.date.Compare(b.date) })
+
+	var body strings.Builder
+	body.WriteString(strings.TrimSpace(card.Desc))
+	for _, cl := range card.Checklists {
+		fmt.Fprintf(&body, "\n\n### %s\n", cl.Name)
+		for _, item := range cl.CheckItems {
+			mark := " "
+			if item.State == "complete" {
+				mark = "x"
+			}
+			fmt.Fprintf(&body, "\n- [%s] %s", mark, item.Name)
+		}
+	}
+	if len(card.Attachments) > 0 {
+		body.WriteString("\n\n### Attachments\n")
+		for _, a := range card.Attachments {
+			fmt.Fprintf(&body, "\n- [%s](%s)", a.Name, a.URL)
+		}
+	}
+	if card.URL != "" {
+		fmt.Fprintf(&body, "\n\n---\nImported from Trello: %s", card.URL)
+	}
+	issue.body = strings.TrimSpace(body.String())
+
+	m.mu.Lock()
+	m.issues = append(m.issues, issue)
+	m.mu.Unlock()
+}
+
+/*
+write
+
+	Write the board's import files.  Called once every card has been added, a run that
+	stopped part way leaves the files from the last complete run in place.
+*/
+func (m *migrationExport) write(board *trello.Board, config Config) {
+	if m == nil {
+		return
+	}
+	if runCtx.Err() != nil {
+		logger("Shutting down, not rewriting the migration files for board "+board.Name, "warn", true, false, config, LogFieldBoard, board.ID, LogFieldOp, "migrate")
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	slices.SortStableFunc(m.issues, func(a, b migrationIssue) int {
+		return cmp.Or(cmp.Compare(a.listPos, b.listPos), cmp.Compare(a.pos, b.pos))
+	})
+
+	writers := map[string]struct {
+		file  string
+		write func(string) error
+	}{
+		"jira":   {JiraCSVFile, m.writeJira},
+		"github": {GitHubIssuesFile, m.writeGitHub},
+		"gitlab": {GitLabCSVFile, m.writeGitLab},
+	}
+	for _, f := range m.formats {
+		w := writers[f]
+		fileName := filepath.Join(m.dir, w.file)
+		if err := w.write(fileName); err != nil {
+			logger("CRITICAL - Unable to write "+fileName+" Error: "+err.Error(), "err", true, false, config, LogFieldBoard, board.ID, LogFieldOp, "migrate")
+			errorWarnOnCompletion = true
+			continue
+		}
+		logger(fmt.Sprintf("Wrote %d issues to %s", len(m.issues), fileName), "info", true, true, config, LogFieldBoard, board.ID, LogFieldOp, "migrate")
+	}
+
+	if config.ARGS.MigrationMap != "" && len(m.unmapped) > 0 {
+		logger("Warning: "+config.ARGS.MigrationMap+" has no user for Trello member(s) "+strings.Join(slices.Sorted(maps.Keys(m.unmapped)), ", ")+
+			" on board "+board.Name+", their Trello usernames were used", "warn", true, false, config, LogFieldBoard, board.ID, LogFieldOp, "migrate")
+	}
+}
+
+/*
+users maps Trello usernames to a tracker's users, noting any the map has no user for
+*/
+func (m *migrationExport) users(tracker string, usernames []string) []string {
+	var out []string
+	for _, username := range usernames {
+		u, ok := m.mapping.user(tracker, username)
+		if !ok {
+			m.unmapped[username] = true
+		}
+		out = append(out, u)
+	}
+	return out
+}
+
+/*
+writeJira
+
+	Jira's CSV importer takes a column once per value for labels, watchers and comments,
+	so those columns repeat as many times as the busiest card needs.  The first card
+	member is the assignee, the rest are watchers.
+*/
+func (m *migrationExport) writeJira(fileName string) error {
+
+	issueType := cmp.Or(m.mapping.IssueType, "Task")
+
+	type jiraRow struct {
+		fixed    []string
+		labels   []string
+		watchers []string
+		comments []string
+	}
+	var (
+		rows                                []jiraRow
+		maxLabels, maxWatchers, maxComments int
+	)
+	for _, issue := range m.issues {
+		r := jiraRow{}
+		users := m.users("jira", issue.assignees)
+		assignee := ""
+		if len(users) > 0 {
+			assignee, r.watchers = users[0], users[1:]
+		}
+		for _, l := range issue.labels {
+			// Jira labels can't have spaces
+			r.labels = append(r.labels, strings.Join(strings.Fields(l), "_"))
+		}
+		for _, c := range issue.comments {
+			author := m.users("jira", []string{c.author})[0]
+			r.comments = append(r.comments, c.date.UTC().Format(jiraTimeFormat)+";"+author+";"+c.text)
+		}
+		resolution := ""
+		if issue.archived || m.mapping.closes(issue.list) {
+			resolution = "Done"
+		}
+		r.fixed = []string{
+			issue.title, issue.body, issueType, m.mapping.status(issue.list), resolution, assignee,
+			jiraTime(&issue.created), jiraTime(issue.updated), jiraTime(issue.due), issue.url,
+		}
+		maxLabels, maxWatchers, maxComments = max(maxLabels, len(r.labels)), max(maxWatchers, len(r.watchers)), max(maxComments, len(r.comments))
+		rows = append(rows, r)
+	}
+
+	header := []string{"Summary", "Description", "Issue Type", "Status", "Resolution", "Assignee", "Created", "Updated", "Due Date", "Trello Card"}
+	header = append(header, repeat("Labels", maxLabels)...)
+	header = append(header, repeat("Watchers", maxWatchers)...)
+	header = append(header, repeat("Comment", maxComments)...)
+
+	var out [][]string
+	for _, r := range rows {
+		row := slices.Clone(r.fixed)
+		row = append(row, padded(r.labels, maxLabels)...)
+		row = append(row, padded(r.watchers, maxWatchers)...)
+		row = append(row, padded(r.comments, maxComments)...)
+		out = append(out, row)
+	}
+
+	return writeCSVFile(fileName, header, out)
+}
+
+// One issue in github-issues.json
+type gitHubIssue struct {
+	Title     string          `json:"title"`
+	Body      string          `json:"body"`
+	Labels    []string        `json:"labels"`
+	Assignees []string        `json:"assignees"`
+	State     string          `json:"state"`  // open or closed
+	Status    string          `json:"status"` // the mapped list, for a project board
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt *time.Time      `json:"updated_at,omitempty"`
+	Comments  []gitHubComment `json:"comments"`
+	TrelloURL string          `json:"trello_url,omitempty"`
+}
+
+type gitHubComment struct {
+	User      string    `json:"user"`
+	CreatedAt time.Time `json:"created_at"`
+	Body      string    `json:"body"`
+}
+
+/*
+writeGitHub writes every issue as one JSON array, in the shape the GitHub issues API takes
+*/
+func (m *migrationExport) writeGitHub(fileName string) error {
+
+	issues := make([]gitHubIssue, 0, len(m.issues))
+	for _, issue := range m.issues {
+		gh := gitHubIssue{
+			Title:     issue.title,
+			Body:      issue.body,
+			Labels:    append([]string{}, issue.labels...),
+			Assignees: append([]string{}, m.users("github", issue.assignees)...),
+			State:     "open",
+			Status:    m.mapping.status(issue.list),
+			CreatedAt: issue.created.UTC(),
+			UpdatedAt: issue.updated,
+			Comments:  []gitHubComment{},
+			TrelloURL: issue.url,
+		}
+		if issue.archived || m.mapping.closes(issue.list) {
+			gh.State = "closed"
+		}
+		for _, c := range issue.comments {
+			gh.Comments = append(gh.Comments, gitHubComment{User: m.users("github", []string{c.author})[0], CreatedAt: c.date.UTC(), Body: c.text})
+		}
+		issues = append(issues, gh)
+	}
+
+	data, err := json.MarshalIndent(issues, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, append(data, '\n'), SecureFileMode)
+}
+
+/*
+writeGitLab
+
+	GitLab's CSV import only takes a title, description and due date, so everything else goes
+	into the description: comments as a section, and labels, the status (as a status:: scoped
+	label), assignees and closing as quick actions, which GitLab runs when it imports the issue.
+*/
+func (m *migrationExport) writeGitLab(fileName string) error {
+
+	var rows [][]string
+	for _, issue := range m.issues {
+		var desc strings.Builder
+		desc.WriteString(issue.body)
+
+		if len(issue.comments) > 0 {
+			desc.WriteString("\n\n### Comments\n")
+			for _, c := range issue.comments {
+				author := m.users("gitlab", []string{c.author})[0]
+				fmt.Fprintf(&desc, "\n**@%s**, %s:\n\n%s\n", author, c.date.UTC().Format("2006-01-02 15:04 MST"), c.text)
+			}
+		}
+
+		var actions []string
+		labels := append(slices.Clone(issue.labels), "status::"+m.mapping.status(issue.list))
+		for _, l := range labels {
+			actions = append(actions, `/label ~"`+strings.ReplaceAll(l, `"`, "")+`"`)
+		}
+		if users := m.users("gitlab", issue.assignees); len(users) > 0 {
+			actions = append(actions, "/assign @"+strings.Join(users, " @"))
+		}
+		if issue.archived || m.mapping.closes(issue.list) {
+			actions = append(actions, "/close")
+		}
+		text := strings.TrimSpace(desc.String()) + "\n\n" + strings.Join(actions, "\n")
+
+		due := ""
+		if issue.due != nil {
+			due = issue.due.UTC().Format("2006-01-02")
+		}
+		rows = append(rows, []string{issue.title, strings.TrimSpace(text), due})
+	}
+
+	return writeCSVFile(fileName, []string{"title", "description", "due_date"}, rows)
+}
+
+// jiraTime is a time in jiraTimeFormat, UTC, empty when not set
+func jiraTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(jiraTimeFormat)
+}
+
+// repeat is n copies of s
+func repeat(s string, n int) []string {
+	out := make([]string, n)
+	for i := range out {
+		out[i] = s
+	}
+	return out
+}
+
+// padded is values followed by empty cells up to n
+func padded(values []string, n int) []string {
+	return append(slices.Clone(values), make([]string, n-len(values))...)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notifications are not Trello calls, they get their own client and a short timeout
+var notifyClient = &http.Client{Timeout: 15 * time.Second}
+
+/*
+RunSummary
+
+	What a notifier is told about a finished run.  Sent as-is by the webhook notifier.
+*/
+type RunSummary struct {
+	Version    string    `json:"version"`
+	Command    string    `json:"command"`
+	Group      string    `json:"group,omitempty"` // daemon group, empty for a one off dump
+	Status     string    `json:"status"`
+	ExitCode   int       `json:"exit_code"`
+	HadErrors  bool      `json:"had_errors"`
+	ErrorCount int       `json:"error_count"`
+	Boards     []string  `json:"boards"`
+	Output     string    `json:"output"` // storage path, board directories or archive
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+/*
+notifier sends a run summary somewhere, one per notify entry in the config file
+*/
+type notifier interface {
+	Notify(s RunSummary) error
+	String() string
+}
+
+/*
+newNotifier builds the notifier for a notify entry in the config file
+*/
+func newNotifier(nc NotifyConfig) (notifier, error) {
+	switch nc.Type {
+	case "webhook":
+		if nc.URL == "" {
+			return nil, fmt.Errorf("webhook notifier needs a url")
+		}
+		return webhookNotifier{url: nc.URL}, nil
+	case "slack", "mattermost":
+		if nc.URL == "" {
+			return nil, fmt.Errorf("%s notifier needs an incoming webhook url", nc.Type)
+		}
+		return slackNotifier{url: nc.URL, channel: nc.Channel, username: nc.Username}, nil
+	case "email":
+		if nc.SMTPHost == "" || nc.From == "" || len(nc.To) == 0 {
+			return nil, fmt.Errorf("email notifier needs smtp_host, from and to")
+		}
+		port := nc.SMTPPort
+		if port == 0 {
+			port = 587
+		}
+		return emailNotifier{
+			addr:     net.JoinHostPort(nc.SMTPHost, strconv.Itoa(port)),
+			host:     nc.SMTPHost,
+			user:     nc.SMTPUser,
+			password: config.ENV.SMTPPASSWORD,
+			from:     nc.From,
+			to:       nc.To,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown notify type %q (known: webhook, slack, mattermost, email)", nc.Type)
+	}
+}
+
+/*
+runSummary builds the summary of the run that just finished from the run report and board tracker
+*/
+func runSummary(group string, output string) RunSummary {
+	runReport.mu.Lock()
+	defer runReport.mu.Unlock()
+
+	errCount := len(runReport.Errors)
+	for _, b := range runReport.Boards {
+		errCount += len(b.Errors)
+	}
+
+	return RunSummary{
+		Version:    runReport.Version,
+		Command:    runReport.Command,
+		Group:      group,
+		Status:     runReport.Status,
+		ExitCode:   runReport.ExitCode,
+		HadErrors:  errorWarnOnCompletion,
+		ErrorCount: errCount,
+		Boards:     append([]string{}, boardTracker...),
+		Output:     output,
+		StartedAt:  runReport.StartedAt,
+		FinishedAt: runReport.FinishedAt,
+	}
+}
+
+/*
+dumpOutput describes where a dump went, the storage path(s) the boards were written under
+*/
+func dumpOutput(args ARGS) string {
+	runReport.mu.Lock()
+	defer runReport.mu.Unlock()
+
+	var roots []string
+	for _, b := range runReport.Boards {
+		if root := filepath.Dir(b.Path); !slices.Contains(roots, root) {
+			roots = append(roots, root)
+		}
+	}
+	if len(roots) == 0 {
+		return args.StoragePath
+	}
+	return strings.Join(roots, ", ")
+}
+
+/*
+sendNotifications sends the summary to every notifier, on_failure ones only when the run wasn't clean
+*/
+func sendNotifications(ncs []NotifyConfig, s RunSummary) {
+
+	failed := s.HadErrors || s.ExitCode != ExitOK
+	for _, nc := range ncs {
+		if nc.OnFailure && !failed {
+			continue
+		}
+		n, err := newNotifier(nc)
+		if err == nil {
+			err = n.Notify(s)
+		}
+		if err != nil {
+			logger("Error: Unable to send "+nc.Type+" notification: "+err.Error(), "err", true, false, config, LogFieldOp, "notify")
+			continue
+		}
+		logger("Sent notification to "+n.String(), "info", true, true, config, LogFieldOp, "notify")
+	}
+}
+
+/*
+summaryTitle is the one line version of a summary, used as the email subject and chat headline
+*/
+func summaryTitle(s RunSummary) string {
+	what := "trellgo " + s.Command
+	if s.Group != "" {
+		what += " (" + s.Group + ")"
+	}
+	switch s.Status {
+	case "success":
+		return what + " completed"
+	case "partial_failure":
+		return what + " completed with errors"
+	default:
+		return what + " FAILED"
+	}
+}
+
+/*
+summaryText is the plain text body for chat and email notifications
+*/
+func summaryText(s RunSummary) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Status: %s (exit code %d)\n", s.Status, s.ExitCode)
+	fmt.Fprintf(&b, "Errors: %d\n", s.ErrorCount)
+	fmt.Fprintf(&b, "Duration: %s\n", s.FinishedAt.Sub(s.StartedAt).Round(time.Second))
+	fmt.Fprintf(&b, "Output: %s\n", s.Output)
+	fmt.Fprintf(&b, "Boards (%d):\n", len(s.Boards))
+	for _, name := range s.Boards {
+		fmt.Fprintf(&b, " - %s\n", name)
+	}
+	if s.HadErrors {
+		b.WriteString("There were errors during the run, see the log or run report.\n")
+	}
+
+	return b.String()
+}
+
+/*
+postJSON POSTs a JSON body and treats anything but a 2xx as a failure
+*/
+func postJSON(endpoint string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	resp, err := notifyClient.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("notification endpoint answered %s", resp.Status)
+	}
+	return nil
+}
+
+/*
+webhookNotifier POSTs the RunSummary as JSON
+*/
+type webhookNotifier struct {
+	url string
+}
+
+func (w webhookNotifier) String() string {
+	return "webhook " + sanitizeURLForLogging(w.url)
+}
+
+func (w webhookNotifier) Notify(s RunSummary) error {
+	return postJSON(w.url, s)
+}
+
+/*
+slackNotifier POSTs to a Slack or Mattermost incoming webhook
+*/
+type slackNotifier struct {
+	url      string
+	channel  string
+	username string
+}
+
+func (sn slackNotifier) String() string {
+	// Incoming webhook URLs are secrets, don't log the path
+	if u, err := url.Parse(sn.url); err == nil {
+		return "chat webhook " + u.Host
+	}
+	return "chat webhook"
+}
+
+func (sn slackNotifier) Notify(s RunSummary) error {
+	msg := map[string]string{
+		"text": "*" + summaryTitle(s) + "*\n```\n" + summaryText(s) + "```",
+	}
+	if sn.channel != "" {
+		msg["channel"] = sn.channel
+	}
+	if sn.username != "" {
+		msg["username"] = sn.username
+	}
+	return postJSON(sn.url, msg)
+}
+
+/*
+emailNotifier sends a plain text email over SMTP, STARTTLS is used when the server offers it
+*/
+type emailNotifier struct {
+	addr     string
+	host     string
+	user     string
+	password string
+	from     string
+	to       []string
+}
+
+func (e emailNotifier) String() string {
+	return "email " + strings.Join(e.to, ", ") + " via " + e.addr
+}
+
+func (e emailNotifier) Notify(s RunSummary) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", summaryTitle(s))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(summaryText(s), "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if e.user != "" {
+		auth = smtp.PlainAuth("", e.user, e.password, e.host)
+	}
+
+	return smtp.SendMail(e.addr, auth, e.from, e.to, msg.Bytes())
+}
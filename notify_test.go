@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A request the HTTP stand-in received
+type notifyRequest struct {
+	path        string
+	contentType string
+	body        []byte
+}
+
+// An email the SMTP stand-in received
+type smtpMessage struct {
+	from string
+	to   []string
+	data string
+}
+
+/*
+newNotifyServer is an HTTP stand-in for webhook and chat endpoints, it keeps every request
+*/
+func newNotifyServer(t *testing.T) (*httptest.Server, func() []notifyRequest) {
+	t.Helper()
+
+	var (
+		mu   sync.Mutex
+		reqs []notifyRequest
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		reqs = append(reqs, notifyRequest{path: r.URL.Path, contentType: r.Header.Get("Content-Type"), body: body})
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, func() []notifyRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]notifyRequest{}, reqs...)
+	}
+}
+
+/*
+newSMTPServer is an SMTP stand-in that accepts any mail without TLS or auth, it keeps every message
+*/
+func newSMTPServer(t *testing.T) (string, int, func() []smtpMessage) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu   sync.Mutex
+		msgs []smtpMessage
+		wg   sync.WaitGroup
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				serveSMTP(conn, func(m smtpMessage) {
+					mu.Lock()
+					msgs = append(msgs, m)
+					mu.Unlock()
+				})
+			}()
+		}
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		wg.Wait()
+	})
+
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, func() []smtpMessage {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]smtpMessage{}, msgs...)
+	}
+}
+
+// serveSMTP speaks just enough SMTP for net/smtp.SendMail, each message is recorded before it is acknowledged
+func serveSMTP(conn net.Conn, record func(smtpMessage)) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	tp := textproto.NewConn(conn)
+	var m smtpMessage
+	_ = tp.PrintfLine("220 localhost test SMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO", "HELO":
+			_ = tp.PrintfLine("250 localhost")
+		case "MAIL":
+			m.from = strings.Trim(strings.TrimPrefix(line[len("MAIL "):], "FROM:"), "<>")
+			_ = tp.PrintfLine("250 OK")
+		case "RCPT":
+			m.to = append(m.to, strings.Trim(strings.TrimPrefix(line[len("RCPT "):], "TO:"), "<>"))
+			_ = tp.PrintfLine("250 OK")
+		case "DATA":
+			_ = tp.PrintfLine("354 go ahead")
+			data, err := io.ReadAll(tp.DotReader())
+			if err != nil {
+				return
+			}
+			m.data = string(data)
+			record(m)
+			m = smtpMessage{}
+			_ = tp.PrintfLine("250 OK")
+		case "QUIT":
+			_ = tp.PrintfLine("221 bye")
+			return
+		default:
+			_ = tp.PrintfLine("250 OK")
+		}
+	}
+}
+
+/*
+finishTestRun sets up the report for a run that has just finished, with or without errors
+*/
+func finishTestRun(t *testing.T, hadErrors bool) RunSummary {
+	t.Helper()
+
+	prevReport, prevErrors, prevTracker, prevConfig := runReport, errorWarnOnCompletion, boardTracker, config
+	t.Cleanup(func() {
+		runReport, errorWarnOnCompletion, boardTracker, config = prevReport, prevErrors, prevTracker, prevConfig
+	})
+	config.ARGS.SuperQuiet = true
+
+	runReport = newRunReport()
+	boardTracker = []string{"Roadmap", "Support"}
+	errorWarnOnCompletion = hadErrors
+	finishReport("dump", exitCode(nil))
+
+	return runSummary("nightly", "/backups/trello")
+}
+
+func TestWebhookNotifierPayload(t *testing.T) {
+	srv, received := newNotifyServer(t)
+	s := finishTestRun(t, true)
+
+	sendNotifications([]NotifyConfig{{Type: "webhook", URL: srv.URL + "/hook"}}, s)
+
+	reqs := received()
+	if len(reqs) != 1 {
+		t.Fatalf("got %d requests, want 1", len(reqs))
+	}
+	if reqs[0].path != "/hook" || reqs[0].contentType != "application/json" {
+		t.Errorf("got POST %s as %q, want /hook as application/json", reqs[0].path, reqs[0].contentType)
+	}
+
+	var got RunSummary
+	if err := json.Unmarshal(reqs[0].body, &got); err != nil {
+		t.Fatalf("payload is not a RunSummary: %v\n%s", err, reqs[0].body)
+	}
+	if got.Command != "dump" || got.Group != "nightly" || got.Status != "partial_failure" || got.ExitCode != ExitPartial ||
+		!got.HadErrors || got.Output != "/backups/trello" || strings.Join(got.Boards, ",") != "Roadmap,Support" {
+		t.Errorf("payload = %+v", got)
+	}
+
+	// Field names are what receivers key on
+	var fields map[string]any
+	_ = json.Unmarshal(reqs[0].body, &fields)
+	for _, k := range []string{"version", "command", "group", "status", "exit_code", "had_errors", "error_count", "boards", "output", "started_at", "finished_at"} {
+		if _, ok := fields[k]; !ok {
+			t.Errorf("payload has no %q field", k)
+		}
+	}
+}
+
+func TestChatNotifierPayload(t *testing.T) {
+	srv, received := newNotifyServer(t)
+	s := finishTestRun(t, false)
+
+	sendNotifications([]NotifyConfig{
+		{Type: "slack", URL: srv.URL + "/slack", Channel: "#backups", Username: "trellgo"},
+		{Type: "mattermost", URL: srv.URL + "/mattermost"},
+	}, s)
+
+	reqs := received()
+	if len(reqs) != 2 {
+		t.Fatalf("got %d requests, want 2", len(reqs))
+	}
+	for _, r := range reqs {
+		var msg map[string]string
+		if err := json.Unmarshal(r.body, &msg); err != nil {
+			t.Fatalf("%s payload: %v\n%s", r.path, err, r.body)
+		}
+		if !strings.HasPrefix(msg["text"], "*trellgo dump (nightly) completed*\n") || !strings.Contains(msg["text"], " - Support\n") {
+			t.Errorf("%s text = %q", r.path, msg["text"])
+		}
+		switch r.path {
+		case "/slack":
+			if msg["channel"] != "#backups" || msg["username"] != "trellgo" {
+				t.Errorf("slack channel/username = %q/%q", msg["channel"], msg["username"])
+			}
+		case "/mattermost":
+			if _, ok := msg["channel"]; ok {
+				t.Errorf("mattermost payload has a channel when none was set: %s", r.body)
+			}
+		}
+	}
+}
+
+func TestEmailNotifier(t *testing.T) {
+	host, port, received := newSMTPServer(t)
+	s := finishTestRun(t, true)
+
+	sendNotifications([]NotifyConfig{{Type: "email", SMTPHost: host, SMTPPort: port, From: "trellgo@example.com", To: []string{"ops@example.com", "me@example.com"}}}, s)
+
+	msgs := received()
+	if len(msgs) != 1 {
+		t.Fatalf("got %d emails, want 1", len(msgs))
+	}
+	m := msgs[0]
+	if m.from != "trellgo@example.com" || strings.Join(m.to, ",") != "ops@example.com,me@example.com" {
+		t.Errorf("envelope from %q to %v", m.from, m.to)
+	}
+	// DotReader has already turned the CRLFs into newlines
+	for _, want := range []string{"Subject: trellgo dump (nightly) completed with errors\n", "To: ops@example.com, me@example.com\n", "Status: partial_failure (exit code 2)\n"} {
+		if !strings.Contains(m.data, want) {
+			t.Errorf("email has no %q:\n%s", want, m.data)
+		}
+	}
+}
+
+func TestNotifyOnFailure(t *testing.T) {
+	srv, received := newNotifyServer(t)
+	host, port, emails := newSMTPServer(t)
+	ncs := []NotifyConfig{
+		{Type: "webhook", OnFailure: true, URL: srv.URL + "/hook"},
+		{Type: "slack", OnFailure: true, URL: srv.URL + "/slack"},
+		{Type: "email", OnFailure: true, SMTPHost: host, SMTPPort: port, From: "trellgo@example.com", To: []string{"ops@example.com"}},
+	}
+
+	sendNotifications(ncs, finishTestRun(t, false))
+	if n, e := len(received()), len(emails()); n != 0 || e != 0 {
+		t.Fatalf("clean run sent %d HTTP and %d email notification(s), want none", n, e)
+	}
+
+	sendNotifications(ncs, finishTestRun(t, true))
+	if n, e := len(received()), len(emails()); n != 2 || e != 1 {
+		t.Fatalf("failed run sent %d HTTP and %d email notification(s), want 2 and 1", n, e)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/adlio/trello"
+)
+
+// Per list index of the cards in board order, written with --ordered
+const CardOrderFile = "CardOrder.md"
+
+// Digits the whole part of a position is padded to.  Positions are float64s, 16 digits covers every whole number they hold exactly.
+const PosPrefixWidth = 16
+
+// A --ordered position prefix, ie "0000000000016384 " or "0000000000024576.5 "
+var posPrefixRe = regexp.MustCompile(`^[0-9]{16}(\.[0-9]+)? `)
+
+/*
+posPrefix
+
+	Zero padded prefix made from a Trello position.  The position itself is used, not a
+	rank, so cards added or moved later never change the prefix of any other card.
+	Trello puts an inserted card halfway between its neighbours, the fractional part is
+	kept so it still sorts between them.
+*/
+func posPrefix(pos float64, bitSize int) string {
+	if pos < 0 {
+		pos = 0
+	}
+	s := strconv.FormatFloat(pos, 'f', -1, bitSize)
+	whole, frac, _ := strings.Cut(s, ".")
+	if len(whole) < PosPrefixWidth {
+		whole = strings.Repeat("0", PosPrefixWidth-len(whole)) + whole
+	}
+	if frac != "" {
+		return whole + "." + frac + " "
+	}
+	return whole + " "
+}
+
+/*
+listDirName is the directory name for a list, position prefixed with --ordered
+*/
+func listDirName(list *trello.List, config Config) string {
+	name := SanitizePathName(list.Name)
+	if config.ARGS.Ordered {
+		// List.Pos is a float32 in the client, format it as one so no noise digits show up
+		return fitName(posPrefix(float64(list.Pos), 32), name, "")
+	}
+	return name
+}
+
+/*
+cardDirName is the directory name for a regular card, "Card Name [shortLink]", position prefixed with --ordered
+*/
+func cardDirName(card *trello.Card, config Config) string {
+	prefix := ""
+	if config.ARGS.Ordered {
+		prefix = posPrefix(card.Pos, 64)
+	}
+	return fitName(prefix, SanitizePathName(card.Name), " "+cardIDSuffix(card))
+}
+
+// fitName keeps a prefixed/suffixed name inside the same 240 character limit SanitizePathName uses
+func fitName(prefix string, name string, suffix string) string {
+	if len(prefix)+len(name)+len(suffix) > 240 {
+		name = strings.TrimRight(truncateName(name, 240-len(prefix)-len(suffix)), " ._-")
+	}
+	return prefix + name + suffix
+}
+
+/*
+stripPosPrefix takes the --ordered prefix back off a directory name
+*/
+func stripPosPrefix(name string) string {
+	return posPrefixRe.ReplaceAllString(name, "")
+}
+
+/*
+writeCardOrderIndexes
+
+	Write CardOrder.md into each list directory, the list's cards in board order with
+	where each one was written.  Only lists that have a directory from this dump get one.
+*/
+func writeCardOrderIndexes(board *trello.Board, cards []*trello.Card, listCache map[string]*trello.List, boardPath string, config Config) {
+
+	byList := make(map[string][]*trello.Card)
+	for _, card := range cards {
+		byList[card.IDList] = append(byList[card.IDList], card)
+	}
+
+	for listID, listCards := range byList {
+		list, ok := listCache[listID]
+		if !ok {
+			continue
+		}
+		cleanListPath := listDirName(list, config)
+		listDir := filepath.Join(config.ARGS.StoragePath, boardPath, cleanListPath)
+		if _, err := os.Stat(listDir); err != nil {
+			continue
+		}
+
+		sort.SliceStable(listCards, func(i, j int) bool { return listCards[i].Pos < listCards[j].Pos })
+
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "# %s\n\n", list.Name)
+		fmt.Fprintf(&buf, "List position on board: %s\n\n", strconv.FormatFloat(float64(list.Pos), 'f', -1, 32))
+		buf.WriteString("| # | Position | Card | Location |\n")
+		buf.WriteString("| --- | --- | --- | --- |\n")
+		for i, card := range listCards {
+			name := card.Name
+			if card.Closed {
+				name += " (ARCHIVED)"
+			}
+			fmt.Fprintf(&buf, "| %d | %s | %s | %s |\n", i+1, strconv.FormatFloat(card.Pos, 'f', -1, 64), mdCell(name),
+				cardOrderLocation(card, config, boardPath, cleanListPath, listDir))
+		}
+
+		fileName := filepath.Join(listDir, CardOrderFile)
+		if err := os.WriteFile(fileName, buf.Bytes(), SecureFileMode); err != nil {
+			logger("Error: Unable to write card order index "+fileName+": "+err.Error(), "err", true, false, config, LogFieldBoard, board.ID, LogFieldList, list.Name, LogFieldOp, "dump")
+			continue
+		}
+		logger("Created card order index: "+fileName, "info", true, true, config, LogFieldBoard, board.ID, LogFieldList, list.Name, LogFieldOp, "dump")
+	}
+}
+
+/*
+cardOrderLocation is a markdown link from the list directory to where a card was written, a card directory or link card file
+*/
+func cardOrderLocation(card *trello.Card, config Config, boardPath, cleanListPath, listDir string) string {
+
+	target := cardDirPath(card, config, boardPath, cleanListPath)
+	if _, err := os.Stat(target); err != nil {
+		target = linkCardFilePath(card, config, boardPath, cleanListPath)
+		if _, err := os.Stat(target); err != nil {
+			return "not dumped"
+		}
+	}
+
+	rel, err := filepath.Rel(listDir, target)
+	if err != nil {
+		return ""
+	}
+	rel = filepath.ToSlash(rel)
+	return fmt.Sprintf("[%s](%s)", mdCell(rel), strings.ReplaceAll(rel, " ", "%20"))
+}
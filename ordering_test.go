@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/adlio/trello"
+)
+
+func TestCardDirNameLongMultibyte(t *testing.T) {
+	ordered := Config{ARGS: ARGS{Ordered: true}}
+
+	tests := []struct {
+		name     string
+		cardName string
+	}{
+		{"CJK", strings.Repeat("看板のカード", 30)},
+		{"emoji", strings.Repeat("🚀", 100)},
+		{"accents", "x" + strings.Repeat("é", 150)},
+		{"mixed", strings.Repeat("a", 237) + "日本語のタイトル"},
+	}
+	for _, tt := range tests {
+		card := &trello.Card{ID: testTrelloID(1), ShortLink: "Xy12Ab", Name: tt.cardName, Pos: 16384}
+		for _, cfg := range []Config{{}, ordered} {
+			got := cardDirName(card, cfg)
+			if !utf8.ValidString(got) {
+				t.Errorf("%s (ordered %v): %q is not valid UTF-8", tt.name, cfg.ARGS.Ordered, got)
+			}
+			if len(got) > 240 {
+				t.Errorf("%s (ordered %v): %d bytes, want at most 240", tt.name, cfg.ARGS.Ordered, len(got))
+			}
+			if !strings.HasSuffix(got, " [Xy12Ab]") {
+				t.Errorf("%s (ordered %v): %q lost its short link", tt.name, cfg.ARGS.Ordered, got)
+			}
+			// Every run has to come up with the same name for the state to match it
+			if again := cardDirName(card, cfg); again != got {
+				t.Errorf("%s (ordered %v): named %q then %q", tt.name, cfg.ARGS.Ordered, got, again)
+			}
+		}
+	}
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Default archive name, same as board-dumper.sh used with the group added
+const DefaultArchiveName = "trello-board-dump-{group}-{date}.tar.gz"
+
+/*
+shipper
+
+	Sends a finished backup somewhere off the box, either an archive file or a board
+	directory tree (copied under its own name).  Each ship entry in the config file is one shipper.
+*/
+type shipper interface {
+	Ship(localPath string) error
+	String() string
+}
+
+/*
+newShipper builds the shipper for a ship entry in the config file
+*/
+func newShipper(sc ShipConfig) (shipper, error) {
+	switch sc.Type {
+	case "dir":
+		if sc.Path == "" {
+			return nil, fmt.Errorf("dir shipper needs a path")
+		}
+		return dirShipper{path: sc.Path, mirror: sc.Mirror}, nil
+	case "sftp":
+		return newSFTPShipper(sc)
+	case "webdav":
+		return newWebDAVShipper(sc)
+	default:
+		return nil, fmt.Errorf("unknown ship type %q (known: dir, sftp, webdav)", sc.Type)
+	}
+}
+
+/*
+shipAll sends localPath to every ship entry, false if any of them failed
+*/
+func shipAll(scs []ShipConfig, localPath string, fields ...any) bool {
+
+	ok := true
+	for _, sc := range scs {
+		s, err := newShipper(sc)
+		if err == nil {
+			logger("Shipping "+localPath+" to "+s.String(), "info", true, false, config, append(fields, LogFieldOp, "ship")...)
+			err = s.Ship(localPath)
+		}
+		if err != nil {
+			logger("Error: Unable to ship "+localPath+": "+err.Error(), "err", true, false, config, append(fields, LogFieldOp, "ship")...)
+			ok = false
+		}
+	}
+
+	return ok
+}
+
+/*
+dirShipper copies into a directory, ie a mounted NAS share, and with mirror on removes what the local tree no longer has
+*/
+type dirShipper struct {
+	path   string
+	mirror bool
+}
+
+func (d dirShipper) String() string {
+	return "dir " + d.path
+}
+
+func (d dirShipper) Ship(localPath string) error {
+	err := walkShipFiles(localPath, func(fileName string, rel string) error {
+		return copyFileAtomic(fileName, filepath.Join(d.path, filepath.FromSlash(rel)))
+	})
+	if err != nil || !d.mirror {
+		return err
+	}
+
+	removed, err := removeStale(localPath, func(rel string) (map[string]bool, error) {
+		entries, err := os.ReadDir(filepath.Join(d.path, filepath.FromSlash(rel)))
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		listing := make(map[string]bool, len(entries))
+		for _, e := range entries {
+			listing[e.Name()] = e.IsDir()
+		}
+		return listing, err
+	}, func(rel string, dir bool) error {
+		return os.RemoveAll(filepath.Join(d.path, filepath.FromSlash(rel)))
+	})
+	logger(fmt.Sprintf("Mirror: %d stale file(s) or directories removed from %s", removed, d), "info", true, true, config, LogFieldOp, "ship")
+	return err
+}
+
+/*
+removeStale
+
+	For mirror: true, walk a shipped directory at the destination and remove whatever isn't
+	in the local one any more (renamed, moved or deleted cards and lists).  Only the tree under
+	localPath's own name is looked at.  list gives a destination directory's entries (name, is a
+	directory), a missing one has none.  remove takes out a file or a whole directory.
+*/
+func removeStale(localPath string, list func(rel string) (map[string]bool, error), remove func(rel string, dir bool) error) (int, error) {
+
+	info, err := os.Stat(localPath)
+	if err != nil || !info.IsDir() {
+		return 0, err
+	}
+
+	// Everything walkShipFiles sent, files and the directories they are in
+	tree := make(map[string]bool)
+	err = walkShipFiles(localPath, func(_ string, rel string) error {
+		for p := rel; p != "."; p = path.Dir(p) {
+			tree[p] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	var walk func(rel string) error
+	walk = func(rel string) error {
+		entries, err := list(rel)
+		if err != nil {
+			return err
+		}
+		for name, dir := range entries {
+			child := path.Join(rel, name)
+			switch {
+			case !tree[child]:
+				if err := remove(child, dir); err != nil {
+					return fmt.Errorf("removing %s: %w", child, err)
+				}
+				removed++
+			case dir:
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	return removed, walk(filepath.Base(filepath.Clean(localPath)))
+}
+
+/*
+walkShipFiles calls fn for every regular file to ship, with its path relative to the destination.
+A single file is shipped under its base name, a directory under its base name plus the tree below it.
+*/
+func walkShipFiles(localPath string, fn func(fileName string, rel string) error) error {
+	parent := filepath.Dir(filepath.Clean(localPath))
+
+	return filepath.WalkDir(localPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(parent, p)
+		if err != nil {
+			return err
+		}
+		return fn(p, filepath.ToSlash(rel))
+	})
+}
+
+/*
+copyFileAtomic copies a file through a temp name, so a half copied file never looks finished
+*/
+func copyFileAtomic(src string, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), SecureDirMode); err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(dst), ".trellgo-ship-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(out.Name(), dst)
+}
+
+/*
+runPipeline
+
+	Post run steps for a daemon group: archive, ship, prune, notify.
+	A failed step stops anything after it that would lose data (no prune without a shipped archive).
+*/
+func runPipeline(g BoardGroup, runErr error) {
+
+	p := g.Pipeline
+
+	// Board directories written by this run
+	var dirs []string
+	runReport.mu.Lock()
+	for _, b := range runReport.Boards {
+		dirs = append(dirs, b.Path)
+	}
+	runReport.mu.Unlock()
+
+	var (
+		archive string
+		safe    = true // ok to delete the raw dump
+	)
+
+	if p.Archive.Dir != "" && len(dirs) > 0 {
+		archive = filepath.Join(p.Archive.Dir, archiveName(p.Archive.Name, g.Name, time.Now()))
+		logger("Archiving group "+g.Name+" to "+archive, "info", true, false, config, LogFieldOp, "archive")
+		if err := archiveDirs(archive, dirs); err != nil {
+			logger("Error: Unable to archive group "+g.Name+": "+err.Error(), "err", true, false, config, LogFieldOp, "archive")
+			archive = ""
+			safe = false
+		}
+	}
+
+	if archive != "" && !shipAll(p.Ship, archive) {
+		safe = false
+	}
+	// Only the archive, the board directories hold the state the next run needs
+	if archive != "" && safe && slices.ContainsFunc(p.Ship, func(sc ShipConfig) bool { return sc.DeleteLocal }) {
+		logger("Shipped, removing local archive "+archive, "info", true, false, config, LogFieldOp, "ship")
+		if err := os.Remove(archive); err != nil {
+			logger("Error: Unable to remove "+archive+": "+err.Error(), "err", true, false, config, LogFieldOp, "ship")
+		}
+	}
+
+	if p.Prune.RemoveDump && archive != "" && safe {
+		for _, dir := range dirs {
+			logger("Removing raw board dump "+dir, "info", true, true, config, LogFieldOp, "prune")
+			if err := removeBoardDump(dir); err != nil {
+				logger("Error: Unable to remove "+dir+": "+err.Error(), "err", true, false, config, LogFieldOp, "prune")
+			}
+		}
+	}
+	if p.Prune.KeepArchives > 0 && p.Archive.Dir != "" {
+		pruneArchives(p.Archive, g.Name, p.Prune.KeepArchives)
+	}
+
+	notifyGroup(g, archive, runErr)
+}
+
+/*
+archiveName fills in {group} and {date} (mm-dd-yyyy, like board-dumper.sh) in an archive name
+*/
+func archiveName(pattern string, group string, t time.Time) string {
+	if pattern == "" {
+		pattern = DefaultArchiveName
+	}
+	return strings.NewReplacer("{group}", group, "{date}", t.Format("01-02-2006")).Replace(pattern)
+}
+
+/*
+removeBoardDump
+
+	Remove a board directory's lists and cards once they are archived.  The board state
+	and DELETED/ stay, so the next run still follows renames and moves and notices deletions.
+*/
+func removeBoardDump(dir string) error {
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Name() == BoardStateFile || e.Name() == DeletedDir {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+archiveDirs writes a .tar.gz holding each directory under its own base name
+*/
+func archiveDirs(fileName string, dirs []string) error {
+
+	if err := os.MkdirAll(filepath.Dir(fileName), SecureDirMode); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(fileName), ".trellgo-archive-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	gz := gzip.NewWriter(tmp)
+	tw := tar.NewWriter(gz)
+
+	for _, dir := range dirs {
+		parent := filepath.Dir(dir)
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() && !info.IsDir() {
+				return nil
+			}
+
+			rel, err := filepath.Rel(parent, path)
+			if err != nil {
+				return err
+			}
+			hdr, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return err
+			}
+			hdr.Name = filepath.ToSlash(rel)
+			if info.IsDir() {
+				hdr.Name += "/"
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(tw, f)
+			return err
+		})
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), fileName)
+}
+
+/*
+pruneArchives deletes the oldest archives for a group, keeping the newest keep
+*/
+func pruneArchives(ac ArchiveConfig, group string, keep int) {
+
+	name := ac.Name
+	if name == "" {
+		name = DefaultArchiveName
+	}
+	pattern := filepath.Join(ac.Dir, strings.NewReplacer("{group}", group, "{date}", "*").Replace(name))
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil || len(matches) <= keep {
+		return
+	}
+
+	type archiveFile struct {
+		name string
+		mod  time.Time
+	}
+	var files []archiveFile
+	for _, m := range matches {
+		if info, err := os.Stat(m); err == nil && info.Mode().IsRegular() {
+			files = append(files, archiveFile{name: m, mod: info.ModTime()})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].mod.After(files[j].mod) })
+
+	for i := keep; i < len(files); i++ {
+		logger("Pruning old archive "+files[i].name, "info", true, true, config, LogFieldOp, "prune")
+		if err := os.Remove(files[i].name); err != nil {
+			logger("Error: Unable to remove old archive "+files[i].name+": "+err.Error(), "err", true, false, config, LogFieldOp, "prune")
+		}
+	}
+}
+
+/*
+notifyGroup sends the group's run summary, using the top level notify list unless the group has its own
+*/
+func notifyGroup(g BoardGroup, archive string, runErr error) {
+
+	output := archive
+	if output == "" {
+		output = dumpOutput(config.ARGS)
+	}
+
+	s := runSummary(g.Name, output)
+	msg := fmt.Sprintf("Group %s finished: %s, %d board(s), %d error(s)", g.Name, s.Status, len(s.Boards), s.ErrorCount)
+	state := "info"
+	if runErr != nil || s.HadErrors {
+		state = "warn"
+	}
+	logger(msg, state, true, false, config, LogFieldOp, "notify")
+
+	ncs := g.Pipeline.Notify
+	if ncs == nil && config.ARGS.fileConfig != nil {
+		ncs = config.ARGS.fileConfig.Notify
+	}
+	sendNotifications(ncs, s)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// shippedFiles lists the files under root, slash separated and sorted
+func shippedFiles(t *testing.T, root string) []string {
+	t.Helper()
+
+	var files []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			rel, _ := filepath.Rel(root, p)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(files)
+	return files
+}
+
+/*
+testMirror ships a board tree to dest, the directory the shipper writes into, then renames a card,
+moves one to another list and retires one under DELETED/ the way a dump does and ships again.
+With mirror on dest has to end up with exactly the local board, with it off nothing is removed.
+Another board already at dest is never touched.
+*/
+func testMirror(t *testing.T, s shipper, dest string, mirror bool) {
+	t.Helper()
+
+	local := filepath.Join(t.TempDir(), "Board")
+	mod := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, f := range []string{
+		BoardStateFile,
+		"To Do/Card A [aaa]/card.md",
+		"To Do/Card B [bbb]/card.md",
+		"To Do/Card B [bbb]/attachments/spec.pdf",
+		"Doing/Card C [ccc]/card.md",
+	} {
+		writeTestFile(t, filepath.Join(local, filepath.FromSlash(f)), []byte(f+"\n"), mod)
+	}
+	writeTestFile(t, filepath.Join(dest, "Other board", "List", "card.md"), []byte("not ours\n"), mod)
+
+	if err := s.Ship(local); err != nil {
+		t.Fatal(err)
+	}
+	first := shippedFiles(t, filepath.Join(dest, "Board"))
+
+	move := func(from string, to string) {
+		t.Helper()
+		to = filepath.Join(local, filepath.FromSlash(to))
+		if err := os.MkdirAll(filepath.Dir(to), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(filepath.Join(local, filepath.FromSlash(from)), to); err != nil {
+			t.Fatal(err)
+		}
+	}
+	move("To Do/Card A [aaa]", "To Do/Card A renamed [aaa]")
+	move("To Do/Card B [bbb]", "Doing/Card B [bbb]")
+	move("Doing/Card C [ccc]", DeletedDir+"/Doing/Card C [ccc]")
+	writeTestFile(t, filepath.Join(local, DeletedDir, "Doing", "Card C [ccc]", TombstoneFile), []byte("gone\n"), mod)
+
+	if err := s.Ship(local); err != nil {
+		t.Fatal(err)
+	}
+
+	got := shippedFiles(t, filepath.Join(dest, "Board"))
+	want := shippedFiles(t, local)
+	if !mirror {
+		// The first upload is all still there alongside the second
+		want = slices.Compact(slices.Sorted(slices.Values(append(want, first...))))
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("destination has\n  %s\nwant\n  %s", strings.Join(got, "\n  "), strings.Join(want, "\n  "))
+	}
+	if mirror {
+		if _, err := os.Stat(filepath.Join(dest, "Board", "To Do", "Card A [aaa]")); !os.IsNotExist(err) {
+			t.Errorf("renamed card's old directory is still at the destination: %v", err)
+		}
+	}
+	if got := readTestFile(t, filepath.Join(dest, "Other board", "List", "card.md")); got != "not ours\n" {
+		t.Errorf("another board at the destination was changed: %q", got)
+	}
+}
+
+func TestDirShipperMirror(t *testing.T) {
+	dest := t.TempDir()
+	s, err := newShipper(ShipConfig{Type: "dir", Path: dest, Mirror: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testMirror(t, s, dest, true)
+}
+
+func TestDirShipperNoMirror(t *testing.T) {
+	dest := t.TempDir()
+	s, err := newShipper(ShipConfig{Type: "dir", Path: dest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testMirror(t, s, dest, false)
+}
+
+func TestMirrorOnlyForBoardTrees(t *testing.T) {
+	fc := &FileConfig{
+		Ship: []ShipConfig{{Type: "dir", Path: "/mnt/share", Mirror: true}},
+		Daemon: DaemonConfig{Groups: []BoardGroup{{
+			Name: "nightly", Schedule: "@daily", Boards: []string{"abc"},
+			Pipeline: PipelineConfig{
+				Archive: ArchiveConfig{Dir: "/opt/archives"},
+				Ship:    []ShipConfig{{Type: "dir", Path: "/mnt/archives", Mirror: true}},
+			},
+		}}},
+	}
+
+	var mirrorErrs []string
+	for _, err := range validateConfigFile(fc, "/opt/trellgo") {
+		if strings.Contains(err.Error(), "mirror") {
+			mirrorErrs = append(mirrorErrs, err.Error())
+		}
+	}
+	if len(mirrorErrs) != 1 || !strings.Contains(mirrorErrs[0], "ship[0]: mirror only applies to top level ship entries") {
+		t.Errorf("mirror errors = %q, want only the pipeline ship entry rejected", mirrorErrs)
+	}
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Process exit codes, so wrappers can tell a partial failure from a total one
+const (
+	ExitOK      = 0 // Everything worked
+	ExitFatal   = 1 // Nothing useful was done (bad arguments, no API access, etc)
+	ExitPartial = 2 // Run finished, but some boards/cards/attachments failed
+)
+
+/*
+RunReport
+
+	Machine readable summary of a run, written with -report / -report-md
+*/
+type RunReport struct {
+	Version         string         `json:"version"`
+	Command         string         `json:"command"`
+	StartedAt       time.Time      `json:"started_at"`
+	FinishedAt      time.Time      `json:"finished_at"`
+	DurationSeconds float64        `json:"duration_seconds"`
+	Status          string         `json:"status"`
+	ExitCode        int            `json:"exit_code"`
+	APICalls        int64          `json:"api_calls"`
+	Boards          []*BoardReport `json:"boards"`
+	DeletedBoards   []DeletedItem  `json:"deleted_boards"` // boards dumped before that are gone from Trello
+	Errors          []ReportError  `json:"errors"`         // errors not tied to a board that was started
+
+	mu      sync.Mutex
+	byBoard map[string]*BoardReport
+}
+
+/*
+BoardReport holds the per board numbers in a RunReport
+*/
+type BoardReport struct {
+	ID              string              `json:"id"`
+	Name            string              `json:"name"`
+	Path            string              `json:"path"`
+	StartedAt       time.Time           `json:"started_at"`
+	DurationSeconds float64             `json:"duration_seconds"`
+	CardsFound      int                 `json:"cards_found"`
+	CardsProcessed  int64               `json:"cards_processed"`
+	CardsSkipped    int64               `json:"cards_skipped"`
+	Attachments     int64               `json:"attachments_downloaded"`
+	AttachmentBytes int64               `json:"attachment_bytes"`
+	APICalls        int64               `json:"api_calls"`
+	Deleted         []DeletedItem       `json:"deleted"`             // cards and lists found gone from Trello this run
+	Skipped         []SkippedAttachment `json:"skipped_attachments"` // uploads not downloaded because of the attachment limits
+	Errors          []ReportError       `json:"errors"`
+
+	apiCallsAtStart int64
+}
+
+/*
+SkippedAttachment is an upload the attachment size or type limits kept from being downloaded
+*/
+type SkippedAttachment struct {
+	CardID   string `json:"card_id"`
+	Card     string `json:"card"`
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Bytes    int    `json:"bytes"`
+	MimeType string `json:"mime_type"`
+	Reason   string `json:"reason"`
+}
+
+/*
+ReportError is a single error logged during the run
+*/
+type ReportError struct {
+	Time    time.Time `json:"time"`
+	BoardID string    `json:"board_id,omitempty"`
+	CardID  string    `json:"card_id,omitempty"`
+	List    string    `json:"list,omitempty"`
+	Op      string    `json:"op,omitempty"`
+	Message string    `json:"message"`
+}
+
+var (
+	runReport = newRunReport()
+	apiCalls  atomic.Int64 // every HTTP request made to Trello, API and downloads
+)
+
+func newRunReport() *RunReport {
+	return &RunReport{
+		StartedAt:     time.Now(),
+		Boards:        []*BoardReport{},
+		DeletedBoards: []DeletedItem{},
+		Errors:        []ReportError{},
+		byBoard:       make(map[string]*BoardReport),
+	}
+}
+
+/*
+reportBoardStart starts the per board section of the report, boards are dumped one at a time
+*/
+func reportBoardStart(id string, name string, path string) *BoardReport {
+	runReport.mu.Lock()
+	defer runReport.mu.Unlock()
+
+	b := &BoardReport{ID: id, Name: name, Path: path, StartedAt: time.Now(), Deleted: []DeletedItem{}, Skipped: []SkippedAttachment{}, Errors: []ReportError{}, apiCallsAtStart: apiCalls.Load()}
+	runReport.Boards = append(runReport.Boards, b)
+	runReport.byBoard[id] = b
+
+	return b
+}
+
+/*
+reportBoardEnd closes out the timing and API call count for a board
+*/
+func reportBoardEnd(b *BoardReport) {
+	runReport.mu.Lock()
+	defer runReport.mu.Unlock()
+
+	b.DurationSeconds = time.Since(b.StartedAt).Seconds()
+	b.APICalls = apiCalls.Load() - b.apiCallsAtStart
+
+	// Clean board backup, feeds trellgo_last_success_timestamp_seconds
+	if len(b.Errors) == 0 && b.CardsSkipped == 0 {
+		metricBoardSuccess(b.ID, b.Name, time.Now())
+	}
+}
+
+/*
+reportBoard returns the report for a board ID, or nil if that board hasn't been started
+*/
+func reportBoard(id string) *BoardReport {
+	runReport.mu.Lock()
+	defer runReport.mu.Unlock()
+
+	return runReport.byBoard[id]
+}
+
+/*
+reportCardsFound records how many cards a board has to process
+*/
+func reportCardsFound(boardID string, n int) {
+	if b := reportBoard(boardID); b != nil {
+		runReport.mu.Lock()
+		b.CardsFound = n
+		runReport.mu.Unlock()
+	}
+}
+
+/*
+reportCard records the outcome of one card, called from the card workers
+*/
+func reportCard(boardID string, err error) {
+	b := reportBoard(boardID)
+	if b == nil {
+		return
+	}
+	if err != nil {
+		atomic.AddInt64(&b.CardsSkipped, 1)
+	} else {
+		atomic.AddInt64(&b.CardsProcessed, 1)
+	}
+}
+
+/*
+reportAttachment records a downloaded attachment and its size
+*/
+func reportAttachment(boardID string, size int64) {
+	if b := reportBoard(boardID); b != nil {
+		atomic.AddInt64(&b.Attachments, 1)
+		atomic.AddInt64(&b.AttachmentBytes, size)
+	}
+}
+
+/*
+reportSkippedAttachment records an upload that wasn't downloaded, called from the card workers
+*/
+func reportSkippedAttachment(boardID string, item SkippedAttachment) {
+	if b := reportBoard(boardID); b != nil {
+		runReport.mu.Lock()
+		b.Skipped = append(b.Skipped, item)
+		runReport.mu.Unlock()
+	}
+}
+
+/*
+skippedSummary is one line per board that had attachments skipped, for the end of run output
+*/
+func skippedSummary() []string {
+	runReport.mu.Lock()
+	defer runReport.mu.Unlock()
+
+	var lines []string
+	for _, b := range runReport.Boards {
+		if len(b.Skipped) == 0 {
+			continue
+		}
+		reasons := make(map[string]int)
+		var size int
+		for _, s := range b.Skipped {
+			reasons[s.Reason]++
+			size += s.Bytes
+		}
+		var parts []string
+		for _, reason := range []string{SkipTooLarge, SkipBudget, SkipDenied, SkipNotAllowed} {
+			if reasons[reason] > 0 {
+				parts = append(parts, fmt.Sprintf("%d %s", reasons[reason], reason))
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%s: %d attachments (%s) not downloaded, %s", b.Name, len(b.Skipped), formatBytes(size), strings.Join(parts, ", ")))
+	}
+
+	return lines
+}
+
+/*
+reportDeleted records a card or list found gone from Trello
+*/
+func reportDeleted(boardID string, item DeletedItem) {
+	if b := reportBoard(boardID); b != nil {
+		runReport.mu.Lock()
+		b.Deleted = append(b.Deleted, item)
+		runReport.mu.Unlock()
+	}
+}
+
+/*
+reportDeletedBoard records a board found gone from Trello
+*/
+func reportDeletedBoard(item DeletedItem) {
+	runReport.mu.Lock()
+	defer runReport.mu.Unlock()
+
+	runReport.DeletedBoards = append(runReport.DeletedBoards, item)
+}
+
+/*
+deletedSummary is one line per card, list or board found gone this run, for the end of run output
+*/
+func deletedSummary() []string {
+	runReport.mu.Lock()
+	defer runReport.mu.Unlock()
+
+	var lines []string
+	for _, b := range runReport.Boards {
+		for _, d := range b.Deleted {
+			lines = append(lines, fmt.Sprintf("%s: %s %s (%s), %s", b.Name, d.Type, d.Name, d.Reason, d.Action))
+		}
+	}
+	for _, d := range runReport.DeletedBoards {
+		lines = append(lines, fmt.Sprintf("board %s (%s), %s", d.Name, d.Reason, d.Action))
+	}
+
+	return lines
+}
+
+/*
+reportLoggedError
+
+	Called by logger() for every error, the structured fields tell us which board and card it belongs to
+*/
+func reportLoggedError(message string, fields []any) {
+
+	e := ReportError{Time: time.Now(), Message: message}
+	for i := 0; i+1 < len(fields); i += 2 {
+		v := fmt.Sprint(fields[i+1])
+		switch fields[i] {
+		case LogFieldBoard:
+			e.BoardID = v
+		case LogFieldCard:
+			e.CardID = v
+		case LogFieldList:
+			e.List = v
+		case LogFieldOp:
+			e.Op = v
+		}
+	}
+
+	metricError(e.Op)
+
+	runReport.mu.Lock()
+	defer runReport.mu.Unlock()
+
+	if b, ok := runReport.byBoard[e.BoardID]; ok {
+		b.Errors = append(b.Errors, e)
+		return
+	}
+	runReport.Errors = append(runReport.Errors, e)
+}
+
+/*
+exitCode works out the process exit code from how the run went
+*/
+func exitCode(fatal error) int {
+	switch {
+	case fatal != nil:
+		return ExitFatal
+	case errorWarnOnCompletion:
+		return ExitPartial
+	default:
+		return ExitOK
+	}
+}
+
+/*
+finishReport stamps the end of run details onto the report
+*/
+func finishReport(command string, code int) {
+	runReport.mu.Lock()
+	defer runReport.mu.Unlock()
+
+	runReport.Version = version
+	runReport.Command = command
+	runReport.FinishedAt = time.Now()
+	runReport.DurationSeconds = runReport.FinishedAt.Sub(runReport.StartedAt).Seconds()
+	runReport.APICalls = apiCalls.Load()
+	runReport.ExitCode = code
+	switch code {
+	case ExitOK:
+		runReport.Status = "success"
+	case ExitPartial:
+		runReport.Status = "partial_failure"
+	default:
+		runReport.Status = "fatal"
+	}
+}
+
+/*
+writeReports writes the JSON/Markdown report and metrics textfile if they were asked for
+*/
+func writeReports(args ARGS) {
+
+	if args.MetricsTextfile != "" {
+		if err := writeMetricsTextfile(args.MetricsTextfile); err != nil {
+			logger("Error: Unable to write metrics textfile "+args.MetricsTextfile+": "+err.Error(), "err", true, false, config)
+		} else {
+			logger("Metrics written to "+args.MetricsTextfile, "info", true, true, config)
+		}
+	}
+
+	if args.ReportFile != "" {
+		runReport.mu.Lock()
+		data, err := json.MarshalIndent(runReport, "", "  ")
+		runReport.mu.Unlock()
+		if err == nil {
+			err = os.WriteFile(args.ReportFile, data, SecureFileMode)
+		}
+		if err != nil {
+			logger("Error: Unable to write run report "+args.ReportFile+": "+err.Error(), "err", true, false, config)
+		} else {
+			logger("Run report written to "+args.ReportFile, "info", true, true, config)
+		}
+	}
+
+	if args.ReportMarkdown != "" {
+		if err := os.WriteFile(args.ReportMarkdown, reportMarkdown().Bytes(), SecureFileMode); err != nil {
+			logger("Error: Unable to write run report "+args.ReportMarkdown+": "+err.Error(), "err", true, false, config)
+		} else {
+			logger("Run report written to "+args.ReportMarkdown, "info", true, true, config)
+		}
+	}
+}
+
+/*
+reportMarkdown renders the run report as Markdown
+*/
+func reportMarkdown() *bytes.Buffer {
+	runReport.mu.Lock()
+	defer runReport.mu.Unlock()
+
+	var buf bytes.Buffer
+
+	r := runReport
+	fmt.Fprintf(&buf, "# trellgo run report\n\n")
+	fmt.Fprintf(&buf, "- **Version:** %s\n", r.Version)
+	fmt.Fprintf(&buf, "- **Command:** %s\n", r.Command)
+	fmt.Fprintf(&buf, "- **Started:** %s\n", r.StartedAt.Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(&buf, "- **Duration:** %.1fs\n", r.DurationSeconds)
+	fmt.Fprintf(&buf, "- **Status:** %s (exit code %d)\n", r.Status, r.ExitCode)
+	fmt.Fprintf(&buf, "- **API calls:** %d\n\n", r.APICalls)
+
+	fmt.Fprintf(&buf, "| Board | Cards Found | Processed | Skipped | Attachments | Bytes | Attachments Skipped | API Calls | Duration | Deleted | Errors |\n")
+	fmt.Fprintf(&buf, "| --- | --- | --- | --- | --- | --- | --- | --- | --- | --- | --- |\n")
+	for _, b := range r.Boards {
+		fmt.Fprintf(&buf, "| %s (%s) | %d | %d | %d | %d | %d | %d | %d | %.1fs | %d | %d |\n",
+			b.Name, b.ID, b.CardsFound, b.CardsProcessed, b.CardsSkipped, b.Attachments, b.AttachmentBytes, len(b.Skipped), b.APICalls, b.DurationSeconds, len(b.Deleted), len(b.Errors))
+	}
+
+	for _, b := range r.Boards {
+		if len(b.Skipped) == 0 {
+			continue
+		}
+		fmt.Fprintf(&buf, "\n## Skipped Attachments: %s\n\n", b.Name)
+		for _, s := range b.Skipped {
+			fmt.Fprintf(&buf, "- **%s** (`%s`, %s %s) on card %s (`%s`): %s\n", s.Name, s.ID, formatBytes(s.Bytes), s.MimeType, s.Card, s.CardID, s.Reason)
+		}
+	}
+
+	writeDeleted := func(title string, items []DeletedItem) {
+		if len(items) == 0 {
+			return
+		}
+		fmt.Fprintf(&buf, "\n## %s\n\n", title)
+		for _, d := range items {
+			fmt.Fprintf(&buf, "- %s **%s** (`%s`) %s, %s at `%s`\n", d.Type, d.Name, d.ID, d.Reason, d.Action, d.Path)
+		}
+	}
+	for _, b := range r.Boards {
+		writeDeleted("Deleted: "+b.Name, b.Deleted)
+	}
+	writeDeleted("Deleted Boards", r.DeletedBoards)
+
+	writeErrors := func(title string, errs []ReportError) {
+		if len(errs) == 0 {
+			return
+		}
+		fmt.Fprintf(&buf, "\n## %s\n\n", title)
+		for _, e := range errs {
+			if e.CardID != "" {
+				fmt.Fprintf(&buf, "- `%s` card `%s`: %s\n", e.Op, e.CardID, e.Message)
+			} else {
+				fmt.Fprintf(&buf, "- %s\n", e.Message)
+			}
+		}
+	}
+	for _, b := range r.Boards {
+		writeErrors("Errors: "+b.Name, b.Errors)
+	}
+	writeErrors("Other Errors", r.Errors)
+
+	return &buf
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/adlio/trello"
+)
+
+// A card read back from a dump directory
+type restoreCard struct {
+	name        string
+	desc        string
+	archived    bool
+	isLink      bool
+	due         *time.Time
+	dueComplete bool
+	start       *time.Time
+	labels      []restoreLabel
+	checklists  []restoreChecklist
+	urls        []string
+	files       []string
+}
+
+type restoreLabel struct {
+	name  string
+	color string
+}
+
+type restoreChecklist struct {
+	name  string
+	items []restoreCheckItem
+}
+
+type restoreCheckItem struct {
+	name     string
+	complete bool
+}
+
+// A list read back from a dump directory, in the order found on disk
+type restoreList struct {
+	name  string
+	cards []restoreCard
+}
+
+var (
+	// **name** - color (id)   as written by processCardLabels
+	restoreLabelLine = regexp.MustCompile(`^\*\*(.*)\*\* - (\S*) \(`)
+	// - [x] item   as written by processCardChecklists
+	restoreCheckLine = regexp.MustCompile(`^- \[( |x)\] (.*)$`)
+	// Name [shortLink]   card directory names, see cardDirName
+	cardIDSuffixRe = regexp.MustCompile(` \[[A-Za-z0-9]+\]$`)
+)
+
+/*
+runRestore
+
+	Create a new Trello board from a dumped board directory (restore command)
+*/
+func runRestore(from string, name string, dryRun bool) error {
+
+	if name == "" {
+		name = filepath.Base(filepath.Clean(from))
+	}
+
+	lists, err := readDumpedBoard(from)
+	if err != nil {
+		return err
+	}
+
+	var cardCount int
+	for _, l := range lists {
+		cardCount += len(l.cards)
+	}
+	logger(fmt.Sprintf("Found %d lists and %d cards to restore from %s", len(lists), cardCount, from), "info", true, false, config)
+
+	if dryRun {
+		for _, l := range lists {
+			fmt.Printf("List: %s\n", l.name)
+			for _, c := range l.cards {
+				state := ""
+				if c.archived {
+					state = " (ARCHIVED)"
+				}
+				if c.isLink {
+					fmt.Printf("  Link Card: %s%s\n", c.name, state)
+					continue
+				}
+				fmt.Printf("  Card: %s%s - %d checklists, %d labels, %d files, %d links\n", c.name, state, len(c.checklists), len(c.labels), len(c.files), len(c.urls))
+			}
+		}
+		return nil
+	}
+
+	board := trello.NewBoard(name)
+	if err := client.CreateBoard(&board, trello.Arguments{"defaultLists": "false", "defaultLabels": "false"}); err != nil {
+		return fmt.Errorf("unable to create board %s: %w", name, err)
+	}
+	logger("Created board "+board.Name+" ("+board.ShortURL+")", "info", true, false, config)
+
+	labelIDs := make(map[restoreLabel]string)
+
+	for _, l := range lists {
+		list, err := board.CreateList(l.name, trello.Arguments{"pos": "bottom"})
+		if err != nil {
+			logger("Error: Unable to create list "+l.name+": "+err.Error(), "err", true, false, config)
+			errorWarnOnCompletion = true
+			continue
+		}
+		logger("Created list "+l.name, "info", true, true, config)
+
+		for _, c := range l.cards {
+			if err := restoreOneCard(&board, list, c, labelIDs); err != nil {
+				logger("Error: Unable to restore card "+c.name+": "+err.Error(), "err", true, false, config)
+				errorWarnOnCompletion = true
+			}
+		}
+	}
+
+	if errorWarnOnCompletion {
+		logger("Restore finished with errors, board is at "+board.ShortURL, "warn", true, false, config)
+		return nil
+	}
+	logger("Restore complete: "+board.ShortURL, "info", true, false, config)
+
+	return nil
+}
+
+/*
+restoreOneCard creates a single card with its labels, checklists and attachments
+*/
+func restoreOneCard(board *trello.Board, list *trello.List, c restoreCard, labelIDs map[restoreLabel]string) error {
+
+	card := &trello.Card{Name: c.name, Desc: c.desc, Due: c.due, Start: c.start}
+
+	for _, rl := range c.labels {
+		id, ok := labelIDs[rl]
+		if !ok {
+			label := &trello.Label{Name: rl.name, Color: rl.color}
+			if err := board.CreateLabel(label); err != nil {
+				logger("Error: Unable to create label "+rl.name+": "+err.Error(), "err", true, false, config)
+				continue
+			}
+			id = label.ID
+			labelIDs[rl] = id
+		}
+		card.IDLabels = append(card.IDLabels, id)
+	}
+
+	args := trello.Arguments{"pos": "bottom"}
+	if c.dueComplete {
+		args["dueComplete"] = "true"
+	}
+	if err := list.AddCard(card, args); err != nil {
+		return err
+	}
+	logger("Created card "+c.name, "info", true, true, config)
+
+	for _, rc := range c.checklists {
+		checklist, err := client.CreateChecklist(card, rc.name)
+		if err != nil {
+			logger("Error: Unable to create checklist "+rc.name+" on "+c.name+": "+err.Error(), "err", true, false, config)
+			continue
+		}
+		for _, item := range rc.items {
+			itemArgs := trello.Arguments{"checked": fmt.Sprintf("%t", item.complete)}
+			if _, err := checklist.CreateCheckItem(item.name, itemArgs); err != nil {
+				logger("Error: Unable to create checklist item on "+c.name+": "+err.Error(), "err", true, false, config)
+			}
+		}
+	}
+
+	for _, u := range c.urls {
+		if err := card.AddURLAttachment(&trello.Attachment{URL: u}); err != nil {
+			logger("Error: Unable to attach link "+sanitizeURLForLogging(u)+" to "+c.name+": "+err.Error(), "err", true, false, config)
+		}
+	}
+
+	for _, f := range c.files {
+		if err := restoreFileAttachment(card, f); err != nil {
+			logger("Error: Unable to upload "+f+" to "+c.name+": "+err.Error(), "err", true, false, config)
+		}
+	}
+
+	if c.archived {
+		return card.Archive()
+	}
+
+	return nil
+}
+
+/*
+restoreFileAttachment uploads one file from the dump back onto a card
+*/
+func restoreFileAttachment(card *trello.Card, fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	name := strings.TrimSuffix(filepath.Base(fileName), " (Card Cover)")
+	return card.AddFileAttachment(&trello.Attachment{Name: name}, name, f)
+}
+
+/*
+readDumpedBoard
+
+	Walk a dumped board directory and read back its lists and cards.
+	Archived cards are picked up from both the "(ARCHIVED)" suffix and the -split ARCHIVED directory.
+	A --ordered dump has its position prefixes taken off, its directories already sort in board order.
+*/
+func readDumpedBoard(boardDir string) ([]restoreList, error) {
+
+	var (
+		lists   []restoreList
+		byName  = make(map[string]int)
+		ordered = isOrderedDump(boardDir)
+		idNames = hasBoardState(boardDir)
+	)
+
+	addCards := func(listDir string, listName string, forceArchived bool) error {
+		cards, err := readDumpedList(listDir, forceArchived, ordered, idNames)
+		if err != nil {
+			return err
+		}
+		if ordered {
+			listName = stripPosPrefix(listName)
+		}
+		i, ok := byName[listName]
+		if !ok {
+			lists = append(lists, restoreList{name: listName})
+			i = len(lists) - 1
+			byName[listName] = i
+		}
+		lists[i].cards = append(lists[i].cards, cards...)
+		return nil
+	}
+
+	entries, err := os.ReadDir(boardDir)
+	if err != nil {
+		return nil, fmt.Errorf("reading board directory: %w", err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() || e.Name() == "ARCHIVED" || e.Name() == DeletedDir || e.Name() == MembersDir {
+			continue
+		}
+		if err := addCards(filepath.Join(boardDir, e.Name()), e.Name(), false); err != nil {
+			return nil, err
+		}
+	}
+
+	// -split puts archived cards under ARCHIVED/<list>/<card>
+	archived, err := os.ReadDir(filepath.Join(boardDir, "ARCHIVED"))
+	if err == nil {
+		for _, e := range archived {
+			if !e.IsDir() {
+				continue
+			}
+			if err := addCards(filepath.Join(boardDir, "ARCHIVED", e.Name()), e.Name(), true); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(lists) == 0 {
+		return nil, fmt.Errorf("no lists found in %s, is this a board directory from a dump?", boardDir)
+	}
+
+	return lists, nil
+}
+
+/*
+hasBoardState reports if a board directory has a state file, its card directories end in the card's short ID
+*/
+func hasBoardState(boardDir string) bool {
+	_, err := os.Stat(filepath.Join(boardDir, BoardStateFile))
+	return err == nil
+}
+
+/*
+isOrderedDump reports if a board directory was dumped with --ordered, any list directory holding a CardOrder.md
+*/
+func isOrderedDump(boardDir string) bool {
+	entries, _ := os.ReadDir(boardDir)
+	for _, e := range entries {
+		if _, err := os.Stat(filepath.Join(boardDir, e.Name(), CardOrderFile)); e.IsDir() && err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+readDumpedList reads every card directory (and link card file) in a list directory
+*/
+func readDumpedList(listDir string, forceArchived bool, ordered bool, idNames bool) ([]restoreCard, error) {
+
+	var cards []restoreCard
+
+	entries, err := os.ReadDir(listDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		dir := filepath.Join(listDir, e.Name())
+
+		// Link cards are single markdown files holding the URL
+		if e.Name() == "Link Cards Only" {
+			links, _ := os.ReadDir(dir)
+			for _, l := range links {
+				if strings.HasSuffix(l.Name(), TombstoneFile) {
+					continue
+				}
+				if _, err := os.Stat(filepath.Join(dir, l.Name()+"."+TombstoneFile)); err == nil {
+					continue
+				}
+				data, err := os.ReadFile(filepath.Join(dir, l.Name()))
+				if err != nil || len(data) == 0 {
+					continue
+				}
+				cards = append(cards, restoreCard{name: strings.TrimSpace(string(data)), isLink: true, archived: forceArchived})
+			}
+			continue
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, "CardDescription.md")); err != nil {
+			continue
+		}
+		// Deleted in Trello, left in place by --on-deleted tombstone
+		if _, err := os.Stat(filepath.Join(dir, TombstoneFile)); err == nil {
+			continue
+		}
+
+		dirName := e.Name()
+		if ordered {
+			dirName = stripPosPrefix(dirName)
+		}
+		card := readDumpedCard(dir, dirName)
+		if idNames {
+			card.name = cardIDSuffixRe.ReplaceAllString(card.name, "")
+		}
+		card.archived = card.archived || forceArchived
+		cards = append(cards, card)
+	}
+
+	return cards, nil
+}
+
+/*
+readDumpedCard reads back the markdown files written by processRegularCard
+*/
+func readDumpedCard(dir string, dirName string) restoreCard {
+
+	card := restoreCard{name: dirName}
+	if strings.HasSuffix(dirName, " (ARCHIVED)") {
+		card.name = strings.TrimSuffix(dirName, " (ARCHIVED)")
+		card.archived = true
+	}
+
+	if data, err := os.ReadFile(filepath.Join(dir, "CardDescription.md")); err == nil {
+		card.desc = string(data)
+	}
+
+	for _, line := range readLines(filepath.Join(dir, "CardLabels.md")) {
+		if m := restoreLabelLine.FindStringSubmatch(line); m != nil {
+			card.labels = append(card.labels, restoreLabel{name: m[1], color: m[2]})
+		}
+	}
+
+	if data, err := os.ReadFile(filepath.Join(dir, "CardDueDate (Completed).md")); err == nil && len(data) > 0 {
+		card.due = parseDumpDate(string(data))
+		card.dueComplete = true
+	} else if data, err := os.ReadFile(filepath.Join(dir, "CardDueDate.md")); err == nil && len(data) > 0 {
+		card.due = parseDumpDate(string(data))
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "CardStartDate.md")); err == nil && len(data) > 0 {
+		card.start = parseDumpDate(string(data))
+	}
+
+	checklists, _ := filepath.Glob(filepath.Join(dir, "checklists", "*.md"))
+	for _, cl := range checklists {
+		rc := restoreChecklist{name: strings.TrimSuffix(filepath.Base(cl), ".md")}
+		for _, line := range readLines(cl) {
+			if m := restoreCheckLine.FindStringSubmatch(line); m != nil {
+				rc.items = append(rc.items, restoreCheckItem{name: m[2], complete: m[1] == "x"})
+			}
+		}
+		card.checklists = append(card.checklists, rc)
+	}
+
+	attachments, _ := os.ReadDir(filepath.Join(dir, "attachments"))
+	for _, a := range attachments {
+		// previews/, the metadata trellgo wrote and unfinished downloads are not attachments
+		if a.IsDir() || a.Name() == AttachmentsMDFile || a.Name() == AttachmentsJSONFile || strings.HasSuffix(a.Name(), PartSuffix) {
+			continue
+		}
+		if a.Name() == "URL-Attachments.md" {
+			for _, line := range readLines(filepath.Join(dir, "attachments", a.Name())) {
+				if line != "" {
+					card.urls = append(card.urls, line)
+				}
+			}
+			continue
+		}
+		card.files = append(card.files, filepath.Join(dir, "attachments", a.Name()))
+	}
+
+	return card
+}
+
+/*
+readLines returns the lines of a text file, or nothing if it can't be read
+*/
+func readLines(fileName string) []string {
+	var lines []string
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		return lines
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimSpace(scanner.Text()))
+	}
+
+	return lines
+}
+
+/*
+parseDumpDate parses the date format used in the dump markdown files
+*/
+func parseDumpDate(s string) *time.Time {
+	t, err := time.Parse("2006-01-02 15:04:05", strings.TrimSpace(s))
+	if err != nil {
+		return nil
+	}
+	return &t
+}
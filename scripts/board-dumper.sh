@@ -31,7 +31,15 @@ logger -p ${FAC}.info -t trellgo "Trellgo process complete"
 # Dump Dynamic Boards
 VERSION=`$TRELLGOHOME/trellgo -v`
 logger -p ${FAC}.info -t trellgo "Starting trellgo v$VERSION. Doing trello board dump from $BOARDLIST"
-cat $TRELLGOHOME/$BOARDLIST | $TRELLGOHOME/trellgo -a -qq -logs "$TRELLGOHOME/logs/$TODAY-live-dump.log" -s "/opt/trellgo/weekly"
+# Exit code 2 is a partial failure (some cards/attachments failed), still worth archiving
+RC=0
+cat $TRELLGOHOME/$BOARDLIST | $TRELLGOHOME/trellgo -a -qq -logs "$TRELLGOHOME/logs/$TODAY-live-dump.log" -s "/opt/trellgo/weekly" || RC=$?
+if [ $RC -eq 1 ]; then
+  logger -p ${FAC}.err -t trellgo "trellgo dump failed (exit $RC), see $TRELLGOHOME/logs/$TODAY-live-dump.log"
+  exit 1
+elif [ $RC -eq 2 ]; then
+  logger -p ${FAC}.warning -t trellgo "trellgo dump finished with errors (exit $RC), see $TRELLGOHOME/logs/$TODAY-live-dump.log"
+fi
 
 # Tar and compress
 logger -p ${FAC}.info -t trellgo "Starting trello board dump tar and compression: $TRELLGOHOME/$FILE"
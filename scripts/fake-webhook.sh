@@ -0,0 +1,25 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+# fake-webhook.sh
+# Post a signed Trello style webhook callback at a local `trellgo webhook` for testing, no Trello needed.
+# Start trellgo with --no-register and the same --callback-url, ie:
+#   trellgo webhook -b <boardID> -s /tmp/mirror --listen :8080 --callback-url https://backup.example.com/trello --no-register
+#   ./fake-webhook.sh <boardID> <cardID>
+
+BOARD="${1:?usage: fake-webhook.sh <full boardID> <cardID> [actionType]}"
+CARD="${2:?usage: fake-webhook.sh <full boardID> <cardID> [actionType]}"
+ACTION="${3:-updateCard}"
+
+# Must match --callback-url exactly, it is part of the signature
+CALLBACK="${CALLBACK:-https://backup.example.com/trello}"
+# Where trellgo is actually listening
+TARGET="${TARGET:-http://127.0.0.1:8080/trello}"
+SECRET="${TRELLGO_APISECRET:?set TRELLGO_APISECRET to the same value trellgo uses}"
+
+BODY=$(printf '{"model":{"id":"%s"},"action":{"id":"fake","type":"%s","data":{"board":{"id":"%s"},"card":{"id":"%s"}}}}' "$BOARD" "$ACTION" "$BOARD" "$CARD")
+
+# base64(HMAC-SHA1(secret, body + callback URL)), same as Trello
+SIG=$(printf '%s%s' "$BODY" "$CALLBACK" | openssl dgst -sha1 -hmac "$SECRET" -binary | base64)
+
+curl -sS -o /dev/null -w "%{http_code}\n" -X POST -H "Content-Type: application/json" -H "X-Trello-Webhook: $SIG" --data "$BODY" "$TARGET"
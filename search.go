@@ -0,0 +1,764 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/spf13/cobra"
+)
+
+// Kept in each storage path, the search index over every dumped card under it
+const SearchIndexFile = ".trellgo-search.json"
+
+// Bumped when what is indexed changes, an older index is rebuilt
+const SearchIndexVersion = 1
+
+// Characters of context either side of a match in a result snippet
+const SnippetContext = 60
+
+// Card files the search index reads, anything else in a card directory is ignored
+var searchCardFiles = []string{"CardDescription.md", "CardComments.md", "CardLabels.md", "CardUsers.md", "CardHistory.md"}
+
+var (
+	// **FullName** (2006-01-02 15:04:05): text   as written by processCardComments/processCardHistory
+	searchActionLine = regexp.MustCompile(`^\*\*[^*]*\*\* \((\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2})\): `)
+	// [avatar] @username **FullName** (ID)   as written by processCardUsers
+	searchUserLine = regexp.MustCompile(`(?:@(\S+) )?\*\*(.*)\*\* \(`)
+)
+
+/*
+SearchIndex
+
+	Text of every card under a storage path, keyed by card path relative to it.
+	Each card keeps a signature of its files so only changed cards are read again.
+*/
+type SearchIndex struct {
+	Version   int                   `json:"version"`
+	UpdatedAt time.Time             `json:"updated_at"`
+	Cards     map[string]*SearchDoc `json:"cards"`
+}
+
+/*
+SearchDoc is one card in the search index
+*/
+type SearchDoc struct {
+	Path     string            `json:"path"` // relative to the storage path, slash separated
+	Board    string            `json:"board"`
+	List     string            `json:"list"`
+	Name     string            `json:"name"`
+	Archived bool              `json:"archived,omitempty"`
+	Link     bool              `json:"link,omitempty"`
+	Labels   []string          `json:"labels,omitempty"`
+	Members  []string          `json:"members,omitempty"` // usernames and full names
+	Activity time.Time         `json:"activity"`          // latest comment or history entry, else when it was dumped
+	Fields   map[string]string `json:"fields"`            // description, comments, checklists
+	Sig      string            `json:"sig"`
+}
+
+/*
+SearchFilters narrows results down beyond the query, empty values match everything
+*/
+type SearchFilters struct {
+	Board  string
+	List   string
+	Label  string
+	Member string
+	Since  time.Time
+	Until  time.Time
+}
+
+/*
+SearchResult is a matching card with a snippet of where it matched
+*/
+type SearchResult struct {
+	Doc     *SearchDoc
+	Root    string
+	Score   int
+	Field   string
+	Snippet string
+}
+
+func newSearchCmd() *cobra.Command {
+	var (
+		storage []string
+		filters SearchFilters
+		since   string
+		until   string
+		limit   int
+		reindex bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "search [query]",
+		Short: "Search the cards in a dump on disk",
+		Long: "Search card names, descriptions, comments, checklist items and labels in a dump, without the Trello API.\n" +
+			"Every word has to match, \"quoted words\" have to appear together as a phrase.\n" +
+			"An index is kept in each storage path (" + SearchIndexFile + ") and only changed cards are read again.",
+		Example: "  trellgo search -s '/path/to/here' 'invoice 4411'\n" +
+			"  trellgo search -s '/path/to/here' '\"sign off\" budget' --board Finance --label Urgent\n" +
+			"  trellgo search -s '/path/to/here' --member @alice --since 2024-01-01",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, err := baseArgs(cmd)
+			if err != nil {
+				return err
+			}
+			// Offline, so no startRun and no API keys needed
+			config.ARGS = a
+
+			roots := storage
+			if len(roots) == 0 {
+				roots = configStoragePaths(a.fileConfig)
+			}
+			if len(roots) == 0 {
+				return fmt.Errorf("no storage path to search, use -s or set storage in --config")
+			}
+
+			if since != "" {
+				if filters.Since, err = time.ParseInLocation("2006-01-02", since, time.Local); err != nil {
+					return fmt.Errorf("--since must be YYYY-MM-DD: %w", err)
+				}
+			}
+			if until != "" {
+				if filters.Until, err = time.ParseInLocation("2006-01-02", until, time.Local); err != nil {
+					return fmt.Errorf("--until must be YYYY-MM-DD: %w", err)
+				}
+			}
+
+			query := strings.Join(args, " ")
+			if strings.TrimSpace(query) == "" && filters == (SearchFilters{}) {
+				return fmt.Errorf("give a query, a filter, or both")
+			}
+			return runSearch(roots, query, filters, limit, reindex)
+		},
+	}
+	cmd.Flags().StringSliceVarP(&storage, "storage", "s", nil, "Storage path (or board directory) of a dump to search, repeatable.  Defaults to the storage paths in --config")
+	cmd.Flags().StringVar(&filters.Board, "board", "", "Only cards on boards whose name contains this")
+	cmd.Flags().StringVar(&filters.List, "list", "", "Only cards in lists whose name contains this")
+	cmd.Flags().StringVar(&filters.Label, "label", "", "Only cards with this label name")
+	cmd.Flags().StringVar(&filters.Member, "member", "", "Only cards with this member, by @username or full name")
+	cmd.Flags().StringVar(&since, "since", "", "Only cards with a comment or history entry on or after this date (YYYY-MM-DD)")
+	cmd.Flags().StringVar(&until, "until", "", "Only cards last active on or before this date (YYYY-MM-DD)")
+	cmd.Flags().IntVar(&limit, "limit", 20, "Most results to show (0 shows all)")
+	cmd.Flags().BoolVar(&reindex, "reindex", false, "Throw the index away and read every card again")
+	return cmd
+}
+
+/*
+configStoragePaths is every storage path named in a config file, defaults first
+*/
+func configStoragePaths(fc *FileConfig) []string {
+	if fc == nil {
+		return nil
+	}
+	var roots []string
+	for _, p := range append([]BoardProfile{fc.Defaults}, fc.Boards...) {
+		if p.StoragePath != nil && *p.StoragePath != "" && !slices.Contains(roots, *p.StoragePath) {
+			roots = append(roots, *p.StoragePath)
+		}
+	}
+	return roots
+}
+
+/*
+runSearch
+
+	Bring the index in each storage path up to date, then print the cards matching the query (search command)
+*/
+func runSearch(roots []string, query string, filters SearchFilters, limit int, reindex bool) error {
+
+	terms := parseSearchQuery(query)
+	var results []SearchResult
+
+	for _, root := range roots {
+		idx, err := updateSearchIndex(root, reindex)
+		if err != nil {
+			return err
+		}
+		results = append(results, searchIndex(idx, root, terms, filters)...)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Doc.Path < results[j].Doc.Path
+	})
+
+	if len(results) == 0 {
+		fmt.Println("No matching cards")
+		return nil
+	}
+	shown := results
+	if limit > 0 && len(shown) > limit {
+		shown = shown[:limit]
+	}
+	for _, r := range shown {
+		name := r.Doc.Name
+		if r.Doc.Archived {
+			name += " (ARCHIVED)"
+		}
+		fmt.Printf("%s / %s / %s\n", r.Doc.Board, r.Doc.List, name)
+		fmt.Printf("  %s\n", filepath.Join(r.Root, filepath.FromSlash(r.Doc.Path)))
+		if r.Snippet != "" {
+			fmt.Printf("  %s: %s\n", r.Field, r.Snippet)
+		}
+		fmt.Println()
+	}
+	if len(shown) < len(results) {
+		fmt.Printf("%d of %d matching card(s) shown, use --limit to see more\n", len(shown), len(results))
+	} else {
+		fmt.Printf("%d matching card(s)\n", len(results))
+	}
+
+	return nil
+}
+
+/*
+updateSearchIndex
+
+	Load a storage path's index and bring it up to date with what is on disk.  Cards whose
+	files haven't changed are kept as they are, new and changed ones are read, and ones no
+	longer there are dropped.  Saved only if something changed.
+*/
+func updateSearchIndex(root string, reindex bool) (*SearchIndex, error) {
+
+	if _, err := os.Stat(root); err != nil {
+		return nil, fmt.Errorf("storage path %s: %w", root, err)
+	}
+
+	idx := &SearchIndex{}
+	if !reindex {
+		if data, err := os.ReadFile(filepath.Join(root, SearchIndexFile)); err == nil {
+			if err := json.Unmarshal(data, idx); err != nil || idx.Version != SearchIndexVersion {
+				idx = &SearchIndex{}
+			}
+		}
+	}
+	if idx.Cards == nil {
+		idx.Cards = make(map[string]*SearchDoc)
+	}
+	idx.Version = SearchIndexVersion
+
+	seen := make(map[string]bool)
+	var added, updated, removed int
+
+	for _, board := range searchBoardDirs(root) {
+		walkDumpedCards(root, board, func(doc *SearchDoc, dir string, isLink bool) {
+			seen[doc.Path] = true
+			old, ok := idx.Cards[doc.Path]
+			if ok && old.Sig == doc.Sig {
+				return
+			}
+			if isLink {
+				readSearchLink(doc, dir)
+			} else {
+				readSearchCard(doc, dir)
+			}
+			idx.Cards[doc.Path] = doc
+			if ok {
+				updated++
+			} else {
+				added++
+			}
+		})
+	}
+
+	for path := range idx.Cards {
+		if !seen[path] {
+			delete(idx.Cards, path)
+			removed++
+		}
+	}
+
+	if added+updated+removed > 0 || reindex {
+		idx.UpdatedAt = time.Now().UTC()
+		data, err := json.Marshal(idx)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filepath.Join(root, SearchIndexFile), data, SecureFileMode); err != nil {
+			logger("Warning: Unable to save search index in "+root+": "+err.Error(), "warn", true, false, config, LogFieldOp, "search")
+		}
+	}
+	logger(fmt.Sprintf("Search index for %s: %d cards, %d added, %d updated, %d removed", root, len(idx.Cards), added, updated, removed), "info", true, true, config, LogFieldOp, "search")
+
+	return idx, nil
+}
+
+/*
+searchBoardDirs lists the board directories under a storage path, the path itself if it is a board directory
+*/
+func searchBoardDirs(root string) []string {
+	if hasBoardState(root) {
+		return []string{"."}
+	}
+
+	var boards []string
+	entries, _ := os.ReadDir(root)
+	for _, e := range entries {
+		if e.IsDir() && e.Name() != DeletedDir && !strings.HasPrefix(e.Name(), ".") {
+			boards = append(boards, e.Name())
+		}
+	}
+	return boards
+}
+
+/*
+walkDumpedCards
+
+	Call fn for every card directory and link card file in a board directory, with the board,
+	list and card names filled in and a signature of its files.  Cards gone from Trello (tombstoned
+	or under DELETED/) are left out.
+*/
+func walkDumpedCards(root string, board string, fn func(doc *SearchDoc, dir string, isLink bool)) {
+
+	boardDir := filepath.Join(root, board)
+	boardName := filepath.Base(filepath.Clean(boardDir))
+	ordered := isOrderedDump(boardDir)
+	idNames := hasBoardState(boardDir)
+
+	cleanName := func(name string) string {
+		if ordered {
+			name = stripPosPrefix(name)
+		}
+		if idNames {
+			name = cardIDSuffixRe.ReplaceAllString(name, "")
+		}
+		return name
+	}
+
+	listDirs := func(dir string) []string {
+		var dirs []string
+		entries, _ := os.ReadDir(dir)
+		for _, e := range entries {
+			if e.IsDir() && e.Name() != "ARCHIVED" && e.Name() != DeletedDir && e.Name() != MembersDir && !strings.HasPrefix(e.Name(), ".") {
+				dirs = append(dirs, e.Name())
+			}
+		}
+		return dirs
+	}
+
+	walkList := func(listDir string, listName string, archived bool) {
+		entries, _ := os.ReadDir(listDir)
+		for _, e := range entries {
+			if !e.IsDir() {
+				continue
+			}
+			dir := filepath.Join(listDir, e.Name())
+
+			if e.Name() == "Link Cards Only" {
+				links, _ := os.ReadDir(dir)
+				for _, l := range links {
+					file := filepath.Join(dir, l.Name())
+					if l.IsDir() || strings.HasSuffix(l.Name(), TombstoneFile) {
+						continue
+					}
+					if _, err := os.Stat(file + "." + TombstoneFile); err == nil {
+						continue
+					}
+					info, err := l.Info()
+					if err != nil {
+						continue
+					}
+					name := strings.TrimSuffix(strings.TrimPrefix(l.Name(), "CARD - "), ".md")
+					fn(&SearchDoc{
+						Path:     searchRelPath(root, file),
+						Board:    boardName,
+						List:     listName,
+						Name:     cleanName(name),
+						Archived: archived,
+						Link:     true,
+						Sig:      strconv.FormatInt(info.ModTime().UnixNano(), 36) + "-" + strconv.FormatInt(info.Size(), 36),
+					}, file, true)
+				}
+				continue
+			}
+
+			if _, err := os.Stat(filepath.Join(dir, "CardDescription.md")); err != nil {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(dir, TombstoneFile)); err == nil {
+				continue
+			}
+
+			name := e.Name()
+			cardArchived := archived
+			if strings.HasSuffix(name, " (ARCHIVED)") {
+				name = strings.TrimSuffix(name, " (ARCHIVED)")
+				cardArchived = true
+			}
+			fn(&SearchDoc{
+				Path:     searchRelPath(root, dir),
+				Board:    boardName,
+				List:     listName,
+				Name:     cleanName(name),
+				Archived: cardArchived,
+				Sig:      searchCardSig(dir),
+			}, dir, false)
+		}
+	}
+
+	for _, l := range listDirs(boardDir) {
+		walkList(filepath.Join(boardDir, l), cleanName(l), false)
+	}
+	// -split puts archived cards under ARCHIVED/<list>/<card>
+	for _, l := range listDirs(filepath.Join(boardDir, "ARCHIVED")) {
+		walkList(filepath.Join(boardDir, "ARCHIVED", l), cleanName(l), true)
+	}
+}
+
+func searchRelPath(root string, path string) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+/*
+searchCardSig is the size and modified time of every file the index reads from a card, a change to any of them changes it
+*/
+func searchCardSig(dir string) string {
+	files := slices.Clone(searchCardFiles)
+	checklists, _ := filepath.Glob(filepath.Join(dir, "checklists", "*.md"))
+	for _, cl := range checklists {
+		files = append(files, filepath.Join("checklists", filepath.Base(cl)))
+	}
+
+	var sig strings.Builder
+	for _, f := range files {
+		info, err := os.Stat(filepath.Join(dir, f))
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&sig, "%s:%x:%x;", f, info.ModTime().UnixNano(), info.Size())
+	}
+	return sig.String()
+}
+
+/*
+readSearchCard reads the text to index back out of the markdown files written by processRegularCard
+*/
+func readSearchCard(doc *SearchDoc, dir string) {
+
+	doc.Fields = make(map[string]string)
+
+	if data, err := os.ReadFile(filepath.Join(dir, "CardDescription.md")); err == nil {
+		doc.Fields["description"] = string(data)
+	}
+
+	for _, line := range readLines(filepath.Join(dir, "CardLabels.md")) {
+		if m := restoreLabelLine.FindStringSubmatch(line); m != nil && m[1] != "" {
+			doc.Labels = append(doc.Labels, m[1])
+		}
+	}
+
+	for _, line := range readLines(filepath.Join(dir, "CardUsers.md")) {
+		if m := searchUserLine.FindStringSubmatch(line); m != nil {
+			if m[1] != "" {
+				doc.Members = append(doc.Members, m[1])
+			}
+			if m[2] != "" {
+				doc.Members = append(doc.Members, m[2])
+			}
+		}
+	}
+
+	if data, err := os.ReadFile(filepath.Join(dir, "CardComments.md")); err == nil {
+		doc.Fields["comments"] = string(data)
+	}
+
+	var items []string
+	checklists, _ := filepath.Glob(filepath.Join(dir, "checklists", "*.md"))
+	for _, cl := range checklists {
+		items = append(items, strings.TrimSuffix(filepath.Base(cl), ".md"))
+		for _, line := range readLines(cl) {
+			if m := restoreCheckLine.FindStringSubmatch(line); m != nil {
+				items = append(items, m[2])
+			}
+		}
+	}
+	doc.Fields["checklists"] = strings.Join(items, "\n")
+
+	// Latest dated comment or history entry
+	for _, f := range []string{"CardComments.md", "CardHistory.md"} {
+		for _, line := range readLines(filepath.Join(dir, f)) {
+			m := searchActionLine.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			if t, err := time.Parse("2006-01-02 15:04:05", m[1]); err == nil && t.After(doc.Activity) {
+				doc.Activity = t
+			}
+		}
+	}
+	if doc.Activity.IsZero() {
+		if info, err := os.Stat(filepath.Join(dir, "CardDescription.md")); err == nil {
+			doc.Activity = info.ModTime().UTC()
+		}
+	}
+}
+
+/*
+readSearchLink indexes a link card, all there is is its URL
+*/
+func readSearchLink(doc *SearchDoc, file string) {
+	doc.Fields = make(map[string]string)
+	if data, err := os.ReadFile(file); err == nil {
+		doc.Fields["description"] = strings.TrimSpace(string(data))
+	}
+	if info, err := os.Stat(file); err == nil {
+		doc.Activity = info.ModTime().UTC()
+	}
+}
+
+/*
+parseSearchQuery
+
+	Split a query into terms, each a list of words.  "Quoted text" is a phrase, its words
+	have to appear together in that order.  Every term has to match somewhere in a card.
+*/
+func parseSearchQuery(query string) [][]string {
+	var terms [][]string
+
+	for i, part := range strings.Split(query, `"`) {
+		if i%2 == 1 {
+			// Inside quotes
+			if words := searchTokens(part); len(words) > 0 {
+				terms = append(terms, words)
+			}
+			continue
+		}
+		for _, w := range searchTokens(part) {
+			terms = append(terms, []string{w})
+		}
+	}
+
+	return terms
+}
+
+/*
+searchTokens lower cases text and splits it into words, anything not a letter or digit separates them
+*/
+func searchTokens(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+/*
+searchIndex
+
+	Match every card in an index against the terms and filters.  Each card's words are put in
+	an inverted index first, so only cards holding every word get their fields checked for phrases.
+*/
+func searchIndex(idx *SearchIndex, root string, terms [][]string, filters SearchFilters) []SearchResult {
+
+	docs := make([]*SearchDoc, 0, len(idx.Cards))
+	for _, doc := range idx.Cards {
+		if searchFilterMatch(doc, filters) {
+			docs = append(docs, doc)
+		}
+	}
+
+	// Word -> docs containing it
+	candidates := make([]int, len(docs))
+	for i := range docs {
+		candidates[i] = i
+	}
+	if len(terms) > 0 {
+		postings := make(map[string][]int)
+		for i, doc := range docs {
+			words := make(map[string]bool)
+			for _, text := range searchDocTexts(doc) {
+				for _, w := range searchTokens(text.text) {
+					words[w] = true
+				}
+			}
+			for w := range words {
+				postings[w] = append(postings[w], i)
+			}
+		}
+		for _, term := range terms {
+			for _, w := range term {
+				candidates = intersectSorted(candidates, postings[w])
+			}
+		}
+	}
+
+	var results []SearchResult
+	for _, i := range candidates {
+		doc := docs[i]
+		r := SearchResult{Doc: doc, Root: root}
+		matched := true
+		for _, term := range terms {
+			score, field, snippet := searchTerm(doc, term)
+			if score == 0 {
+				matched = false
+				break
+			}
+			r.Score += score
+			if r.Snippet == "" {
+				r.Field, r.Snippet = field, snippet
+			}
+		}
+		if matched {
+			results = append(results, r)
+		}
+	}
+
+	return results
+}
+
+type searchText struct {
+	field  string
+	text   string
+	weight int
+}
+
+/*
+searchDocTexts is everything searchable in a card, best places for a match first
+*/
+func searchDocTexts(doc *SearchDoc) []searchText {
+	return []searchText{
+		{"name", doc.Name, 5},
+		{"labels", strings.Join(doc.Labels, "\n"), 3},
+		{"description", doc.Fields["description"], 2},
+		{"checklists", doc.Fields["checklists"], 1},
+		{"comments", doc.Fields["comments"], 1},
+	}
+}
+
+/*
+searchTerm scores how well a term (word or phrase) matches a card, with a snippet from the best place it matched.  0 is no match.
+*/
+func searchTerm(doc *SearchDoc, term []string) (int, string, string) {
+
+	var (
+		score   int
+		field   string
+		snippet string
+	)
+	for _, t := range searchDocTexts(doc) {
+		n := countPhrase(searchTokens(t.text), term)
+		if n == 0 {
+			continue
+		}
+		score += n * t.weight
+		if snippet == "" {
+			field, snippet = t.field, searchSnippet(t.text, term)
+		}
+	}
+
+	return score, field, snippet
+}
+
+/*
+countPhrase is how many times the words of a phrase appear together, in order, in a list of words
+*/
+func countPhrase(words []string, phrase []string) int {
+	n := 0
+	for i := 0; i+len(phrase) <= len(words); i++ {
+		if slices.Equal(words[i:i+len(phrase)], phrase) {
+			n++
+		}
+	}
+	return n
+}
+
+/*
+searchSnippet is the text around the first match of a phrase on one line, with the match in bold
+*/
+func searchSnippet(text string, phrase []string) string {
+
+	quoted := make([]string, len(phrase))
+	for i, w := range phrase {
+		quoted[i] = regexp.QuoteMeta(w)
+	}
+	re, err := regexp.Compile(`(?i)(?:^|[^\pL\pN])(` + strings.Join(quoted, `[^\pL\pN]+`) + `)(?:$|[^\pL\pN])`)
+	if err != nil {
+		return ""
+	}
+	loc := re.FindStringSubmatchIndex(text)
+	if loc == nil {
+		return ""
+	}
+	start, end := loc[2], loc[3]
+
+	before := []rune(text[:start])
+	after := []rune(text[end:])
+	prefix, suffix := "", ""
+	if len(before) > SnippetContext {
+		before, prefix = before[len(before)-SnippetContext:], "..."
+	}
+	if len(after) > SnippetContext {
+		after, suffix = after[:SnippetContext], "..."
+	}
+
+	snippet := prefix + string(before) + "**" + text[start:end] + "**" + string(after) + suffix
+	return strings.Join(strings.Fields(snippet), " ")
+}
+
+/*
+searchFilterMatch reports if a card passes the --board, --list, --label, --member, --since and --until filters
+*/
+func searchFilterMatch(doc *SearchDoc, f SearchFilters) bool {
+
+	contains := func(s, sub string) bool {
+		return strings.Contains(strings.ToLower(s), strings.ToLower(sub))
+	}
+
+	if f.Board != "" && !contains(doc.Board, f.Board) {
+		return false
+	}
+	if f.List != "" && !contains(doc.List, f.List) {
+		return false
+	}
+	if f.Label != "" && !slices.ContainsFunc(doc.Labels, func(l string) bool { return strings.EqualFold(l, f.Label) }) {
+		return false
+	}
+	if f.Member != "" {
+		member := strings.TrimPrefix(f.Member, "@")
+		if !slices.ContainsFunc(doc.Members, func(m string) bool { return strings.EqualFold(m, member) }) {
+			return false
+		}
+	}
+	if !f.Since.IsZero() && doc.Activity.Before(f.Since) {
+		return false
+	}
+	// --until is a whole day
+	if !f.Until.IsZero() && !doc.Activity.Before(f.Until.AddDate(0, 0, 1)) {
+		return false
+	}
+
+	return true
+}
+
+/*
+intersectSorted is the ids in both ascending lists
+*/
+func intersectSorted(a []int, b []int) []int {
+	var out []int
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, a[i])
+			i++
+			j++
+		case a[i] < b[j]:
+			i++
+		default:
+			j++
+		}
+	}
+	return out
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// How many times a file upload is retried (resuming from where it stopped) after the connection drops
+const SFTPUploadRetries = 3
+
+// Set once the run has warned that an SFTP server won't keep modified times
+var sftpMtimeWarned atomic.Bool
+
+/*
+sftpShipper
+
+	Uploads over SFTP.  Files are written to name.part and renamed once their size
+	(and sha256 when checksum is on) matches, an interrupted upload carries on from the
+	end of the .part file next time.  Uploads are given the local file's modified time, a
+	remote file with the same size and modified time (or sha256, with checksum on) is skipped.
+	With mirror on, whatever is no longer in the local tree is then removed from the server.
+*/
+type sftpShipper struct {
+	addr       string
+	user       string
+	keyFile    string
+	knownHosts string
+	remoteDir  string
+	checksum   bool
+	mirror     bool
+}
+
+func newSFTPShipper(sc ShipConfig) (shipper, error) {
+
+	if sc.Host == "" || sc.User == "" || sc.KeyFile == "" || sc.Path == "" {
+		return nil, fmt.Errorf("sftp shipper needs host, user, key_file and path")
+	}
+	port := sc.Port
+	if port == 0 {
+		port = 22
+	}
+	knownHosts := sc.KnownHosts
+	if knownHosts == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("sftp shipper needs known_hosts, no home directory to find ~/.ssh/known_hosts: %w", err)
+		}
+		knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+
+	return sftpShipper{
+		addr:       net.JoinHostPort(sc.Host, strconv.Itoa(port)),
+		user:       sc.User,
+		keyFile:    sc.KeyFile,
+		knownHosts: knownHosts,
+		remoteDir:  sc.Path,
+		checksum:   sc.Checksum,
+		mirror:     sc.Mirror,
+	}, nil
+}
+
+func (s sftpShipper) String() string {
+	return "sftp " + s.user + "@" + s.addr + ":" + s.remoteDir
+}
+
+/*
+connect opens the SSH connection and SFTP session, the host key must be in known_hosts
+*/
+func (s sftpShipper) connect() (*ssh.Client, *sftp.Client, error) {
+
+	key, err := os.ReadFile(s.keyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading key_file: %w", err)
+	}
+	var signer ssh.Signer
+	if config.ENV.SSHPASSPHRASE != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(config.ENV.SSHPASSPHRASE))
+	} else {
+		signer, err = ssh.ParsePrivateKey(key)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing key_file: %w", err)
+	}
+
+	hostKeys, err := knownhosts.New(s.knownHosts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading known_hosts: %w", err)
+	}
+
+	conn, err := ssh.Dial("tcp", s.addr, &ssh.ClientConfig{
+		User:            s.user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeys,
+		Timeout:         30 * time.Second,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sc, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	return conn, sc, nil
+}
+
+func (s sftpShipper) Ship(localPath string) error {
+
+	conn, sc, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if sc != nil {
+			sc.Close()
+			conn.Close()
+		}
+	}()
+
+	err = walkShipFiles(localPath, func(fileName string, rel string) error {
+		remote := path.Join(s.remoteDir, rel)
+
+		var err error
+		for attempt := 0; attempt <= SFTPUploadRetries; attempt++ {
+			if attempt > 0 {
+				logger(fmt.Sprintf("Retrying upload of %s (%d/%d): %v", rel, attempt, SFTPUploadRetries, err), "warn", true, false, config, LogFieldOp, "ship")
+				time.Sleep(time.Second << attempt)
+			}
+			if sc == nil {
+				if conn, sc, err = s.connect(); err != nil {
+					continue
+				}
+			}
+			if err = s.uploadFile(sc, fileName, remote); err == nil {
+				return nil
+			}
+			// Most likely the connection dropped, reconnect and pick up from the .part file
+			sc.Close()
+			conn.Close()
+			conn, sc = nil, nil
+		}
+		return fmt.Errorf("uploading %s: %w", rel, err)
+	})
+	if err != nil || !s.mirror {
+		return err
+	}
+
+	removed, err := removeStale(localPath, func(rel string) (map[string]bool, error) {
+		entries, err := sc.ReadDir(path.Join(s.remoteDir, rel))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		listing := make(map[string]bool, len(entries))
+		for _, e := range entries {
+			listing[e.Name()] = e.IsDir()
+		}
+		return listing, err
+	}, func(rel string, dir bool) error {
+		if dir {
+			return sc.RemoveAll(path.Join(s.remoteDir, rel))
+		}
+		return sc.Remove(path.Join(s.remoteDir, rel))
+	})
+	logger(fmt.Sprintf("Mirror: %d stale file(s) or directories removed from %s", removed, s), "info", true, true, config, LogFieldOp, "ship")
+	return err
+}
+
+/*
+uploadFile uploads one file, resuming a .part file left by an earlier try
+*/
+func (s sftpShipper) uploadFile(sc *sftp.Client, fileName string, remote string) error {
+
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+
+	// Already there from an earlier run, size alone misses an edit that kept the length (a date, a ticked checklist item)
+	if ri, err := sc.Stat(remote); err == nil && ri.Size() == size {
+		if s.checksum {
+			if err := s.verifyChecksum(sc, fileName, remote); err == nil {
+				return nil
+			}
+		} else if ri.ModTime().Equal(info.ModTime().Truncate(time.Second)) {
+			return nil
+		}
+	}
+
+	if err := sc.MkdirAll(path.Dir(remote)); err != nil {
+		return fmt.Errorf("creating remote directory: %w", err)
+	}
+
+	part := remote + ".part"
+	var offset int64
+	// Only resume a .part written since the local file was, older ones are from a different version of it
+	if pi, err := sc.Stat(part); err == nil && pi.Size() <= size && !pi.ModTime().Before(info.ModTime().Truncate(time.Second)) {
+		offset = pi.Size()
+	}
+
+	local, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer local.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset > 0 {
+		if _, err := local.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		logger(fmt.Sprintf("Resuming upload of %s at %d of %d bytes", remote, offset, size), "info", true, true, config, LogFieldOp, "ship")
+	} else {
+		flags |= os.O_TRUNC
+	}
+
+	rf, err := sc.OpenFile(part, flags)
+	if err != nil {
+		return err
+	}
+	// Writes go at the file offset, O_APPEND isn't honored by every server
+	if _, err := rf.Seek(offset, io.SeekStart); err != nil {
+		rf.Close()
+		return err
+	}
+	if _, err := io.Copy(rf, local); err != nil {
+		rf.Close()
+		return err
+	}
+	if err := rf.Close(); err != nil {
+		return err
+	}
+
+	// Verify before the file takes its real name
+	pi, err := sc.Stat(part)
+	if err != nil {
+		return err
+	}
+	if pi.Size() != size {
+		// Start over next time, a .part bigger or smaller than expected can't be trusted
+		_ = sc.Remove(part)
+		return fmt.Errorf("remote size %d does not match local size %d", pi.Size(), size)
+	}
+	if s.checksum {
+		if err := s.verifyChecksum(sc, fileName, part); err != nil {
+			_ = sc.Remove(part)
+			return err
+		}
+	}
+
+	if err := sc.PosixRename(part, remote); err != nil {
+		// Server without the posix-rename extension, plain rename won't replace an existing file
+		_ = sc.Remove(remote)
+		if err := sc.Rename(part, remote); err != nil {
+			return err
+		}
+	}
+
+	// What the next run compares against, a server that won't set it just gets the file again
+	mtime := info.ModTime().Truncate(time.Second)
+	if err := sc.Chtimes(remote, time.Now(), info.ModTime()); err != nil {
+		s.warnMtime("unable to set the modified time of " + remote + ": " + err.Error())
+	} else if ri, err := sc.Stat(remote); err == nil && !ri.ModTime().Equal(mtime) {
+		s.warnMtime("the server ignored the modified time set on " + remote)
+	}
+
+	return nil
+}
+
+/*
+warnMtime says once per run that uploads can't be skipped on this server, unless checksum is on and they don't need to be
+*/
+func (s sftpShipper) warnMtime(why string) {
+	if s.checksum || !sftpMtimeWarned.CompareAndSwap(false, true) {
+		return
+	}
+	logger("Warning: "+s.String()+": "+why+", unchanged files will be uploaded again every run (checksum: true skips them by sha256 instead)", "warn", true, false, config, LogFieldOp, "ship")
+}
+
+/*
+verifyChecksum reads the remote file back and compares its sha256 with the local file
+*/
+func (s sftpShipper) verifyChecksum(sc *sftp.Client, fileName string, remote string) error {
+
+	localSum, err := fileSHA256(func() (io.ReadCloser, error) { return os.Open(fileName) })
+	if err != nil {
+		return err
+	}
+	remoteSum, err := fileSHA256(func() (io.ReadCloser, error) { return sc.Open(remote) })
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(localSum, remoteSum) {
+		return fmt.Errorf("sha256 of %s does not match the local file", remote)
+	}
+
+	return nil
+}
+
+func fileSHA256(open func() (io.ReadCloser, error)) ([]byte, error) {
+	f, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// A local SSH server with the SFTP subsystem, and the files a client needs to reach it
+type testSFTPServer struct {
+	host       string
+	port       int
+	keyFile    string // client private key
+	knownHosts string // holds the server's real host key
+}
+
+/*
+newTestSFTPServer starts an SSH server on localhost that only accepts the generated client
+key and serves SFTP on the real filesystem
+*/
+func newTestSFTPServer(t *testing.T) testSFTPServer {
+	t.Helper()
+
+	prevConfig := config
+	config.ARGS.SuperQuiet = true
+	config.ENV.SSHPASSPHRASE = ""
+	t.Cleanup(func() { config = prevConfig })
+
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPub, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientSSHPub, err := ssh.NewPublicKey(clientPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sc := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), clientSSHPub.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	sc.AddHostKey(hostSigner)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				serveTestSSH(conn, sc)
+			}()
+		}
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		wg.Wait()
+	})
+
+	dir := t.TempDir()
+	block, err := ssh.MarshalPrivateKey(clientPriv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().(*net.TCPAddr)
+	knownHosts := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(ln.Addr().String())}, hostSigner.PublicKey())
+	if err := os.WriteFile(knownHosts, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return testSFTPServer{host: addr.IP.String(), port: addr.Port, keyFile: keyFile, knownHosts: knownHosts}
+}
+
+// serveTestSSH runs one SSH connection, session channels only, with the sftp subsystem
+func serveTestSSH(nConn net.Conn, sc *ssh.ServerConfig) {
+	defer nConn.Close()
+
+	conn, chans, reqs, err := ssh.NewServerConn(nConn, sc)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			_ = nc.Reject(ssh.UnknownChannelType, "session only")
+			continue
+		}
+		ch, chReqs, err := nc.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range chReqs {
+				// Payload is an SSH string, uint32 length then the subsystem name
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				_ = req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				srv, err := sftp.NewServer(ch)
+				if err != nil {
+					ch.Close()
+					return
+				}
+				_ = srv.Serve()
+				srv.Close()
+				return
+			}
+		}()
+	}
+}
+
+func (ts testSFTPServer) shipper(t *testing.T, remoteDir string, checksum bool) sftpShipper {
+	t.Helper()
+
+	s, err := newSFTPShipper(ShipConfig{Type: "sftp", Host: ts.host, Port: ts.port, User: "trellgo", KeyFile: ts.keyFile, KnownHosts: ts.knownHosts, Path: remoteDir, Checksum: checksum})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.(sftpShipper)
+}
+
+// dial opens an SFTP session as the shipper would, for calling uploadFile directly
+func (ts testSFTPServer) dial(t *testing.T, s sftpShipper) *sftp.Client {
+	t.Helper()
+
+	conn, sc, err := s.connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sc.Close()
+		conn.Close()
+	})
+	return sc
+}
+
+func writeTestFile(t *testing.T, fileName string, data []byte, mod time.Time) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(fileName), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fileName, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(fileName, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestFile(t *testing.T, fileName string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestSFTPKnownHosts(t *testing.T) {
+	ts := newTestSFTPServer(t)
+	remoteDir := t.TempDir()
+	local := filepath.Join(t.TempDir(), "Board")
+	writeTestFile(t, filepath.Join(local, "card.md"), []byte("# Card\n"), time.Now())
+
+	// Another key for the same host, ie the server was replaced or someone is in the middle
+	otherPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	otherKey, _ := ssh.NewPublicKey(otherPub)
+	wrongHosts := filepath.Join(t.TempDir(), "known_hosts")
+	writeTestFile(t, wrongHosts, []byte(knownhosts.Line([]string{knownhosts.Normalize(net.JoinHostPort(ts.host, strconv.Itoa(ts.port)))}, otherKey)+"\n"), time.Now())
+	emptyHosts := filepath.Join(t.TempDir(), "known_hosts")
+	writeTestFile(t, emptyHosts, nil, time.Now())
+
+	for _, tt := range []struct {
+		name       string
+		knownHosts string
+		want       string
+	}{
+		{"changed host key", wrongHosts, "key mismatch"},
+		{"unknown host", emptyHosts, "key is unknown"},
+	} {
+		s := ts.shipper(t, remoteDir, false)
+		s.knownHosts = tt.knownHosts
+		err := s.Ship(local)
+		var keyErr *knownhosts.KeyError
+		if err == nil || !errors.As(err, &keyErr) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Ship error = %v, want a known_hosts %q error", tt.name, err, tt.want)
+		}
+	}
+	if entries, _ := os.ReadDir(remoteDir); len(entries) != 0 {
+		t.Fatalf("rejected host was sent %d file(s)", len(entries))
+	}
+
+	// The right key is let through
+	if err := ts.shipper(t, remoteDir, false).Ship(local); err != nil {
+		t.Fatalf("Ship with the real host key: %v", err)
+	}
+	if got := readTestFile(t, filepath.Join(remoteDir, "Board", "card.md")); got != "# Card\n" {
+		t.Fatalf("remote card.md = %q", got)
+	}
+}
+
+func TestSFTPShipUnchangedAndEdited(t *testing.T) {
+	ts := newTestSFTPServer(t)
+	remoteDir := t.TempDir()
+	local := filepath.Join(t.TempDir(), "Board")
+	card := filepath.Join(local, "List", "Card", "card.md")
+	remote := filepath.Join(remoteDir, "Board", "List", "Card", "card.md")
+	s := ts.shipper(t, remoteDir, false)
+
+	first := time.Now().Add(-time.Hour).Truncate(time.Second)
+	writeTestFile(t, card, []byte("Due: 2024-05-01\n"), first)
+	if err := s.Ship(local); err != nil {
+		t.Fatal(err)
+	}
+	ri, err := os.Stat(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ri.ModTime().Equal(first) {
+		t.Errorf("remote mtime = %v, want the local %v", ri.ModTime(), first)
+	}
+
+	// Same size and mtime is taken as already there, tamper with the remote to see it left alone
+	writeTestFile(t, remote, []byte("Due: 1999-12-31\n"), first)
+	if err := s.Ship(local); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, remote); got != "Due: 1999-12-31\n" {
+		t.Errorf("unchanged file was uploaded again, remote = %q", got)
+	}
+
+	// An edit that keeps the length (a changed date) still goes up
+	writeTestFile(t, card, []byte("Due: 2024-06-15\n"), first.Add(time.Minute))
+	if err := s.Ship(local); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, remote); got != "Due: 2024-06-15\n" {
+		t.Errorf("same length edit not shipped, remote = %q", got)
+	}
+
+	// A remote of another size is replaced whatever its mtime
+	writeTestFile(t, remote, []byte("short\n"), first.Add(time.Minute))
+	if err := s.Ship(local); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, remote); got != "Due: 2024-06-15\n" {
+		t.Errorf("remote of another size not replaced, remote = %q", got)
+	}
+}
+
+func TestSFTPResumePart(t *testing.T) {
+	ts := newTestSFTPServer(t)
+	remoteDir := t.TempDir()
+	fileName := filepath.Join(t.TempDir(), "attachment.bin")
+	remote := filepath.Join(remoteDir, "attachment.bin")
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4096) // 64 KiB
+	writeTestFile(t, fileName, data, time.Now().Add(-time.Hour))
+
+	// Left by an upload that dropped a quarter of the way, written after the local file was.
+	// Filled with X so a resume (rather than a fresh upload) shows in the result.
+	writeTestFile(t, remote+".part", bytes.Repeat([]byte("X"), len(data)/4), time.Now())
+
+	s := ts.shipper(t, remoteDir, false)
+	if err := s.uploadFile(ts.dial(t, s), fileName, remote); err != nil {
+		t.Fatal(err)
+	}
+	got := readTestFile(t, remote)
+	if want := strings.Repeat("X", len(data)/4) + string(data[len(data)/4:]); got != want {
+		t.Fatalf("upload did not resume from the .part (%d bytes, starts %q)", len(got), got[:16])
+	}
+	if _, err := os.Stat(remote + ".part"); !os.IsNotExist(err) {
+		t.Errorf(".part still there after the rename: %v", err)
+	}
+
+	// A .part older than the local file is from a different version of it, start over
+	_ = os.Remove(remote)
+	writeTestFile(t, remote+".part", bytes.Repeat([]byte("X"), len(data)/4), time.Now().Add(-2*time.Hour))
+	if err := s.uploadFile(ts.dial(t, s), fileName, remote); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, remote); got != string(data) {
+		t.Fatalf("stale .part was resumed")
+	}
+
+	// A .part bigger than the local file can't be a prefix of it
+	_ = os.Remove(remote)
+	writeTestFile(t, remote+".part", bytes.Repeat([]byte("X"), len(data)+10), time.Now())
+	if err := s.uploadFile(ts.dial(t, s), fileName, remote); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, remote); got != string(data) {
+		t.Fatalf("oversized .part was resumed, remote is %d bytes", len(got))
+	}
+}
+
+func TestSFTPChecksumMismatch(t *testing.T) {
+	ts := newTestSFTPServer(t)
+	remoteDir := t.TempDir()
+	fileName := filepath.Join(t.TempDir(), "attachment.bin")
+	remote := filepath.Join(remoteDir, "attachment.bin")
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	mod := time.Now().Add(-time.Hour).Truncate(time.Second)
+	writeTestFile(t, fileName, data, mod)
+
+	s := ts.shipper(t, remoteDir, true)
+	sc := ts.dial(t, s)
+
+	// A resumed .part whose start isn't the local file: the size matches, the sha256 doesn't
+	writeTestFile(t, remote+".part", bytes.Repeat([]byte("X"), len(data)/4), time.Now())
+	err := s.uploadFile(sc, fileName, remote)
+	if err == nil || !strings.Contains(err.Error(), "sha256") {
+		t.Fatalf("uploadFile = %v, want a sha256 mismatch", err)
+	}
+	if _, err := os.Stat(remote); !os.IsNotExist(err) {
+		t.Errorf("file with a bad checksum took its real name: %v", err)
+	}
+	if _, err := os.Stat(remote + ".part"); !os.IsNotExist(err) {
+		t.Errorf("bad .part was kept for the next try: %v", err)
+	}
+
+	// The retry starts over and gets it right
+	if err := s.uploadFile(sc, fileName, remote); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, remote); got != string(data) {
+		t.Fatal("retry after a checksum mismatch did not upload the file")
+	}
+
+	// With checksum on a matching size and mtime isn't enough, the content is compared
+	corrupt := bytes.Clone(data)
+	corrupt[100] = '!'
+	writeTestFile(t, remote, corrupt, mod)
+	if err := s.uploadFile(sc, fileName, remote); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, remote); got != string(data) {
+		t.Fatal("remote with the same size and mtime but other content was skipped")
+	}
+}
+
+func TestSFTPMirror(t *testing.T) {
+	ts := newTestSFTPServer(t)
+	remoteDir := t.TempDir()
+	s := ts.shipper(t, remoteDir, false)
+	s.mirror = true
+
+	testMirror(t, s, remoteDir, true)
+}
@@ -0,0 +1,542 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adlio/trello"
+	_ "modernc.org/sqlite"
+)
+
+// Written in each storage path when the sqlite format is on, upserted on every run after the first
+const SQLiteFile = "trellgo.sqlite"
+
+// Bumped when the tables change, kept in PRAGMA user_version
+const SQLiteSchemaVersion = 1
+
+/*
+Normalized tables for --format sqlite.  IDs are Trello's, times are RFC 3339 UTC text.
+synced_at is the last run that saw the row, deleted_at is set on cards and lists found deleted in Trello.
+REFERENCES document the joins but aren't enforced, a card can point at a label that couldn't be fetched.
+*/
+var sqliteSchema = []string{
+	`CREATE TABLE IF NOT EXISTS boards (
+		id              TEXT PRIMARY KEY,
+		name            TEXT NOT NULL,
+		description     TEXT,
+		url             TEXT,
+		closed          INTEGER NOT NULL DEFAULT 0,
+		organization_id TEXT,
+		synced_at       TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS lists (
+		id         TEXT PRIMARY KEY,
+		board_id   TEXT NOT NULL REFERENCES boards(id),
+		name       TEXT NOT NULL,
+		closed     INTEGER NOT NULL DEFAULT 0,
+		pos        REAL,
+		deleted_at TEXT,
+		synced_at  TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS labels (
+		id        TEXT PRIMARY KEY,
+		board_id  TEXT NOT NULL REFERENCES boards(id),
+		name      TEXT,
+		color     TEXT,
+		synced_at TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS members (
+		id        TEXT PRIMARY KEY,
+		username  TEXT,
+		full_name TEXT,
+		initials  TEXT,
+		synced_at TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS cards (
+		id               TEXT PRIMARY KEY,
+		board_id         TEXT NOT NULL REFERENCES boards(id),
+		list_id          TEXT REFERENCES lists(id),
+		name             TEXT NOT NULL,
+		description      TEXT,
+		url              TEXT,
+		short_link       TEXT,
+		closed           INTEGER NOT NULL DEFAULT 0,
+		is_link          INTEGER NOT NULL DEFAULT 0,
+		pos              REAL,
+		start_at         TEXT,
+		due_at           TEXT,
+		due_complete     INTEGER NOT NULL DEFAULT 0,
+		created_at       TEXT,
+		last_activity_at TEXT,
+		deleted_at       TEXT,
+		synced_at        TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS card_labels (
+		card_id  TEXT NOT NULL REFERENCES cards(id),
+		label_id TEXT NOT NULL REFERENCES labels(id),
+		PRIMARY KEY (card_id, label_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS card_members (
+		card_id   TEXT NOT NULL REFERENCES cards(id),
+		member_id TEXT NOT NULL REFERENCES members(id),
+		PRIMARY KEY (card_id, member_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS checklists (
+		id      TEXT PRIMARY KEY,
+		card_id TEXT NOT NULL REFERENCES cards(id),
+		name    TEXT,
+		pos     REAL
+	)`,
+	`CREATE TABLE IF NOT EXISTS check_items (
+		id           TEXT PRIMARY KEY,
+		checklist_id TEXT NOT NULL REFERENCES checklists(id),
+		card_id      TEXT NOT NULL REFERENCES cards(id),
+		name         TEXT,
+		state        TEXT,
+		pos          REAL
+	)`,
+	`CREATE TABLE IF NOT EXISTS actions (
+		id                TEXT PRIMARY KEY,
+		board_id          TEXT REFERENCES boards(id),
+		card_id           TEXT REFERENCES cards(id),
+		list_id           TEXT,
+		list_before_id    TEXT,
+		list_after_id     TEXT,
+		type              TEXT NOT NULL,
+		date              TEXT NOT NULL,
+		member_creator_id TEXT REFERENCES members(id),
+		text              TEXT,
+		data              TEXT
+	)`,
+	`CREATE TABLE IF NOT EXISTS attachments (
+		id        TEXT PRIMARY KEY,
+		card_id   TEXT NOT NULL REFERENCES cards(id),
+		name      TEXT,
+		url       TEXT,
+		bytes     INTEGER,
+		mime_type TEXT,
+		date      TEXT,
+		member_id TEXT REFERENCES members(id),
+		is_upload INTEGER NOT NULL DEFAULT 0,
+		is_cover  INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE TABLE IF NOT EXISTS custom_fields (
+		id        TEXT PRIMARY KEY,
+		board_id  TEXT NOT NULL REFERENCES boards(id),
+		name      TEXT,
+		type      TEXT,
+		pos       INTEGER,
+		synced_at TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS custom_field_values (
+		card_id         TEXT NOT NULL REFERENCES cards(id),
+		custom_field_id TEXT NOT NULL REFERENCES custom_fields(id),
+		value           TEXT,
+		PRIMARY KEY (card_id, custom_field_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS cards_board ON cards(board_id)`,
+	`CREATE INDEX IF NOT EXISTS cards_list ON cards(list_id)`,
+	`CREATE INDEX IF NOT EXISTS lists_board ON lists(board_id)`,
+	`CREATE INDEX IF NOT EXISTS checklists_card ON checklists(card_id)`,
+	`CREATE INDEX IF NOT EXISTS check_items_card ON check_items(card_id)`,
+	`CREATE INDEX IF NOT EXISTS actions_card ON actions(card_id, date)`,
+	`CREATE INDEX IF NOT EXISTS actions_board ON actions(board_id, date)`,
+	`CREATE INDEX IF NOT EXISTS attachments_card ON attachments(card_id)`,
+}
+
+var (
+	upsertBoardSQL       = upsertSQL("boards", "id", "name", "description", "url", "closed", "organization_id", "synced_at")
+	upsertListSQL        = upsertSQL("lists", "id", "board_id", "name", "closed", "pos", "deleted_at", "synced_at")
+	upsertLabelSQL       = upsertSQL("labels", "id", "board_id", "name", "color", "synced_at")
+	upsertMemberSQL      = upsertSQL("members", "id", "username", "full_name", "initials", "synced_at")
+	upsertCustomFieldSQL = upsertSQL("custom_fields", "id", "board_id", "name", "type", "pos", "synced_at")
+	upsertCardSQL        = upsertSQL("cards", "id", "board_id", "list_id", "name", "description", "url", "short_link", "closed", "is_link",
+		"pos", "start_at", "due_at", "due_complete", "created_at", "last_activity_at", "deleted_at", "synced_at")
+	upsertActionSQL = upsertSQL("actions", "id", "board_id", "card_id", "list_id", "list_before_id", "list_after_id", "type", "date",
+		"member_creator_id", "text", "data")
+)
+
+/*
+sqliteExport
+
+	One storage path's database, opened on the first board that wants it and closed at the end of the run.
+	database/sql is held to a single connection so card workers take turns, each card is one transaction.
+*/
+type sqliteExport struct {
+	db       *sql.DB
+	path     string
+	syncedAt string
+	options  sync.Map // custom field option ID -> text, for list type custom fields
+}
+
+var (
+	sqliteExportsMu sync.Mutex
+	sqliteExports   = make(map[string]*sqliteExport)
+)
+
+/*
+openSQLiteExport
+
+	The export for a board, nil when the sqlite format isn't on for it or the database can't be opened
+*/
+func openSQLiteExport(config Config) *sqliteExport {
+
+	if !hasFormat(config.ARGS, "sqlite") {
+		return nil
+	}
+
+	sqliteExportsMu.Lock()
+	defer sqliteExportsMu.Unlock()
+
+	root := config.ARGS.StoragePath
+	if e, ok := sqliteExports[root]; ok {
+		return e
+	}
+
+	path := filepath.Join(root, SQLiteFile)
+	e, err := newSQLiteExport(path)
+	if err != nil {
+		logger("CRITICAL - Unable to open SQLite export "+path+": "+err.Error(), "err", true, false, config, LogFieldOp, "sqlite")
+		errorWarnOnCompletion = true
+		return nil
+	}
+	logger("Writing SQLite export to "+path, "info", true, true, config, LogFieldOp, "sqlite")
+	sqliteExports[root] = e
+
+	return e
+}
+
+/*
+newSQLiteExport opens (or creates) a database file and brings its tables up to date
+*/
+func newSQLiteExport(path string) (*sqliteExport, error) {
+
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(10000)")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if version > SQLiteSchemaVersion {
+		db.Close()
+		return nil, fmt.Errorf("written by a newer trellgo (schema %d, this version knows %d)", version, SQLiteSchemaVersion)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	for _, stmt := range sqliteSchema {
+		if _, err := tx.Exec(stmt); err != nil {
+			tx.Rollback()
+			db.Close()
+			return nil, err
+		}
+	}
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", SQLiteSchemaVersion)); err != nil {
+		tx.Rollback()
+		db.Close()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &sqliteExport{db: db, path: path, syncedAt: time.Now().UTC().Format(time.RFC3339)}, nil
+}
+
+/*
+closeSQLiteExports closes every database opened this run
+*/
+func closeSQLiteExports() {
+	sqliteExportsMu.Lock()
+	defer sqliteExportsMu.Unlock()
+
+	for root, e := range sqliteExports {
+		if err := e.db.Close(); err != nil {
+			logger("Error: Unable to close SQLite export "+e.path+": "+err.Error(), "err", true, false, config, LogFieldOp, "sqlite")
+		}
+		delete(sqliteExports, root)
+	}
+}
+
+/*
+writeBoard
+
+	Upsert the board and what hangs off it: lists, labels, members and custom field definitions
+*/
+func (e *sqliteExport) writeBoard(board *trello.Board, lists map[string]*trello.List, labels []*trello.Label, members []BoardMember) {
+	if e == nil {
+		return
+	}
+
+	// Custom field definitions aren't needed for the Markdown, only fetched for the database
+	fields, err := board.GetCustomFields(trello.Defaults())
+	if err != nil {
+		logger("Warning: Unable to get custom fields for board "+board.Name+": "+err.Error(), "warn", true, false, config, LogFieldBoard, board.ID, LogFieldOp, "sqlite")
+	}
+
+	err = e.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(upsertBoardSQL, board.ID, board.Name, board.Desc, board.URL, board.Closed, nullString(board.IDOrganization), e.syncedAt); err != nil {
+			return err
+		}
+		for _, l := range lists {
+			if _, err := tx.Exec(upsertListSQL, l.ID, board.ID, l.Name, l.Closed, l.Pos, nil, e.syncedAt); err != nil {
+				return err
+			}
+		}
+		for _, l := range labels {
+			if _, err := tx.Exec(upsertLabelSQL, l.ID, board.ID, l.Name, l.Color, e.syncedAt); err != nil {
+				return err
+			}
+		}
+		for _, m := range members {
+			if _, err := tx.Exec(upsertMemberSQL, m.ID, m.Username, m.FullName, m.Initials, e.syncedAt); err != nil {
+				return err
+			}
+		}
+		for _, f := range fields {
+			if _, err := tx.Exec(upsertCustomFieldSQL, f.ID, board.ID, f.Name, f.Type, f.Pos, e.syncedAt); err != nil {
+				return err
+			}
+			for _, o := range f.Options {
+				e.options.Store(o.ID, o.Value.Text)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger("Error: Unable to write board "+board.Name+" to SQLite export: "+err.Error(), "err", true, false, config, LogFieldBoard, board.ID, LogFieldOp, "sqlite")
+		errorWarnOnCompletion = true
+	}
+}
+
+/*
+writeCard
+
+	Upsert a card.  full is when card came from getComprehensiveCardData, then its labels,
+	members, checklists, attachments and custom field values replace what was there and
+	its actions are added, otherwise (link cards, or the fetch failed) only the card row is.
+*/
+func (e *sqliteExport) writeCard(card *trello.Card, full bool, isLink bool) {
+	if e == nil {
+		return
+	}
+
+	err := e.inTx(func(tx *sql.Tx) error {
+		created := card.CreatedAt()
+		if _, err := tx.Exec(upsertCardSQL, card.ID, card.IDBoard, nullString(card.IDList), card.Name, card.Desc, card.URL, card.ShortLink,
+			card.Closed, isLink, card.Pos, sqlTime(card.Start), sqlTime(card.Due), card.DueComplete, sqlTime(&created),
+			sqlTime(card.DateLastActivity), nil, e.syncedAt); err != nil {
+			return err
+		}
+		if !full {
+			return nil
+		}
+
+		for _, table := range []string{"card_labels", "card_members", "check_items", "checklists", "attachments", "custom_field_values"} {
+			if _, err := tx.Exec("DELETE FROM "+table+" WHERE card_id = ?", card.ID); err != nil {
+				return err
+			}
+		}
+
+		for _, l := range card.Labels {
+			if _, err := tx.Exec("INSERT OR IGNORE INTO card_labels (card_id, label_id) VALUES (?, ?)", card.ID, l.ID); err != nil {
+				return err
+			}
+		}
+		for _, m := range card.Members {
+			if _, err := tx.Exec(upsertMemberSQL, m.ID, m.Username, m.FullName, m.Initials, e.syncedAt); err != nil {
+				return err
+			}
+			if _, err := tx.Exec("INSERT OR IGNORE INTO card_members (card_id, member_id) VALUES (?, ?)", card.ID, m.ID); err != nil {
+				return err
+			}
+		}
+		for _, cl := range card.Checklists {
+			if _, err := tx.Exec("INSERT OR REPLACE INTO checklists (id, card_id, name, pos) VALUES (?, ?, ?, ?)", cl.ID, card.ID, cl.Name, cl.Pos); err != nil {
+				return err
+			}
+			for _, item := range cl.CheckItems {
+				if _, err := tx.Exec("INSERT OR REPLACE INTO check_items (id, checklist_id, card_id, name, state, pos) VALUES (?, ?, ?, ?, ?, ?)",
+					item.ID, cl.ID, card.ID, item.Name, item.State, item.Pos); err != nil {
+					return err
+				}
+			}
+		}
+		for _, a := range card.Attachments {
+			if _, err := tx.Exec("INSERT OR REPLACE INTO attachments (id, card_id, name, url, bytes, mime_type, date, member_id, is_upload, is_cover) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+				a.ID, card.ID, a.Name, a.URL, a.Bytes, nullString(a.MimeType), nullString(a.Date), nullString(a.IDMember), a.IsUpload, a.ID == card.IDAttachmentCover); err != nil {
+				return err
+			}
+		}
+		for _, item := range card.CustomFieldItems {
+			if _, err := tx.Exec("INSERT OR REPLACE INTO custom_field_values (card_id, custom_field_id, value) VALUES (?, ?, ?)",
+				card.ID, item.IDCustomField, e.customFieldValue(item)); err != nil {
+				return err
+			}
+		}
+
+		// Actions are history, ones no longer returned (past the 1000 limit) are kept
+		for _, a := range card.Actions {
+			if err := e.writeAction(tx, card, a); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger("Error: Unable to write card "+card.Name+" to SQLite export: "+err.Error(), "err", true, false, config, cardLogFields(card, "sqlite")...)
+		errorWarnOnCompletion = true
+	}
+}
+
+/*
+writeAction upserts one of a card's actions, and the member who did it
+*/
+func (e *sqliteExport) writeAction(tx *sql.Tx, card *trello.Card, a *trello.Action) error {
+
+	var (
+		listID, before, after any
+		text                  string
+		data                  []byte
+	)
+	if a.Data != nil {
+		text = a.Data.Text
+		if a.Data.List != nil {
+			listID = a.Data.List.ID
+		}
+		if a.Data.ListBefore != nil {
+			before = a.Data.ListBefore.ID
+		}
+		if a.Data.ListAfter != nil {
+			after = a.Data.ListAfter.ID
+		}
+		data, _ = json.Marshal(a.Data)
+	}
+
+	if m := a.MemberCreator; m != nil {
+		if _, err := tx.Exec(upsertMemberSQL, m.ID, m.Username, m.FullName, m.Initials, e.syncedAt); err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.Exec(upsertActionSQL, a.ID, card.IDBoard, card.ID, listID, before, after, a.Type, a.Date.UTC().Format(time.RFC3339),
+		nullString(a.IDMemberCreator), nullString(text), nullString(string(data)))
+	return err
+}
+
+/*
+markDeleted sets deleted_at on the cards and lists the board state has found gone from Trello
+*/
+func (e *sqliteExport) markDeleted(items []DeletedItem) {
+	if e == nil || len(items) == 0 {
+		return
+	}
+
+	err := e.inTx(func(tx *sql.Tx) error {
+		for _, item := range items {
+			var table string
+			switch item.Type {
+			case "card":
+				table = "cards"
+			case "list":
+				table = "lists"
+			default:
+				continue
+			}
+			if _, err := tx.Exec("UPDATE "+table+" SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL",
+				item.DetectedAt.UTC().Format(time.RFC3339), item.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger("Error: Unable to mark deleted cards in SQLite export: "+err.Error(), "err", true, false, config, LogFieldOp, "sqlite")
+		errorWarnOnCompletion = true
+	}
+}
+
+/*
+customFieldValue is a card's custom field value as text, list fields give the chosen option's text
+*/
+func (e *sqliteExport) customFieldValue(item *trello.CustomFieldItem) any {
+	if item.IDValue != "" {
+		if text, ok := e.options.Load(item.IDValue); ok {
+			return text
+		}
+		return item.IDValue
+	}
+
+	switch v := item.Value.Get().(type) {
+	case nil:
+		return nil
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+/*
+inTx runs fn in a transaction, committed if it returns nil
+*/
+func (e *sqliteExport) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := e.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+/*
+upsertSQL builds an INSERT that updates every other column when the key is already there
+*/
+func upsertSQL(table string, key string, cols ...string) string {
+	set := make([]string, len(cols))
+	for i, c := range cols {
+		set[i] = c + " = excluded." + c
+	}
+	all := append([]string{key}, cols...)
+
+	return "INSERT INTO " + table + " (" + strings.Join(all, ", ") + ") VALUES (?" + strings.Repeat(", ?", len(cols)) +
+		") ON CONFLICT(" + key + ") DO UPDATE SET " + strings.Join(set, ", ")
+}
+
+// sqlTime is a Trello time as RFC 3339 UTC text, NULL when not set
+func sqlTime(t *time.Time) any {
+	if t == nil || t.IsZero() {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// nullString stores an empty string as NULL
+func nullString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adlio/trello"
+)
+
+// Kept in each board directory, what the last run wrote and where
+const BoardStateFile = ".trellgo-state.json"
+
+// Where cards that no longer exist in Trello are moved to, under the board directory
+const DeletedDir = "DELETED"
+
+/*
+BoardState
+
+	Where each card of a board was written on the last run, keyed by card ID.
+	Loaded at the start of a board and saved at the end, it is how a renamed or moved
+	card's directory is found again and how cards deleted in Trello are noticed.
+*/
+type BoardState struct {
+	Version   int                  `json:"version"`
+	BoardID   string               `json:"board_id"`
+	UpdatedAt time.Time            `json:"updated_at"`
+	Cards     map[string]CardState `json:"cards"`
+	Lists     map[string]ListState `json:"lists"`
+	Deleted   []DeletedItem        `json:"deleted,omitempty"` // everything found gone so far, oldest first
+
+	mu        sync.Mutex
+	boardDir  string
+	prev      map[string]CardState // as loaded, Cards fills up with this run
+	prevLists map[string]ListState
+	legacy    bool // no state file, the dump (if any) is from before card IDs were in the names
+}
+
+/*
+CardState is one card's entry in the board state
+*/
+type CardState struct {
+	Name   string `json:"name"`
+	ListID string `json:"list_id"`
+	Path   string `json:"path"` // relative to the board directory, slash separated
+}
+
+/*
+ListState is one list's entry in the board state
+*/
+type ListState struct {
+	Name string `json:"name"`
+	Path string `json:"path"` // relative to the board directory, slash separated
+}
+
+/*
+loadBoardState reads the board's state file, a missing or unreadable one starts empty
+*/
+func loadBoardState(boardDir string, boardID string) *BoardState {
+
+	s := &BoardState{
+		Version:   1,
+		BoardID:   boardID,
+		Cards:     make(map[string]CardState),
+		Lists:     make(map[string]ListState),
+		boardDir:  boardDir,
+		prev:      make(map[string]CardState),
+		prevLists: make(map[string]ListState),
+	}
+
+	data, err := os.ReadFile(filepath.Join(boardDir, BoardStateFile))
+	if err != nil {
+		s.legacy = errors.Is(err, os.ErrNotExist)
+		return s
+	}
+
+	var loaded BoardState
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		logger("Warning: Unable to read board state "+filepath.Join(boardDir, BoardStateFile)+", renamed cards will not be found this run: "+err.Error(), "warn", true, false, config, LogFieldBoard, boardID, LogFieldOp, "state")
+		return s
+	}
+	if loaded.Cards != nil {
+		s.prev = loaded.Cards
+	}
+	if loaded.Lists != nil {
+		s.prevLists = loaded.Lists
+	}
+	s.Deleted = loaded.Deleted
+
+	return s
+}
+
+/*
+save writes the board state through a temp file, so an interrupted run keeps the old one
+*/
+func (s *BoardState) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.boardDir, ".trellgo-state-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), SecureFileMode); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(s.boardDir, BoardStateFile))
+}
+
+/*
+claim
+
+	Record where a card is being written this run.  If the last run wrote it somewhere
+	else (renamed, moved list, archived) that directory or file is moved here first, so
+	nothing is left behind under the old name.  A dump from before card IDs were part of
+	the names is picked up the same way the first time.
+	Safe to call on a nil state (nothing is tracked).
+*/
+func (s *BoardState) claim(card *trello.Card, newPath string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	oldPath := ""
+	if prev, ok := s.prev[card.ID]; ok {
+		oldPath = filepath.Join(s.boardDir, filepath.FromSlash(prev.Path))
+	} else if s.legacy {
+		oldPath = filepath.Join(filepath.Dir(newPath), strings.Replace(filepath.Base(newPath), " "+cardIDSuffix(card), "", 1))
+	}
+
+	if oldPath != "" && oldPath != newPath {
+		s.move(card, oldPath, newPath)
+	}
+
+	rel, err := filepath.Rel(s.boardDir, newPath)
+	if err != nil {
+		return
+	}
+	s.Cards[card.ID] = CardState{Name: card.Name, ListID: card.IDList, Path: filepath.ToSlash(rel)}
+}
+
+/*
+move puts a card's old directory or file at its new path, s.mu is held
+*/
+func (s *BoardState) move(card *trello.Card, oldPath string, newPath string) {
+
+	if _, err := os.Stat(oldPath); err != nil {
+		return
+	}
+
+	// Both there, the new one wins and the old copy is stale
+	if _, err := os.Stat(newPath); err == nil {
+		logger("Card "+card.Name+" already has "+newPath+", removing the stale copy at "+oldPath, "warn", true, true, config, cardLogFields(card, "rename")...)
+		if err := os.RemoveAll(oldPath); err != nil {
+			logger("Error: Unable to remove stale card copy "+oldPath+": "+err.Error(), "err", true, false, config, cardLogFields(card, "rename")...)
+		}
+		removeEmptyDirs(filepath.Dir(oldPath), s.boardDir)
+		return
+	}
+
+	logger("Card renamed or moved, moving "+oldPath+" to "+newPath, "info", true, true, config, cardLogFields(card, "rename")...)
+	err := os.MkdirAll(filepath.Dir(newPath), SecureDirMode)
+	if err == nil {
+		err = os.Rename(oldPath, newPath)
+	}
+	if err != nil {
+		logger("Error: Unable to move "+oldPath+" to "+newPath+": "+err.Error(), "err", true, false, config, cardLogFields(card, "rename")...)
+		return
+	}
+	removeEmptyDirs(filepath.Dir(oldPath), s.boardDir)
+}
+
+/*
+removeGone
+
+	Handle cards and lists the last run wrote that weren't seen this run.  Trello is asked
+	which cards are still on the board in one go, as a card can just be archived or outside
+	the label filter, and about each missing list.  Ones that are gone from the board (deleted,
+	or moved to another board) get --on-deleted applied and are recorded, anything else is
+	kept in the state for next time.
+	cards and lists are what was fetched this run, a fetched card that failed to write is still there.
+*/
+func (s *BoardState) removeGone(board *trello.Board, cards []*trello.Card, lists map[string]*trello.List, client *trello.Client, mode string) {
+	if s == nil {
+		return
+	}
+
+	fetched := make(map[string]bool, len(cards))
+	for _, card := range cards {
+		fetched[card.ID] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Cards first, a gone list's cards are gone with it and land inside its DELETED/ directory
+	var missing []string
+	for id := range s.prev {
+		if _, ok := s.Cards[id]; ok {
+			continue
+		}
+		if fetched[id] {
+			s.Cards[id] = s.prev[id]
+			continue
+		}
+		missing = append(missing, id)
+	}
+
+	if len(missing) > 0 {
+		onBoard, err := boardCardIDs(board, client)
+		if err != nil {
+			logger("Warning: Unable to check if the "+strconv.Itoa(len(missing))+" card(s) not seen this run still exist, keeping them: "+err.Error(), "warn", true, false, config, LogFieldBoard, board.ID, LogFieldOp, "deleted")
+		}
+		for _, id := range missing {
+			prev := s.prev[id]
+			if err != nil || onBoard[id] {
+				s.Cards[id] = prev
+				continue
+			}
+
+			s.retire(DeletedItem{Type: "card", ID: id, Name: prev.Name, Path: prev.Path, Reason: "deleted or moved to another board"}, mode, board.ID, LogFieldBoard, board.ID, LogFieldCard, id)
+		}
+	}
+
+	for id, prev := range s.prevLists {
+		if _, ok := s.Lists[id]; ok {
+			continue
+		}
+		if _, ok := lists[id]; ok {
+			s.Lists[id] = prev
+			continue
+		}
+
+		list, err := client.GetList(id, trello.Arguments{"fields": "idBoard,closed,name"})
+		idBoard := ""
+		if err == nil {
+			idBoard = list.IDBoard
+		}
+		reason := goneReason(err, idBoard, board.ID, "list "+prev.Name+" ("+id+")", LogFieldBoard, board.ID, LogFieldList, prev.Name)
+		if reason == "" {
+			s.Lists[id] = prev
+			continue
+		}
+
+		s.retire(DeletedItem{Type: "list", ID: id, Name: prev.Name, Path: prev.Path, Reason: reason}, mode, board.ID, LogFieldBoard, board.ID, LogFieldList, prev.Name)
+	}
+}
+
+/*
+boardCardIDs
+
+	Every card still on the board that this run may not have fetched, one request per
+	filter rather than one per card.  Archived cards unless they were fetched already (-a),
+	and open ones when the label filter only fetched some of them.
+*/
+func boardCardIDs(board *trello.Board, client *trello.Client) (map[string]bool, error) {
+
+	var filters []string
+	if !config.ARGS.Archived || config.ARGS.LabelID != "" {
+		filters = append(filters, "closed")
+	}
+	if config.ARGS.LabelID != "" {
+		filters = append(filters, "open")
+	}
+
+	ids := make(map[string]bool)
+	for _, filter := range filters {
+		var cards []*trello.Card
+		if err := client.Get("boards/"+board.ID+"/cards/"+filter, trello.Arguments{"fields": "id"}, &cards); err != nil {
+			return nil, err
+		}
+		for _, card := range cards {
+			ids[card.ID] = true
+		}
+	}
+
+	return ids, nil
+}
+
+/*
+retire applies --on-deleted to a card or list and records it, s.mu is held
+*/
+func (s *BoardState) retire(item DeletedItem, mode string, boardID string, fields ...any) {
+
+	item, err := retire(item, s.boardDir, mode, fields...)
+	if os.IsNotExist(err) {
+		// Nothing left on disk (a list whose cards all moved on), just stop tracking it
+		return
+	}
+	if err != nil {
+		logger("Error: Unable to handle deleted "+item.Type+" "+item.Name+": "+err.Error(), "err", true, false, config, append(fields, LogFieldOp, "deleted")...)
+		if item.Type == "card" {
+			s.Cards[item.ID] = s.prev[item.ID]
+		} else {
+			s.Lists[item.ID] = s.prevLists[item.ID]
+		}
+		return
+	}
+
+	s.Deleted = append(s.Deleted, item)
+	reportDeleted(boardID, item)
+}
+
+/*
+carryForward keeps the last entry of every card and list not written this run, for a run that stopped early
+*/
+func (s *BoardState) carryForward() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, prev := range s.prev {
+		if _, ok := s.Cards[id]; !ok {
+			s.Cards[id] = prev
+		}
+	}
+	for id, prev := range s.prevLists {
+		if _, ok := s.Lists[id]; !ok {
+			s.Lists[id] = prev
+		}
+	}
+}
+
+/*
+claimList records where a list's directory is this run
+*/
+func (s *BoardState) claimList(list *trello.List, listPath string) {
+	if s == nil {
+		return
+	}
+	rel, err := filepath.Rel(s.boardDir, listPath)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Lists[list.ID] = ListState{Name: list.Name, Path: filepath.ToSlash(rel)}
+}
+
+/*
+removeEmptyDirs removes dir and its parents while they are empty, stopping at stop.
+Index files trellgo writes into a list directory don't count as content.
+*/
+func removeEmptyDirs(dir string, stop string) {
+	stop = filepath.Clean(stop)
+	for dir = filepath.Clean(dir); dir != stop && strings.HasPrefix(dir, stop+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return
+		}
+		for _, e := range entries {
+			if e.Name() != CardOrderFile {
+				return
+			}
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return
+		}
+	}
+}
+
+/*
+cardIDSuffix is the short ID put after a card's name, so two cards with the same name never share a directory
+*/
+func cardIDSuffix(card *trello.Card) string {
+	short := card.ShortLink
+	if short == "" && len(card.ID) > 8 {
+		short = card.ID[len(card.ID)-8:]
+	}
+	return "[" + short + "]"
+}
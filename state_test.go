@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/adlio/trello"
+)
+
+/*
+newGoneTestBoard writes a board directory whose last run dumped cards c1, c2 and c3 into list l1,
+and a Trello stand-in that answers the closed cards request with closed (or fails when it's nil)
+*/
+func newGoneTestBoard(t *testing.T, closed []string) (*BoardState, *trello.Client, *atomic.Int32) {
+	t.Helper()
+
+	prevConfig := config
+	t.Cleanup(func() { config = prevConfig })
+	config.ARGS.SuperQuiet = true
+
+	boardDir := t.TempDir()
+	prev := BoardState{Version: 1, BoardID: "b1", Cards: map[string]CardState{}, Lists: map[string]ListState{"l1": {Name: "Doing", Path: "Doing"}}}
+	for _, id := range []string{"c1", "c2", "c3"} {
+		prev.Cards[id] = CardState{Name: "Card " + id, ListID: "l1", Path: "Doing/" + id}
+		if err := os.MkdirAll(filepath.Join(boardDir, "Doing", id), SecureDirMode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, _ := json.Marshal(&prev)
+	if err := os.WriteFile(filepath.Join(boardDir, BoardStateFile), data, SecureFileMode); err != nil {
+		t.Fatal(err)
+	}
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path != "/boards/b1/cards/closed" || closed == nil {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		cards := []map[string]string{}
+		for _, id := range closed {
+			cards = append(cards, map[string]string{"id": id})
+		}
+		_ = json.NewEncoder(w).Encode(cards)
+	}))
+	t.Cleanup(srv.Close)
+
+	client := trello.NewClient("key", "token")
+	client.BaseURL = srv.URL
+
+	return loadBoardState(boardDir, "b1"), client, &requests
+}
+
+func TestRemoveGoneOneRequest(t *testing.T) {
+	state, client, requests := newGoneTestBoard(t, []string{"c2"})
+	board := &trello.Board{ID: "b1", Name: "Roadmap"}
+	lists := map[string]*trello.List{"l1": {ID: "l1", Name: "Doing"}}
+
+	// c1 was fetched, c2 is archived and c3 is gone
+	state.removeGone(board, []*trello.Card{{ID: "c1"}}, lists, client, OnDeletedMove)
+
+	if n := requests.Load(); n != 1 {
+		t.Errorf("made %d requests, want 1 for every missing card", n)
+	}
+	if _, ok := state.Cards["c1"]; !ok {
+		t.Error("fetched card c1 was dropped")
+	}
+	if _, ok := state.Cards["c2"]; !ok {
+		t.Error("archived card c2 was dropped")
+	}
+	if _, ok := state.Cards["c3"]; ok {
+		t.Error("gone card c3 is still tracked")
+	}
+	if len(state.Deleted) != 1 || state.Deleted[0].ID != "c3" || state.Deleted[0].Action != "moved" {
+		t.Fatalf("deleted = %+v, want c3 moved", state.Deleted)
+	}
+	if _, err := os.Stat(filepath.Join(state.boardDir, DeletedDir, "Doing", "c3", TombstoneFile)); err != nil {
+		t.Errorf("c3 was not moved under %s with a tombstone: %v", DeletedDir, err)
+	}
+}
+
+func TestRemoveGoneKeepsCardsWhenUnchecked(t *testing.T) {
+	state, client, _ := newGoneTestBoard(t, nil)
+	board := &trello.Board{ID: "b1", Name: "Roadmap"}
+	lists := map[string]*trello.List{"l1": {ID: "l1", Name: "Doing"}}
+
+	state.removeGone(board, nil, lists, client, OnDeletedMove)
+
+	if len(state.Cards) != 3 || len(state.Deleted) != 0 {
+		t.Errorf("failed check kept %d card(s) and deleted %+v, want all 3 kept", len(state.Cards), state.Deleted)
+	}
+	if _, err := os.Stat(filepath.Join(state.boardDir, "Doing", "c3")); err != nil {
+		t.Errorf("card directory was touched: %v", err)
+	}
+}
+
+func TestRemoveGoneAfterPrune(t *testing.T) {
+	state, client, _ := newGoneTestBoard(t, []string{"c2"})
+	board := &trello.Board{ID: "b1", Name: "Roadmap"}
+	lists := map[string]*trello.List{"l1": {ID: "l1", Name: "Doing"}}
+
+	// The last run was archived and prune.remove_dump emptied the board directory
+	if err := removeBoardDump(state.boardDir); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(state.boardDir)
+	if len(entries) != 1 || entries[0].Name() != BoardStateFile {
+		t.Fatalf("board directory has %v after pruning, want only %s", entries, BoardStateFile)
+	}
+	state = loadBoardState(state.boardDir, "b1")
+
+	state.removeGone(board, []*trello.Card{{ID: "c1"}}, lists, client, OnDeletedMove)
+
+	if len(state.Deleted) != 1 || state.Deleted[0].ID != "c3" {
+		t.Fatalf("deleted = %+v, want c3 noticed from the kept state", state.Deleted)
+	}
+	if _, err := os.Stat(filepath.Join(state.boardDir, DeletedDir, "Doing", "c3", TombstoneFile)); err != nil {
+		t.Errorf("pruned card c3 has no tombstone: %v", err)
+	}
+}
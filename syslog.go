@@ -0,0 +1,81 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"log/syslog"
+	"strings"
+)
+
+/*
+syslogHandler
+
+	slog handler that sends entries to the local syslog socket, tagged trellgo,
+	with the syslog priority taken from the entry level
+*/
+type syslogHandler struct {
+	w      *syslog.Writer
+	level  slog.Leveler
+	attrs  []slog.Attr
+	prefix string
+}
+
+/*
+newSyslogHandler connects to the local syslog daemon
+*/
+func newSyslogHandler(level slog.Leveler) (slog.Handler, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_LOCAL1, "trellgo")
+	if err != nil {
+		return nil, err
+	}
+	return &syslogHandler{w: w, level: level}, nil
+}
+
+func (h *syslogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *syslogHandler) Handle(_ context.Context, r slog.Record) error {
+
+	var b strings.Builder
+	b.WriteString(r.Message)
+
+	// Same key=value layout as the logfmt file output
+	for _, a := range h.attrs {
+		fmt.Fprintf(&b, " %s=%q", a.Key, a.Value.String())
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		fmt.Fprintf(&b, " %s%s=%q", h.prefix, a.Key, a.Value.String())
+		return true
+	})
+
+	switch {
+	case r.Level >= slog.LevelError:
+		return h.w.Err(b.String())
+	case r.Level >= slog.LevelWarn:
+		return h.w.Warning(b.String())
+	case r.Level >= slog.LevelInfo:
+		return h.w.Info(b.String())
+	default:
+		return h.w.Debug(b.String())
+	}
+}
+
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	n := *h
+	n.attrs = append([]slog.Attr{}, h.attrs...)
+	for _, a := range attrs {
+		a.Key = h.prefix + a.Key
+		n.attrs = append(n.attrs, a)
+	}
+	return &n
+}
+
+func (h *syslogHandler) WithGroup(name string) slog.Handler {
+	n := *h
+	n.prefix = h.prefix + name + "."
+	return &n
+}
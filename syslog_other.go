@@ -0,0 +1,15 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"errors"
+	"log/slog"
+)
+
+/*
+newSyslogHandler - there is no local syslog socket on this platform
+*/
+func newSyslogHandler(level slog.Leveler) (slog.Handler, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -27,10 +29,62 @@ type CardProcessingJob struct {
 	config    Config
 	client    *trello.Client
 	listCache map[string]*trello.List
+	state     *BoardState // where cards were written last run, nil to not track
+	exports   exportSinks
 	index     int
 	total     int
 }
 
+/*
+exportSinks
+
+	The exports each card goes into besides the Markdown tree.  Every sink is nil when its
+	format is off, a new export format adds its sink here.
+*/
+type exportSinks struct {
+	sqlite  *sqliteExport    // nil when the sqlite format is off
+	csv     *csvExport       // nil when the csv format is off
+	ics     *icsExport       // nil when the ics format is off
+	migrate *migrationExport // nil when none of the jira, github and gitlab formats are on
+	wekan   *wekanExport     // nil when the wekan format is off
+}
+
+/*
+newExportSinks opens the board's exports, the SQLite one is given the board, lists, labels and members up front
+*/
+func newExportSinks(config Config, boardDir string, board *trello.Board, listCache map[string]*trello.List, labels []*trello.Label, members []BoardMember) exportSinks {
+	e := exportSinks{
+		sqlite:  openSQLiteExport(config),
+		csv:     newCSVExport(config, boardDir, listCache),
+		ics:     newICSExport(config, boardDir, listCache),
+		migrate: newMigrationExport(config, boardDir, listCache),
+		wekan:   newWekanExport(config, boardDir, board, labels, members),
+	}
+	e.sqlite.writeBoard(board, listCache, labels, members)
+	return e
+}
+
+/*
+addCard adds a card to every export.  raw is its JSON from getComprehensiveCardData, nil for link cards or when that fetch failed.
+*/
+func (e exportSinks) addCard(card *trello.Card, list *trello.List, raw []byte, isLink bool) {
+	e.sqlite.writeCard(card, raw != nil, isLink)
+	e.csv.addCard(card)
+	e.ics.addCard(card, raw)
+	e.migrate.addCard(card)
+	e.wekan.addCard(card, list)
+}
+
+/*
+write writes the exports that are built up over the whole board, once every card has been added
+*/
+func (e exportSinks) write(board *trello.Board, listCache map[string]*trello.List, config Config) {
+	e.csv.write(board, config)
+	e.ics.write(board, config)
+	e.migrate.write(board, config)
+	e.wekan.write(listCache, config)
+}
+
 // Buffer pool for reusing byte buffers across concurrent workers
 var bufferPool = sync.Pool{
 	New: func() interface{} {
@@ -59,7 +113,16 @@ processCardWorker processes individual cards concurrently
 */
 func processCardWorker(jobs <-chan CardProcessingJob, results chan<- error, processed *int64) {
 	for job := range jobs {
+		// Shutting down, finish the card in hand but don't start any more
+		if runCtx.Err() != nil {
+			reportCard(job.board.ID, errShutdown)
+			results <- errShutdown
+			continue
+		}
+
 		err := processSingleCard(job)
+		reportCard(job.board.ID, err)
+		metricCard(job.board.ID, err)
 		if err != nil {
 			results <- err
 		} else {
@@ -106,83 +169,96 @@ func processSingleCard(job CardProcessingJob) error {
 		var err error
 		list, err = client.GetList(card.IDList, trello.Defaults())
 		if err != nil {
-			logger("CRITICAL - Error: Unable to get list data for list ID "+card.IDList+" Error: "+err.Error(), "err", true, false, config)
+			logger("CRITICAL - Error: Unable to get list data for list ID "+card.IDList+" Error: "+err.Error(), "err", true, false, config, cardLogFields(card, "get_list")...)
 			errorWarnOnCompletion = true
 			return err
 		}
 	}
 
 	// create list directory
-	cleanListPath = SanitizePathName(list.Name)
+	cleanListPath = listDirName(list, config)
 	dirCreate(filepath.Join(config.ARGS.StoragePath, boardPath, cleanListPath))
+	job.state.claimList(list, filepath.Join(config.ARGS.StoragePath, boardPath, cleanListPath))
 
 	// We need to handle when card is a LINK and not a regular card
 	// Trello Go client does not support the new field `cardRole` so we have to do our own thing here for now.  6/16/2025
 	isCardLink, _ := isLinkCard(client, card.ID)
 
 	if isCardLink {
-		return processLinkCard(card, config, boardPath, cleanListPath)
+		job.exports.addCard(card, list, nil, true)
+		return processLinkCard(card, config, boardPath, cleanListPath, job.state)
 	}
 
 	// Get comprehensive card data in one API call instead of multiple calls
-	comprehensiveCard, err := getComprehensiveCardData(card.ID, client)
+	comprehensiveCard, raw, err := getComprehensiveCardData(card.ID, client)
 	if err != nil {
-		logger("Warning: Failed to get comprehensive card data, falling back to individual calls: "+err.Error(), "warn", true, true, config)
+		logger("Warning: Failed to get comprehensive card data, falling back to individual calls: "+err.Error(), "warn", true, true, config, cardLogFields(card, "get_card", LogFieldList, list.Name)...)
 		comprehensiveCard = card // Fallback to original card
 	}
+	job.exports.addCard(comprehensiveCard, list, raw, false)
 
 	// Process regular card with comprehensive data
-	return processRegularCard(comprehensiveCard, config, client, boardPath, cleanListPath, buff, &cardNumber, &dueFileName, &cleanCardPath, &cardPath)
+	return processRegularCard(comprehensiveCard, config, client, boardPath, cleanListPath, job.state, buff, &cardNumber, &dueFileName, &cleanCardPath, &cardPath)
 }
 
 /*
 processLinkCard handles processing of Trello link cards
 */
-func processLinkCard(card *trello.Card, config Config, boardPath, cleanListPath string) error {
+func processLinkCard(card *trello.Card, config Config, boardPath, cleanListPath string, state *BoardState) error {
 	// We should dump this into their own directory as they can be messy filenames
-	logger("This card is a link file only, processing as .MD instead of directory", "info", true, true, config)
-	thisCardLinkPath := filepath.Join(config.ARGS.StoragePath, boardPath, cleanListPath, "Link Cards Only")
+	logger("This card is a link file only, processing as .MD instead of directory", "info", true, true, config, cardLogFields(card, "link_card")...)
+	thisCardPath := linkCardFilePath(card, config, boardPath, cleanListPath)
+	thisCardLinkPath := filepath.Dir(thisCardPath)
+	state.claim(card, thisCardPath)
 	dirCreate(thisCardLinkPath)
-	logger("Created Custom Directory for Link Cards: "+thisCardLinkPath, "info", true, true, config)
-	// Cleanup messy filename
-	cleanName := SanitizePathName(card.Name)
-	cleanName = strings.ReplaceAll(cleanName, "https---", "")
-	cleanName = strings.ReplaceAll(cleanName, "http---", "")
-	cleanName = "CARD - " + cleanName + ".md"
-	logger("New Clean Custom Card File Name: "+cleanName, "info", true, true, config)
-	thisCardPath := filepath.Join(thisCardLinkPath, cleanName)
+	logger("Created Custom Directory for Link Cards: "+thisCardLinkPath, "info", true, true, config, cardLogFields(card, "link_card")...)
+	logger("New Clean Custom Card File Name: "+filepath.Base(thisCardPath), "info", true, true, config, cardLogFields(card, "link_card")...)
 	// Dump URL into card md file
 	err := os.WriteFile(thisCardPath, []byte(card.Name), SecureFileMode)
 	if err != nil {
-		logger("CRITICAL - Unable to write buffer to file for "+thisCardPath+" Error: "+err.Error(), "err", true, true, config)
+		logger("CRITICAL - Unable to write buffer to file for "+thisCardPath+" Error: "+err.Error(), "err", true, true, config, cardLogFields(card, "link_card")...)
 		errorWarnOnCompletion = true
 		return err
 	}
 	return nil
 }
 
+/*
+cardLogFields
+
+	Structured log fields for an operation on a card, extra key/value pairs are appended
+*/
+func cardLogFields(card *trello.Card, op string, extra ...any) []any {
+	return append([]any{LogFieldBoard, card.IDBoard, LogFieldCard, card.ID, LogFieldOp, op}, extra...)
+}
+
+/*
+linkCardFilePath
+
+	Full path of the markdown file a link card is written to
+*/
+func linkCardFilePath(card *trello.Card, config Config, boardPath, cleanListPath string) string {
+	// Cleanup messy filename
+	cleanName := SanitizePathName(card.Name)
+	cleanName = strings.ReplaceAll(cleanName, "https---", "")
+	cleanName = strings.ReplaceAll(cleanName, "http---", "")
+	cleanName = "CARD - " + fitName("", cleanName, " "+cardIDSuffix(card)) + ".md"
+
+	return filepath.Join(config.ARGS.StoragePath, boardPath, cleanListPath, "Link Cards Only", cleanName)
+}
+
 /*
 processRegularCard handles processing of regular Trello cards with all their data
 */
-func processRegularCard(card *trello.Card, config Config, client *trello.Client, boardPath, cleanListPath string,
+func processRegularCard(card *trello.Card, config Config, client *trello.Client, boardPath, cleanListPath string, state *BoardState,
 	buff *bytes.Buffer, cardNumber *int, dueFileName *string, cleanCardPath *string, cardPath *string) error {
 
 	// Create directory for card name
-	*cleanCardPath = SanitizePathName(card.Name)
-	// If card is archived, append ARCHIVED to the card name or move to ARCHIVED directory
-	if card.Closed {
-		if !config.ARGS.SeparateArchived {
-			// If -split flag is not set, append ARCHIVED to the card name
-			*cardPath = filepath.Join(config.ARGS.StoragePath, boardPath, cleanListPath, *cleanCardPath+" (ARCHIVED)")
-			// If -split flag is set, move to ARCHIVED directory
-		} else {
-			*cardPath = filepath.Join(config.ARGS.StoragePath, boardPath, "ARCHIVED", cleanListPath, *cleanCardPath)
-		}
-		// card is not archived
-	} else {
-		*cardPath = filepath.Join(config.ARGS.StoragePath, boardPath, cleanListPath, *cleanCardPath)
-	}
+	*cleanCardPath = cardDirName(card, config)
+	*cardPath = cardDirPath(card, config, boardPath, cleanListPath)
 
+	// Bring along the directory from last run if the card was renamed or moved
+	state.claim(card, *cardPath)
 	dirCreate(*cardPath)
 
 	// Process all card data
@@ -198,7 +274,7 @@ func processRegularCard(card *trello.Card, config Config, client *trello.Client,
 	if err := processCardComments(card, *cardPath, config, buff); err != nil {
 		return err
 	}
-	if err := processCardUsers(card, *cardPath, config, buff); err != nil {
+	if err := processCardUsers(card, boardPath, *cardPath, config, buff); err != nil {
 		return err
 	}
 	if err := processCardLabels(card, client, *cardPath, config, buff); err != nil {
@@ -217,14 +293,37 @@ func processRegularCard(card *trello.Card, config Config, client *trello.Client,
 	return nil
 }
 
+/*
+cardDirPath
+
+	Full path of the directory a regular card is written to
+*/
+func cardDirPath(card *trello.Card, config Config, boardPath, cleanListPath string) string {
+
+	cleanCardPath := cardDirName(card, config)
+
+	// If card is archived, append ARCHIVED to the card name or move to ARCHIVED directory
+	if card.Closed {
+		if !config.ARGS.SeparateArchived {
+			// If -split flag is not set, append ARCHIVED to the card name
+			return filepath.Join(config.ARGS.StoragePath, boardPath, cleanListPath, cleanCardPath+" (ARCHIVED)")
+		}
+		// If -split flag is set, move to ARCHIVED directory
+		return filepath.Join(config.ARGS.StoragePath, boardPath, "ARCHIVED", cleanListPath, cleanCardPath)
+	}
+
+	// card is not archived
+	return filepath.Join(config.ARGS.StoragePath, boardPath, cleanListPath, cleanCardPath)
+}
+
 /*
 processCardDescription creates markdown file for card description
 */
 func processCardDescription(card *trello.Card, cardPath string, config Config) error {
-	logger("Dumping card: "+card.Name, "info", true, true, config)
+	logger("Dumping card: "+card.Name, "info", true, true, config, cardLogFields(card, "description")...)
 	err := os.WriteFile(filepath.Join(cardPath, "CardDescription.md"), []byte(card.Desc), SecureFileMode)
 	if err != nil {
-		logger("CRITICAL - Unable to write buffer to file for "+cardPath+" Error: "+err.Error(), "err", true, true, config)
+		logger("CRITICAL - Unable to write buffer to file for "+cardPath+" Error: "+err.Error(), "err", true, true, config, cardLogFields(card, "description")...)
 		errorWarnOnCompletion = true
 		return err
 	}
@@ -232,7 +331,8 @@ func processCardDescription(card *trello.Card, cardPath string, config Config) e
 }
 
 /*
-processCardAttachments downloads file attachments and saves URL attachments
+processCardAttachments downloads file attachments and saves URL attachments, with
+attachments.md/attachments.json describing every one of them.
 Uses comprehensive card data instead of additional API call
 */
 func processCardAttachments(card *trello.Card, cardPath string, config Config, buff *bytes.Buffer) error {
@@ -243,7 +343,7 @@ func processCardAttachments(card *trello.Card, cardPath string, config Config, b
 		var err error
 		attachments, err = card.GetAttachments(trello.Defaults())
 		if err != nil {
-			logger("Error: Unable to get attachment data for card ID "+card.ID+": "+err.Error(), "err", true, true, config)
+			logger("Error: Unable to get attachment data for card ID "+card.ID+": "+err.Error(), "err", true, true, config, cardLogFields(card, "attachments")...)
 			return nil // Don't fail the entire card for attachment errors
 		}
 	}
@@ -251,59 +351,132 @@ func processCardAttachments(card *trello.Card, cardPath string, config Config, b
 	// Clear the old Bytes Buffer
 	buff.Reset()
 
+	attachmentsDir := filepath.Join(cardPath, "attachments")
+
 	if len(attachments) > 0 {
-		dirCreate(filepath.Join(cardPath, "attachments"))
-		logger(card.Name+" has "+strconv.Itoa(len(attachments))+" attachments", "info", true, true, config)
+		dirCreate(attachmentsDir)
+		logger(card.Name+" has "+strconv.Itoa(len(attachments))+" attachments", "info", true, true, config, cardLogFields(card, "attachments")...)
 
+		// Card members cover uploaders the board member list doesn't (ie left the board)
+		for _, m := range card.Members {
+			rememberMember(m.ID, m.Username)
+		}
+
+		metas := make([]AttachmentMeta, 0, len(attachments))
+		found := make([]*trello.Attachment, 0, len(attachments)) // lines up with metas
 		for _, a := range attachments {
 			if a == nil {
 				continue
 			}
-
-			if a.IsUpload {
-				// Download
-				filePath := filepath.Join(cardPath, "attachments")
-				if card.Cover != nil && card.Cover.IDAttachment == a.ID {
-					// If this is the cover attachment, append "Cover" to the filename
-					filePath = filepath.Join(filePath, a.Name+" (Card Cover)")
-					logger("This is the cover attachment for card "+card.Name+" downloading to "+filePath, "info", true, true, config)
-				} else {
-					filePath = filepath.Join(filePath, a.Name)
-				}
-				// Format https://api.trello.com/1/cards/{idCard}/attachments/{idAttachment}/download/{attachmentFileName}
-				authURL := fmt.Sprintf("https://api.trello.com/1/cards/%s/attachments/%s/download/%s", card.ID, a.ID, a.Name)
-				err := downloadFileAuthHeader(authURL, filePath, config.ENV.TRELLOAPIKEY, config.ENV.TRELLOAPITOK)
-				if err != nil {
-					logger("Error downloading attachment from "+sanitizeURLForLogging(authURL)+" to "+filePath+": "+err.Error(), "err", true, false, config)
-				}
-			} else {
+			metas = append(metas, newAttachmentMeta(card, a))
+			found = append(found, a)
+			if !a.IsUpload {
 				// build a bytes.buffer for URL attachments
 				buff.WriteString(a.URL)
 				buff.WriteString("\n")
 			}
 		}
 
+		// Downloads run side by side, the download pool bounds how many at once across all cards.
+		// Attachments sharing a name would write the same file, those go one after another.
+		byName := make(map[string][]int)
+		var names []string
+		for i, m := range metas {
+			if !m.IsUpload && !config.ARGS.Previews {
+				continue
+			}
+			if _, ok := byName[m.Name]; !ok {
+				names = append(names, m.Name)
+			}
+			byName[m.Name] = append(byName[m.Name], i)
+		}
+
+		var wg sync.WaitGroup
+		for _, name := range names {
+			wg.Add(1)
+			go func(indexes []int) {
+				defer wg.Done()
+				for _, i := range indexes {
+					a := found[i]
+					if a.IsUpload {
+						downloadUploadAttachment(card, a, &metas[i], attachmentsDir, config)
+					}
+					if config.ARGS.Previews {
+						metas[i].Preview = downloadLargestPreview(card, a, attachmentsDir, config)
+					}
+				}
+			}(byName[name])
+		}
+		wg.Wait()
+
 		// Write buffer to disc for URL Attachments
-		err := os.WriteFile(filepath.Join(cardPath, "attachments", "URL-Attachments.md"), buff.Bytes(), SecureFileMode)
+		err := os.WriteFile(filepath.Join(attachmentsDir, "URL-Attachments.md"), buff.Bytes(), SecureFileMode)
 		if err != nil {
-			logger("CRITICAL - Unable to write URL attachments file for "+cardPath+" Error: "+err.Error(), "err", true, true, config)
+			logger("CRITICAL - Unable to write URL attachments file for "+cardPath+" Error: "+err.Error(), "err", true, true, config, cardLogFields(card, "attachments")...)
+			errorWarnOnCompletion = true
+			return err
+		}
+
+		if err := writeAttachmentMeta(metas, attachmentsDir); err != nil {
+			logger("CRITICAL - Unable to write attachment metadata for "+cardPath+" Error: "+err.Error(), "err", true, true, config, cardLogFields(card, "attachments")...)
 			errorWarnOnCompletion = true
 			return err
 		}
 	} else {
-		logger("No attachments found for card "+card.Name, "warn", true, true, config)
+		logger("No attachments found for card "+card.Name, "warn", true, true, config, cardLogFields(card, "attachments")...)
 		// Create an empty attachments directory if no attachments found
-		dirCreate(filepath.Join(cardPath, "attachments"))
+		dirCreate(attachmentsDir)
 	}
 	return nil
 }
 
+/*
+downloadUploadAttachment downloads an uploaded file attachment, unless the type or size limits skip it
+*/
+func downloadUploadAttachment(card *trello.Card, a *trello.Attachment, meta *AttachmentMeta, attachmentsDir string, config Config) {
+
+	meta.Skipped = attachmentTypeSkip(a, config.ARGS)
+	var hold *attachmentReservation
+	if meta.Skipped == "" {
+		hold, meta.Skipped = reserveAttachment(card.IDBoard, int64(a.Bytes), config.ARGS)
+	}
+	if meta.Skipped != "" {
+		skipAttachment(card, a, meta.Skipped, config)
+		return
+	}
+
+	fileName := a.Name
+	if meta.Cover {
+		// If this is the cover attachment, append "Cover" to the filename
+		fileName = a.Name + " (Card Cover)"
+	}
+	filePath := filepath.Join(attachmentsDir, fileName)
+	if meta.Cover {
+		logger("This is the cover attachment for card "+card.Name+" downloading to "+filePath, "info", true, true, config, cardLogFields(card, "attachments")...)
+	}
+	// Format https://api.trello.com/1/cards/{idCard}/attachments/{idAttachment}/download/{attachmentFileName}
+	authURL := fmt.Sprintf("https://api.trello.com/1/cards/%s/attachments/%s/download/%s", card.ID, a.ID, a.Name)
+	n, err := download(downloadRequest{URL: authURL, Path: filePath, Auth: true, Size: int64(a.Bytes), MaxBytes: hold.limit})
+	hold.done(n)
+	switch {
+	case err == nil:
+		meta.File = fileName
+		reportAttachment(card.IDBoard, n)
+		metricAttachment(card.IDBoard, n)
+	case errors.Is(err, errTooLarge):
+		meta.Skipped = hold.limitReason
+		skipAttachment(card, a, meta.Skipped, config)
+	default:
+		logger("Error downloading attachment from "+sanitizeURLForLogging(authURL)+" to "+filePath+": "+err.Error(), "err", true, false, config, cardLogFields(card, "attachments")...)
+	}
+}
+
 /*
 processCardChecklists creates markdown files for each checklist
 */
 func processCardChecklists(card *trello.Card, client *trello.Client, cardPath string, config Config, buff *bytes.Buffer, cardNumber *int) error {
 	*cardNumber = 0
-	logger("Found "+strconv.Itoa(len(card.IDCheckLists))+" checklists for card "+card.Name, "info", true, true, config)
+	logger("Found "+strconv.Itoa(len(card.IDCheckLists))+" checklists for card "+card.Name, "info", true, true, config, cardLogFields(card, "checklists")...)
 
 	dirCreate(filepath.Join(cardPath, "checklists"))
 
@@ -318,12 +491,12 @@ func processCardChecklists(card *trello.Card, client *trello.Client, cardPath st
 		args := trello.Arguments{"checkItems": "all"}
 		checklist, err := client.GetChecklist(checkList, args)
 		if err != nil {
-			logger("Error: Unable to get checklist data for checklist ID "+checkList, "err", true, false, config)
+			logger("Error: Unable to get checklist data for checklist ID "+checkList, "err", true, false, config, cardLogFields(card, "checklists")...)
 			continue
 		}
 
 		checklistName := SanitizePathName(checklist.Name)
-		logger("Processing checklist: "+checklistName, "info", true, true, config)
+		logger("Processing checklist: "+checklistName, "info", true, true, config, cardLogFields(card, "checklists")...)
 
 		for _, item := range checklist.CheckItems {
 			// If item is checked, append [x] to the name, otherwise append [ ]
@@ -341,12 +514,12 @@ func processCardChecklists(card *trello.Card, client *trello.Client, cardPath st
 			fullpath = filepath.Join(cardPath, "checklists", checklistName+" "+strconv.Itoa(*cardNumber)+".md")
 		}
 
-		logger("Creating checklist markdown file: "+fullpath, "info", true, true, config)
+		logger("Creating checklist markdown file: "+fullpath, "info", true, true, config, cardLogFields(card, "checklists")...)
 
 		// Create markdown file for card checklists
 		err = os.WriteFile(fullpath, buff.Bytes(), SecureFileMode)
 		if err != nil {
-			logger("CRITICAL - Unable to write buffer to file for "+fullpath+" Error: "+err.Error(), "err", true, true, config)
+			logger("CRITICAL - Unable to write buffer to file for "+fullpath+" Error: "+err.Error(), "err", true, true, config, cardLogFields(card, "checklists")...)
 			errorWarnOnCompletion = true
 			return err
 		}
@@ -359,7 +532,7 @@ processCardComments creates markdown file for card comments
 Uses comprehensive card data instead of additional API call
 */
 func processCardComments(card *trello.Card, cardPath string, config Config, buff *bytes.Buffer) error {
-	logger("Grabbing comments for card: "+card.Name, "info", true, true, config)
+	logger("Grabbing comments for card: "+card.Name, "info", true, true, config, cardLogFields(card, "comments")...)
 
 	// Filter comments from comprehensive card actions instead of API call
 	var comments []*trello.Action
@@ -374,7 +547,7 @@ func processCardComments(card *trello.Card, cardPath string, config Config, buff
 		var err error
 		comments, err = card.GetActions(trello.Arguments{"filter": "commentCard"})
 		if err != nil {
-			logger("Error: Unable to get comments for card ID "+card.ID, "err", true, false, config)
+			logger("Error: Unable to get comments for card ID "+card.ID, "err", true, false, config, cardLogFields(card, "comments")...)
 			return nil // Don't fail the entire card for comment errors
 		}
 	}
@@ -383,7 +556,7 @@ func processCardComments(card *trello.Card, cardPath string, config Config, buff
 	if len(comments) > 0 {
 		// Clear the old Bytes Buffer
 		buff.Reset()
-		logger("Found "+strconv.Itoa(len(comments))+" comments for card "+card.Name, "info", true, true, config)
+		logger("Found "+strconv.Itoa(len(comments))+" comments for card "+card.Name, "info", true, true, config, cardLogFields(card, "comments")...)
 		for _, comment := range comments {
 			if comment.MemberCreator == nil || comment.MemberCreator.FullName == "" {
 				comment.MemberCreator = &trello.Member{FullName: "Unknown Member"}
@@ -394,13 +567,13 @@ func processCardComments(card *trello.Card, cardPath string, config Config, buff
 		// Create markdown file for card comments
 		err := os.WriteFile(commentFileName, buff.Bytes(), SecureFileMode)
 		if err != nil {
-			logger("CRITICAL - Unable to write buffer to file for "+commentFileName+" Error: "+err.Error(), "err", true, true, config)
+			logger("CRITICAL - Unable to write buffer to file for "+commentFileName+" Error: "+err.Error(), "err", true, true, config, cardLogFields(card, "comments")...)
 			errorWarnOnCompletion = true
 			return err
 		}
-		logger("Created comments markdown file: "+commentFileName, "info", true, true, config)
+		logger("Created comments markdown file: "+commentFileName, "info", true, true, config, cardLogFields(card, "comments")...)
 	} else {
-		logger("No comments found on card "+card.Name, "warn", true, true, config)
+		logger("No comments found on card "+card.Name, "warn", true, true, config, cardLogFields(card, "comments")...)
 		// Create an empty comments markdown file if no comments found
 		_ = os.WriteFile(commentFileName, nil, SecureFileMode)
 	}
@@ -409,10 +582,10 @@ func processCardComments(card *trello.Card, cardPath string, config Config, buff
 
 /*
 processCardUsers creates markdown file for card users/members
-Uses comprehensive card data instead of additional API call
+Uses comprehensive card data instead of additional API call, avatars link to the board's members/ directory
 */
-func processCardUsers(card *trello.Card, cardPath string, config Config, buff *bytes.Buffer) error {
-	logger("Grabbing users for card: "+card.Name, "info", true, true, config)
+func processCardUsers(card *trello.Card, boardPath string, cardPath string, config Config, buff *bytes.Buffer) error {
+	logger("Grabbing users for card: "+card.Name, "info", true, true, config, cardLogFields(card, "members")...)
 
 	// Use members from comprehensive card data instead of API call
 	members := card.Members
@@ -421,7 +594,7 @@ func processCardUsers(card *trello.Card, cardPath string, config Config, buff *b
 		var err error
 		members, err = card.GetMembers()
 		if err != nil {
-			logger("Error: Unable to get members for card ID "+card.ID, "err", true, false, config)
+			logger("Error: Unable to get members for card ID "+card.ID, "err", true, false, config, cardLogFields(card, "members")...)
 			return nil // Don't fail the entire card for member errors
 		}
 	}
@@ -430,24 +603,30 @@ func processCardUsers(card *trello.Card, cardPath string, config Config, buff *b
 	if len(members) > 0 {
 		// Clear the old Bytes Buffer
 		buff.Reset()
-		logger("Found "+strconv.Itoa(len(members))+" members for card "+card.Name, "info", true, true, config)
+		logger("Found "+strconv.Itoa(len(members))+" members for card "+card.Name, "info", true, true, config, cardLogFields(card, "members")...)
 		for _, member := range members {
 			if member == nil || member.FullName == "" {
 				member = &trello.Member{FullName: "Unknown Member", ID: "Unknown ID"}
 			}
-			// Format member with name and ID
+			// Format member with avatar, username, name and ID
+			if avatar := avatarMarkdown(member.Username, member.AvatarHash, filepath.Join(config.ARGS.StoragePath, boardPath), cardPath); avatar != "" {
+				buff.WriteString(avatar + " ")
+			}
+			if member.Username != "" {
+				buff.WriteString("@" + member.Username + " ")
+			}
 			buff.WriteString(fmt.Sprintf("**%s** (%s)\n", member.FullName, member.ID))
 		}
 		// Create markdown file for card users
 		err := os.WriteFile(userFileName, buff.Bytes(), SecureFileMode)
 		if err != nil {
-			logger("CRITICAL - Unable to write buffer to file for "+userFileName+" Error: "+err.Error(), "err", true, true, config)
+			logger("CRITICAL - Unable to write buffer to file for "+userFileName+" Error: "+err.Error(), "err", true, true, config, cardLogFields(card, "members")...)
 			errorWarnOnCompletion = true
 			return err
 		}
-		logger("Created users markdown file: "+userFileName, "info", true, true, config)
+		logger("Created users markdown file: "+userFileName, "info", true, true, config, cardLogFields(card, "members")...)
 	} else {
-		logger("No users found on card "+card.Name, "warn", true, true, config)
+		logger("No users found on card "+card.Name, "warn", true, true, config, cardLogFields(card, "members")...)
 		// Create an empty users markdown file if no users found
 		_ = os.WriteFile(userFileName, nil, SecureFileMode)
 	}
@@ -459,7 +638,7 @@ processCardLabels creates markdown file for card labels
 Uses comprehensive card data instead of additional API call
 */
 func processCardLabels(card *trello.Card, client *trello.Client, cardPath string, config Config, buff *bytes.Buffer) error {
-	logger("Grabbing labels for card: "+card.Name, "info", true, true, config)
+	logger("Grabbing labels for card: "+card.Name, "info", true, true, config, cardLogFields(card, "labels")...)
 
 	// PERFORMANCE: Use labels from comprehensive card data instead of additional API call
 	labels := card.Labels
@@ -467,7 +646,7 @@ func processCardLabels(card *trello.Card, client *trello.Client, cardPath string
 		// Fallback to API call if not available in comprehensive data
 		cardWithLabels, err := client.GetCard(card.ID, trello.Arguments{"labels": "all"})
 		if err != nil {
-			logger("Error: Unable to get labels for card ID "+card.ID, "err", true, false, config)
+			logger("Error: Unable to get labels for card ID "+card.ID, "err", true, false, config, cardLogFields(card, "labels")...)
 			return nil // Don't fail the entire card for label errors
 		}
 		labels = cardWithLabels.Labels
@@ -477,7 +656,7 @@ func processCardLabels(card *trello.Card, client *trello.Client, cardPath string
 	if len(labels) > 0 {
 		// Clear the old Bytes Buffer
 		buff.Reset()
-		logger("Found "+strconv.Itoa(len(labels))+" labels for card "+card.Name, "info", true, true, config)
+		logger("Found "+strconv.Itoa(len(labels))+" labels for card "+card.Name, "info", true, true, config, cardLogFields(card, "labels")...)
 		for _, label := range labels {
 			if label == nil {
 				continue
@@ -488,13 +667,13 @@ func processCardLabels(card *trello.Card, client *trello.Client, cardPath string
 		// Create markdown file for card labels
 		err := os.WriteFile(labelFileName, buff.Bytes(), SecureFileMode)
 		if err != nil {
-			logger("CRITICAL - Unable to write buffer to file for "+labelFileName+" Error: "+err.Error(), "err", true, true, config)
+			logger("CRITICAL - Unable to write buffer to file for "+labelFileName+" Error: "+err.Error(), "err", true, true, config, cardLogFields(card, "labels")...)
 			errorWarnOnCompletion = true
 			return err
 		}
-		logger("Created labels markdown file: "+labelFileName, "info", true, true, config)
+		logger("Created labels markdown file: "+labelFileName, "info", true, true, config, cardLogFields(card, "labels")...)
 	} else {
-		logger("No labels found on card "+card.Name, "warn", true, true, config)
+		logger("No labels found on card "+card.Name, "warn", true, true, config, cardLogFields(card, "labels")...)
 		// Create an empty labels markdown file if no labels found
 		_ = os.WriteFile(labelFileName, nil, SecureFileMode)
 	}
@@ -506,7 +685,7 @@ processCardHistory creates markdown file for card history/actions
 Uses comprehensive card data instead of additional API call
 */
 func processCardHistory(card *trello.Card, cardPath string, config Config, buff *bytes.Buffer) error {
-	logger("Grabbing history for card: "+card.Name, "info", true, true, config)
+	logger("Grabbing history for card: "+card.Name, "info", true, true, config, cardLogFields(card, "history")...)
 
 	// Use actions from comprehensive card data instead of API call
 	history := card.Actions
@@ -515,7 +694,7 @@ func processCardHistory(card *trello.Card, cardPath string, config Config, buff
 		var err error
 		history, err = card.GetActions(trello.Arguments{"filter": "all"})
 		if err != nil {
-			logger("Error: Unable to get history for card ID "+card.ID, "err", true, true, config)
+			logger("Error: Unable to get history for card ID "+card.ID, "err", true, true, config, cardLogFields(card, "history")...)
 			return nil // Don't fail the entire card for history errors
 		}
 	}
@@ -524,7 +703,7 @@ func processCardHistory(card *trello.Card, cardPath string, config Config, buff
 	if len(history) > 0 {
 		// Clear the old Bytes Buffer
 		buff.Reset()
-		logger("Found "+strconv.Itoa(len(history))+" history actions for card "+card.Name, "info", true, true, config)
+		logger("Found "+strconv.Itoa(len(history))+" history actions for card "+card.Name, "info", true, true, config, cardLogFields(card, "history")...)
 		for _, action := range history {
 			if action == nil {
 				continue
@@ -538,13 +717,13 @@ func processCardHistory(card *trello.Card, cardPath string, config Config, buff
 		// Create markdown file for card history
 		err := os.WriteFile(historyFileName, buff.Bytes(), SecureFileMode)
 		if err != nil {
-			logger("CRITICAL - Unable to write buffer to file for "+historyFileName+" Error: "+err.Error(), "err", true, true, config)
+			logger("CRITICAL - Unable to write buffer to file for "+historyFileName+" Error: "+err.Error(), "err", true, true, config, cardLogFields(card, "history")...)
 			errorWarnOnCompletion = true
 			return err
 		}
-		logger("Created history markdown file: "+historyFileName, "info", true, true, config)
+		logger("Created history markdown file: "+historyFileName, "info", true, true, config, cardLogFields(card, "history")...)
 	} else {
-		logger("No history found for card "+card.Name, "warn", true, true, config)
+		logger("No history found for card "+card.Name, "warn", true, true, config, cardLogFields(card, "history")...)
 		// Create an empty history markdown file if no history found
 		_ = os.WriteFile(historyFileName, nil, SecureFileMode)
 	}
@@ -564,13 +743,13 @@ func processCardDates(card *trello.Card, cardPath string, config Config, dueFile
 		}
 		err := os.WriteFile(*dueFileName, []byte(card.Due.Format("2006-01-02 15:04:05")), SecureFileMode)
 		if err != nil {
-			logger("CRITICAL - Unable to write buffer to file for "+*dueFileName+" Error: "+err.Error(), "err", true, true, config)
+			logger("CRITICAL - Unable to write buffer to file for "+*dueFileName+" Error: "+err.Error(), "err", true, true, config, cardLogFields(card, "dates")...)
 			errorWarnOnCompletion = true
 			return err
 		}
-		logger("Created due date markdown file: "+*dueFileName, "info", true, true, config)
+		logger("Created due date markdown file: "+*dueFileName, "info", true, true, config, cardLogFields(card, "dates")...)
 	} else {
-		logger("No due date found for card "+card.Name, "warn", true, true, config)
+		logger("No due date found for card "+card.Name, "warn", true, true, config, cardLogFields(card, "dates")...)
 		// Create an empty due date markdown file if no due date found
 		*dueFileName = filepath.Join(cardPath, "CardDueDate.md")
 		_ = os.WriteFile(*dueFileName, nil, SecureFileMode)
@@ -581,13 +760,13 @@ func processCardDates(card *trello.Card, cardPath string, config Config, dueFile
 		startFileName := filepath.Join(cardPath, "CardStartDate.md")
 		err := os.WriteFile(startFileName, []byte(card.Start.Format("2006-01-02 15:04:05")), SecureFileMode)
 		if err != nil {
-			logger("CRITICAL - Unable to write buffer to file for "+startFileName+" Error: "+err.Error(), "err", true, true, config)
+			logger("CRITICAL - Unable to write buffer to file for "+startFileName+" Error: "+err.Error(), "err", true, true, config, cardLogFields(card, "dates")...)
 			errorWarnOnCompletion = true
 			return err
 		}
-		logger("Created start date markdown file: "+startFileName, "info", true, true, config)
+		logger("Created start date markdown file: "+startFileName, "info", true, true, config, cardLogFields(card, "dates")...)
 	} else {
-		logger("No start date found for card "+card.Name, "warn", true, true, config)
+		logger("No start date found for card "+card.Name, "warn", true, true, config, cardLogFields(card, "dates")...)
 		// Create an empty start date markdown file if no start date found
 		startFileName := filepath.Join(cardPath, "CardStartDate.md")
 		_ = os.WriteFile(startFileName, nil, SecureFileMode)
@@ -600,15 +779,15 @@ processCardCover saves card cover information (color or image reference)
 */
 func processCardCover(card *trello.Card, cardPath string, config Config) error {
 	if card.Cover == nil {
-		logger("No cover set on card "+card.Name, "info", true, true, config)
+		logger("No cover set on card "+card.Name, "info", true, true, config, cardLogFields(card, "cover")...)
 	} else if card.Cover.Color != "" {
 		colorFile := filepath.Join(cardPath, "CardCoverColor.md")
 		if err := os.WriteFile(colorFile, []byte(card.Cover.Color), SecureFileMode); err != nil {
-			logger("Error writing cover color for "+card.Name+": "+err.Error(), "err", true, false, config)
+			logger("Error writing cover color for "+card.Name+": "+err.Error(), "err", true, false, config, cardLogFields(card, "cover")...)
 			return err
 		}
 	} else {
-		logger("Cover is an image, already downloaded in attachments for card "+card.Name, "info", true, true, config)
+		logger("Cover is an image, already downloaded in attachments for card "+card.Name, "info", true, true, config, cardLogFields(card, "cover")...)
 	}
 	return nil
 }
@@ -636,39 +815,42 @@ func createListCache(board *trello.Board, config Config) (map[string]*trello.Lis
 }
 
 /*
-getComprehensiveCardData fetches all card data in fewer API calls
+getComprehensiveCardData fetches all card data in fewer API calls, the raw JSON is returned too for what the client drops
 */
-func getComprehensiveCardData(cardID string, client *trello.Client) (*trello.Card, error) {
+func getComprehensiveCardData(cardID string, client *trello.Client) (*trello.Card, json.RawMessage, error) {
 	// Get card with all related data in one call
 	args := trello.Arguments{
-		"attachments":     "true",
-		"actions":         "all",
-		"actions_limit":   "1000",
-		"members":         "true",
-		"labels":          "all",
-		"checklists":      "all",
-		"checkItemStates": "true",
+		"attachments":      "true",
+		"actions":          "all",
+		"actions_limit":    "1000",
+		"members":          "true",
+		"member_fields":    "username,fullName,initials,avatarHash",
+		"labels":           "all",
+		"checklists":       "all",
+		"checkItemStates":  "true",
+		"customFieldItems": "true",
 	}
 
-	cardData, err := client.GetCard(cardID, args)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get comprehensive card data for %s: %w", cardID, err)
+	// Fetched raw so the attachment sizes the client drops can be read back out
+	var raw json.RawMessage
+	if err := client.Get("cards/"+cardID, args, &raw); err != nil {
+		return nil, nil, fmt.Errorf("failed to get comprehensive card data for %s: %w", cardID, err)
+	}
+
+	cardData := &trello.Card{}
+	if err := json.Unmarshal(raw, cardData); err != nil {
+		return nil, nil, fmt.Errorf("failed to get comprehensive card data for %s: %w", cardID, err)
 	}
+	cardData.SetClient(client)
+	fixAttachmentBytes(raw, cardData.Attachments)
 
-	return cardData, nil
+	return cardData, raw, nil
 }
 
 /*
 processCardsConcurrently manages concurrent processing of cards using a worker pool
 */
-func processCardsConcurrently(cards []*trello.Card, board *trello.Board, boardPath string, config Config, client *trello.Client) {
-	//  Cache all lists once instead of fetching per card
-	listCache, err := createListCache(board, config)
-	if err != nil {
-		logger("Error caching board lists: "+err.Error(), "err", true, false, config)
-		// Fallback to individual list calls
-		listCache = make(map[string]*trello.List)
-	}
+func processCardsConcurrently(cards []*trello.Card, board *trello.Board, boardPath string, listCache map[string]*trello.List, state *BoardState, exports exportSinks, config Config, client *trello.Client) {
 	numCards := len(cards)
 	if numCards == 0 {
 		return
@@ -702,6 +884,8 @@ func processCardsConcurrently(cards []*trello.Card, board *trello.Board, boardPa
 				config:    config,
 				client:    client,
 				listCache: listCache,
+				state:     state,
+				exports:   exports,
 				index:     i,
 				total:     numCards,
 			}
@@ -716,13 +900,22 @@ func processCardsConcurrently(cards []*trello.Card, board *trello.Board, boardPa
 
 	// Process results and handle errors
 	errorCount := 0
+	skipped := 0
 	for i := 0; i < numCards; i++ {
 		if err := <-results; err != nil {
+			if errors.Is(err, errShutdown) {
+				skipped++
+				continue
+			}
 			errorCount++
-			logger("Card processing error: "+err.Error(), "err", true, false, config)
+			logger("Card processing error: "+err.Error(), "err", true, false, config, LogFieldBoard, board.ID, LogFieldOp, "card")
 		}
 	}
 
+	if skipped > 0 {
+		logger(fmt.Sprintf("Shutting down, %d of %d cards were not processed", skipped, numCards), "warn", true, false, config, LogFieldBoard, board.ID, LogFieldOp, "shutdown")
+		errorWarnOnCompletion = true
+	}
 	if errorCount > 0 {
 		logger(fmt.Sprintf("Completed with %d errors out of %d cards", errorCount, numCards), "warn", true, false, config)
 		errorWarnOnCompletion = true
@@ -757,6 +950,30 @@ func isLinkCard(client *trello.Client, cardID string) (bool, error) {
 	return false, nil
 }
 
+/*
+fetchBoardCards
+
+	Get the cards to dump for a board
+	- If -a flag is set, include archived cards
+	- If -l flag is set, only include cards with the specified label NAME (not Label ID)
+*/
+func fetchBoardCards(config Config, board *trello.Board, client *trello.Client) ([]*trello.Card, error) {
+
+	// Handle specific label ID search, if provided (-l flag)
+	if config.ARGS.LabelID != "" {
+		logger("Searching for only cards with label ID: "+config.ARGS.LabelID, "info", true, false, config)
+		query := fmt.Sprintf("board:%s label:\"%s\" is:open", board.ID, config.ARGS.LabelID)
+		logger("Querying Trello API with: "+sanitizeURLForLogging(query), "info", true, true, config)
+		return client.SearchCards(query, trello.Defaults())
+	}
+
+	// If no specific label ID is provided, get all cards based on the -a flag
+	if config.ARGS.Archived {
+		return board.GetCards(trello.Arguments{"filter": "all"})
+	}
+	return board.GetCards(trello.Arguments{"filter": "open"})
+}
+
 /*
 dumpABoard - Process the board data and dump to specified directory structure
 
@@ -782,6 +999,10 @@ func dumpABoard(config Config, board *trello.Board, client *trello.Client) {
 
 	// Stash in master slice for reference later
 	boardTracker = append(boardTracker, board.Name+" ("+board.ID+")")
+	boardReport := reportBoardStart(board.ID, board.Name, filepath.Join(config.ARGS.StoragePath, boardPath))
+	recordBoardDir(config.ARGS.StoragePath, board, boardPath)
+	startAttachmentBudget(board.ID)
+	defer reportBoardEnd(boardReport)
 
 	/*
 		Board Level Data
@@ -789,10 +1010,10 @@ func dumpABoard(config Config, board *trello.Board, client *trello.Client) {
 	// Save board background image if exists
 	if board.Prefs.BackgroundImage != "" {
 		url := board.Prefs.BackgroundImage
-		localFilePath := filepath.Join(config.ARGS.StoragePath, boardPath, "BoardBackground-")
-		err := downLoadFile(url, localFilePath)
+		localFilePath := filepath.Join(config.ARGS.StoragePath, boardPath, "BoardBackground-"+urlFileName(url, "UnknownFile"))
+		_, err := download(downloadRequest{URL: url, Path: localFilePath})
 		if err != nil {
-			logger("Error: Unable to download background image for board "+board.Name+": "+err.Error(), "err", true, false, config)
+			logger("Error: Unable to download background image for board "+board.Name+": "+err.Error(), "err", true, false, config, LogFieldBoard, board.ID, LogFieldOp, "dump")
 		}
 	} else {
 		logger("No background image found for board"+board.Name, "info", true, true, config)
@@ -806,7 +1027,7 @@ func dumpABoard(config Config, board *trello.Board, client *trello.Client) {
 
 	labels, err := board.GetLabels(trello.Defaults())
 	if err != nil {
-		logger("Error: Unable to get label data for board ID "+board.ID+" ("+board.Name+")", "err", true, false, config)
+		logger("Error: Unable to get label data for board ID "+board.ID+" ("+board.Name+")", "err", true, false, config, LogFieldBoard, board.ID, LogFieldOp, "dump")
 	} else {
 
 		buf := prettyPrintLabels(labels, true)
@@ -815,7 +1036,7 @@ func dumpABoard(config Config, board *trello.Board, client *trello.Client) {
 		labelFileName := filepath.Join(config.ARGS.StoragePath, boardPath, "BoardLabels.md")
 		err := os.WriteFile(labelFileName, buf.Bytes(), SecureFileMode)
 		if err != nil {
-			logger("CRITICAL - Unable to write buffer to file for "+labelFileName+" Error: "+err.Error(), "err", true, true, config)
+			logger("CRITICAL - Unable to write buffer to file for "+labelFileName+" Error: "+err.Error(), "err", true, true, config, LogFieldBoard, board.ID, LogFieldOp, "dump")
 			errorWarnOnCompletion = true
 
 			return
@@ -824,29 +1045,24 @@ func dumpABoard(config Config, board *trello.Board, client *trello.Client) {
 
 	/*
 		Get Board Members
-		- Create markdown file for board members
-		- Include member name and ID
+		- Save each member's avatar once into members/
+		- Create markdown file for board members with username, role and avatar
 	*/
 	logger("Grabbing members for board: "+board.Name, "info", true, true, config)
 
-	members, err := board.GetMembers()
+	boardDir := filepath.Join(config.ARGS.StoragePath, boardPath)
+	members, err := fetchBoardMembers(board, client)
 	if err != nil {
-		logger("Error: Unable to get members for board ID "+board.ID, "err", true, true, config)
+		logger("Error: Unable to get members for board ID "+board.ID, "err", true, true, config, LogFieldBoard, board.ID, LogFieldOp, "dump")
 	} else {
-		memberBuf := getBuffer()
-		defer putBuffer(memberBuf)
+		downloadAvatars(members, boardDir, board.ID)
 
-		for _, member := range members {
-			if member == nil {
-				continue
-			}
-			memberBuf.WriteString(fmt.Sprintf("**%s** (%s)\n", member.FullName, member.ID))
-		}
 		// Write buffer content to a file
-		memberFileName := filepath.Join(config.ARGS.StoragePath, boardPath, "BoardMembers.md")
+		memberBuf := boardMembersMarkdown(members, boardDir)
+		memberFileName := filepath.Join(boardDir, "BoardMembers.md")
 		err := os.WriteFile(memberFileName, memberBuf.Bytes(), SecureFileMode)
 		if err != nil {
-			logger("CRITICAL - Unable to write buffer to file for "+memberFileName+" Error: "+err.Error(), "err", true, true, config)
+			logger("CRITICAL - Unable to write buffer to file for "+memberFileName+" Error: "+err.Error(), "err", true, true, config, LogFieldBoard, board.ID, LogFieldOp, "dump")
 			errorWarnOnCompletion = true
 
 			return
@@ -861,53 +1077,90 @@ func dumpABoard(config Config, board *trello.Board, client *trello.Client) {
 		- If -split flag is set, archived cards will be moved to an ARCHIVED directory
 	*/
 
-	// Handle specific label ID search, if provided (-l flag)
-	if config.ARGS.LabelID != "" {
-		logger("Searching for only cards with label ID: "+config.ARGS.LabelID, "info", true, false, config)
-		query := fmt.Sprintf("board:%s label:\"%s\" is:open", board.ID, config.ARGS.LabelID)
-		logger("Querying Trello API with: "+sanitizeURLForLogging(query), "info", true, true, config)
-		cards, err = client.SearchCards(query, trello.Defaults())
-		if err != nil {
-			logger("Error: Unable to get card data for board ID "+board.ID+" with label ID "+config.ARGS.LabelID, "err", true, false, config)
-			os.Exit(1)
-		}
-	} else {
-		// If no specific label ID is provided, get all cards based on the -a flag
-		if config.ARGS.Archived {
-			cards, err = board.GetCards(trello.Arguments{"filter": "all"})
-		} else {
-			cards, err = board.GetCards(trello.Arguments{"filter": "open"})
-		}
-		if err != nil {
-			logger("CRITICAL - Error: Unable to get card data for board ID "+board.ID+" Error: "+err.Error(), "err", true, false, config)
+	cards, err = fetchBoardCards(config, board, client)
+	if err != nil {
+		// Handle specific label ID search failure (-l flag), only this board fails, the daemon and webhook carry on
+		if config.ARGS.LabelID != "" {
+			logger("Error: Unable to get card data for board ID "+board.ID+" with label ID "+config.ARGS.LabelID+" Error: "+err.Error(), "err", true, false, config, LogFieldBoard, board.ID, LogFieldOp, "dump")
 			errorWarnOnCompletion = true
 
 			return
 		}
-	}
-
-	// If no cards found, return with message
-	if len(cards) == 0 {
-		logger("CRITICAL - No cards found for board "+board.Name, "warn", true, false, config)
+		logger("CRITICAL - Error: Unable to get card data for board ID "+board.ID+" Error: "+err.Error(), "err", true, false, config, LogFieldBoard, board.ID, LogFieldOp, "dump")
 		errorWarnOnCompletion = true
 
 		return
-	} else {
-		if len(cards) > 1 {
-			logger("Found "+strconv.Itoa(len(cards))+" cards to process.\nPlease wait...\n", "info", true, false, config)
+	}
+
+	reportCardsFound(board.ID, len(cards))
+
+	//  Cache all lists once instead of fetching per card
+	listCache, err := createListCache(board, config)
+	if err != nil {
+		logger("Error caching board lists: "+err.Error(), "err", true, false, config)
+		// Fallback to individual list calls
+		listCache = make(map[string]*trello.List)
+	}
+
+	// Where each card was written last run, to follow renames and moves
+	state := loadBoardState(boardDir, board.ID)
+
+	// No cards to process, but ones from the last run may all have been deleted since
+	if len(cards) == 0 {
+		if len(state.prev) == 0 {
+			logger("CRITICAL - No cards found for board "+board.Name, "warn", true, false, config, LogFieldBoard, board.ID, LogFieldOp, "dump")
+			errorWarnOnCompletion = true
 		} else {
-			logger("Found "+strconv.Itoa(len(cards))+" card to processs.\nPlease wait...\n", "info", true, false, config)
+			logger("No cards found for board "+board.Name+", checking the "+strconv.Itoa(len(state.prev))+" card(s) from the last run", "info", true, false, config, LogFieldBoard, board.ID, LogFieldOp, "dump")
 		}
+		saveBoardState(state, board, cards, listCache, client, config)
+
+		return
 	}
 
+	if len(cards) > 1 {
+		logger("Found "+strconv.Itoa(len(cards))+" cards to process.\nPlease wait...\n", "info", true, false, config)
+	} else {
+		logger("Found "+strconv.Itoa(len(cards))+" card to processs.\nPlease wait...\n", "info", true, false, config)
+	}
 	if !ListLoud && !config.ARGS.SuperQuiet {
 		fmt.Println() // blank line to make counter output cleaner
 	}
 
+	// Exports besides the Markdown tree, cards are added as they are processed
+	exports := newExportSinks(config, boardDir, board, listCache, labels, members)
+
 	// Process cards concurrently for better performance
-	processCardsConcurrently(cards, board, boardPath, config, client)
+	processCardsConcurrently(cards, board, boardPath, listCache, state, exports, config, client)
+	exports.write(board, listCache, config)
 
 	if !ListLoud && !config.ARGS.SuperQuiet {
 		fmt.Println() // New line after running counter
 	}
+
+	saveBoardState(state, board, cards, listCache, client, config)
+	exports.sqlite.markDeleted(state.Deleted)
+
+	// Board order index for each list
+	if config.ARGS.Ordered {
+		writeCardOrderIndexes(board, cards, listCache, boardPath, config)
+	}
+}
+
+/*
+saveBoardState
+
+	Retire cards and lists gone from the board since the last run, then save the state.
+	Gone ones are only looked for when the run got through the whole board.
+*/
+func saveBoardState(state *BoardState, board *trello.Board, cards []*trello.Card, listCache map[string]*trello.List, client *trello.Client, config Config) {
+
+	if runCtx.Err() == nil {
+		state.removeGone(board, cards, listCache, client, config.ARGS.OnDeleted)
+	} else {
+		state.carryForward()
+	}
+	if err := state.save(); err != nil {
+		logger("Error: Unable to save board state for "+board.Name+": "+err.Error(), "err", true, false, config, LogFieldBoard, board.ID, LogFieldOp, "state")
+	}
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/adlio/trello"
+)
+
+/*
+runVerify
+
+	Compare what Trello currently has against a previous dump on disk (verify command)
+	Returns the number of cards that are missing from disk
+*/
+func runVerify(boards []string) int {
+
+	var totalMissing int
+
+	for _, boardID := range boards {
+
+		board, ok := getBoard(boardID)
+		if !ok {
+			continue
+		}
+
+		boardConfig := config
+		boardConfig.ARGS = boardArgs(config.ARGS, boardID)
+
+		missing, checked, err := verifyBoard(boardConfig, board)
+		if err != nil {
+			logger("Error: Unable to verify board "+board.Name+": "+err.Error(), "err", true, false, config)
+			totalMissing++
+			continue
+		}
+
+		if len(missing) == 0 {
+			logger(fmt.Sprintf("Board %s (%s): all %d cards present on disk", board.Name, board.ID, checked), "info", true, false, config)
+			continue
+		}
+
+		logger(fmt.Sprintf("Board %s (%s): %d of %d cards missing on disk", board.Name, board.ID, len(missing), checked), "warn", true, false, config)
+		for _, m := range missing {
+			logger(" - "+m, "warn", true, false, config)
+		}
+		totalMissing += len(missing)
+	}
+
+	return totalMissing
+}
+
+/*
+verifyBoard
+
+	Check each card Trello reports for the board has its file or directory in the dump
+	Returns a description of each missing card, and the number of cards checked
+*/
+func verifyBoard(config Config, board *trello.Board) ([]string, int, error) {
+
+	var missing []string
+
+	boardPath := SanitizePathName(board.Name)
+	if _, err := os.Stat(filepath.Join(config.ARGS.StoragePath, boardPath)); err != nil {
+		return nil, 0, fmt.Errorf("board directory not found: %w", err)
+	}
+
+	cards, err := fetchBoardCards(config, board, client)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	listCache, err := createListCache(board, config)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, card := range cards {
+		list, exists := listCache[card.IDList]
+		if !exists {
+			missing = append(missing, card.Name+" ("+card.ID+"): list "+card.IDList+" not found in Trello")
+			continue
+		}
+		cleanListPath := listDirName(list, config)
+
+		// A regular card has a directory with a description, a link card a single markdown file
+		cardFile := filepath.Join(cardDirPath(card, config, boardPath, cleanListPath), "CardDescription.md")
+		if _, err := os.Stat(cardFile); err == nil {
+			continue
+		}
+		if _, err := os.Stat(linkCardFilePath(card, config, boardPath, cleanListPath)); err == nil {
+			continue
+		}
+
+		missing = append(missing, list.Name+" / "+card.Name+" ("+card.ID+")")
+	}
+
+	return missing, len(cards), nil
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Uploads can be large archives, so no overall timeout, only on getting a response started
+var webdavClient = &http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, ResponseHeaderTimeout: 2 * time.Minute}}
+
+// PROPFIND body asking only for what the unchanged check needs
+const webdavPropfind = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:getcontentlength/><d:getlastmodified/><d:getetag/><d:resourcetype/></d:prop></d:propfind>`
+
+/*
+webdavShipper
+
+	Mirrors a board tree (or an archive) onto a WebDAV share such as Nextcloud.
+	Collections are made with MKCOL and files sent with PUT.  A file is skipped when its
+	content and the remote ETag are the same as the last upload, or failing that when the
+	remote copy has the same size and is at least as new.  With mirror on, whatever is no
+	longer in the local tree is then deleted from the share.
+*/
+type webdavShipper struct {
+	base      *url.URL // always ends in /
+	user      string
+	cacheFile string
+	mirror    bool
+}
+
+// A file as listed by PROPFIND
+type davEntry struct {
+	size  int64
+	mtime time.Time
+	etag  string
+	dir   bool
+}
+
+// What was last uploaded to a remote path, kept in the ETag cache file
+type davCacheEntry struct {
+	SHA256 string `json:"sha256"`
+	ETag   string `json:"etag"`
+}
+
+func newWebDAVShipper(sc ShipConfig) (shipper, error) {
+
+	u, err := url.Parse(sc.URL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("webdav shipper needs a full url, ie https://cloud.example.com/remote.php/dav/files/USER/Trello")
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+
+	// One ETag cache per destination
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = os.TempDir()
+	}
+	sum := sha1.Sum([]byte(u.String()))
+
+	return webdavShipper{
+		base:      u,
+		user:      sc.User,
+		cacheFile: filepath.Join(cacheDir, "trellgo", "webdav-"+hex.EncodeToString(sum[:6])+".json"),
+		mirror:    sc.Mirror,
+	}, nil
+}
+
+func (w webdavShipper) String() string {
+	return "webdav " + w.base.Redacted()
+}
+
+func (w webdavShipper) Ship(localPath string) error {
+
+	cache := w.loadCache()
+	defer w.saveCache(cache)
+
+	var (
+		made     = make(map[string]bool)
+		listings = make(map[string]map[string]davEntry)
+		sent     int
+		skipped  int
+	)
+
+	err := walkShipFiles(localPath, func(fileName string, rel string) error {
+		dir := path.Dir(rel)
+		if err := w.mkcolAll(dir, made); err != nil {
+			return err
+		}
+
+		listing, ok := listings[dir]
+		if !ok {
+			var err error
+			if listing, err = w.propfind(dir); err != nil {
+				return err
+			}
+			listings[dir] = listing
+		}
+
+		info, err := os.Stat(fileName)
+		if err != nil {
+			return err
+		}
+		sum, err := fileSHA256(func() (io.ReadCloser, error) { return os.Open(fileName) })
+		if err != nil {
+			return err
+		}
+		localSum := hex.EncodeToString(sum)
+
+		remote, exists := listing[path.Base(rel)]
+		if exists && !remote.dir && webdavUnchanged(remote, info, localSum, cache[rel]) {
+			skipped++
+			return nil
+		}
+
+		etag, err := w.put(rel, fileName, info)
+		if err != nil {
+			return fmt.Errorf("uploading %s: %w", rel, err)
+		}
+		cache[rel] = davCacheEntry{SHA256: localSum, ETag: etag}
+		sent++
+		return nil
+	})
+
+	logger(fmt.Sprintf("WebDAV: %d file(s) uploaded, %d unchanged", sent, skipped), "info", true, true, config, LogFieldOp, "ship")
+	if err != nil || !w.mirror {
+		return err
+	}
+
+	removed, err := removeStale(localPath, func(rel string) (map[string]bool, error) {
+		entries, err := w.propfind(rel)
+		listing := make(map[string]bool, len(entries))
+		for name, e := range entries {
+			listing[name] = e.dir
+		}
+		return listing, err
+	}, func(rel string, dir bool) error {
+		if err := w.delete(rel, dir); err != nil {
+			return err
+		}
+		for p := range cache {
+			if p == rel || strings.HasPrefix(p, rel+"/") {
+				delete(cache, p)
+			}
+		}
+		return nil
+	})
+	logger(fmt.Sprintf("Mirror: %d stale file(s) or collections removed from %s", removed, w), "info", true, true, config, LogFieldOp, "ship")
+	return err
+}
+
+/*
+webdavUnchanged decides if a remote file already matches the local one
+*/
+func webdavUnchanged(remote davEntry, info os.FileInfo, localSum string, cached davCacheEntry) bool {
+
+	if remote.size != info.Size() {
+		return false
+	}
+
+	// Same content as the last upload, and nobody has changed it on the server since
+	if cached.ETag != "" && remote.etag != "" {
+		return cached.SHA256 == localSum && cached.ETag == remote.etag
+	}
+
+	// No record of uploading it, go by size and modified time
+	return !remote.mtime.Before(info.ModTime().Truncate(time.Second))
+}
+
+/*
+remoteURL turns a slash separated path under the base collection into a URL, escaping each segment
+*/
+func (w webdavShipper) remoteURL(rel string, collection bool) string {
+	var segs []string
+	for _, s := range strings.Split(rel, "/") {
+		if s != "" && s != "." {
+			segs = append(segs, url.PathEscape(s))
+		}
+	}
+
+	u := *w.base
+	u.Path = "" // RawPath carries the escaped form
+	raw := w.base.EscapedPath() + strings.Join(segs, "/")
+	if collection && !strings.HasSuffix(raw, "/") {
+		raw += "/"
+	}
+	return u.String() + raw
+}
+
+func (w webdavShipper) do(method string, target string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(runCtx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if w.user != "" {
+		req.SetBasicAuth(w.user, config.ENV.WEBDAVPASSWORD)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return webdavClient.Do(req)
+}
+
+/*
+mkcolAll makes every collection down to dir, each one only once per Ship
+*/
+func (w webdavShipper) mkcolAll(dir string, made map[string]bool) error {
+
+	if dir == "." || dir == "" || made[dir] {
+		return nil
+	}
+	if err := w.mkcolAll(path.Dir(dir), made); err != nil {
+		return err
+	}
+
+	resp, err := w.do("MKCOL", w.remoteURL(dir, true), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	// 405 Method Not Allowed is what servers answer when it already exists
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMethodNotAllowed {
+		return fmt.Errorf("MKCOL %s: %s", dir, resp.Status)
+	}
+	made[dir] = true
+
+	return nil
+}
+
+/*
+propfind lists a collection, by file name.  A missing collection is an empty listing.
+*/
+func (w webdavShipper) propfind(dir string) (map[string]davEntry, error) {
+
+	listing := make(map[string]davEntry)
+
+	target := w.remoteURL(dir, true)
+	resp, err := w.do("PROPFIND", target, strings.NewReader(webdavPropfind), map[string]string{
+		"Depth":        "1",
+		"Content-Type": "application/xml; charset=utf-8",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return listing, nil
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("PROPFIND %s: %s", dir, resp.Status)
+	}
+
+	var ms struct {
+		Responses []struct {
+			Href     string `xml:"href"`
+			Propstat []struct {
+				Status string `xml:"status"`
+				Prop   struct {
+					Length       string    `xml:"getcontentlength"`
+					LastModified string    `xml:"getlastmodified"`
+					ETag         string    `xml:"getetag"`
+					ResourceType *struct { // only present with a collection child
+						Collection *struct{} `xml:"collection"`
+					} `xml:"resourcetype"`
+				} `xml:"prop"`
+			} `xml:"propstat"`
+		} `xml:"response"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("PROPFIND %s: %w", dir, err)
+	}
+
+	// The collection itself comes back as well, by its own href
+	self := ""
+	if u, err := url.Parse(target); err == nil {
+		self = strings.TrimSuffix(u.Path, "/")
+	}
+
+	for _, r := range ms.Responses {
+		href, err := url.PathUnescape(r.Href)
+		if err != nil {
+			href = r.Href
+		}
+		if u, err := url.Parse(r.Href); err == nil && strings.TrimSuffix(u.Path, "/") == self {
+			continue
+		}
+		name := path.Base(strings.TrimSuffix(href, "/"))
+
+		var e davEntry
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			e.size, _ = strconv.ParseInt(ps.Prop.Length, 10, 64)
+			e.mtime, _ = http.ParseTime(ps.Prop.LastModified)
+			e.etag = ps.Prop.ETag
+			e.dir = ps.Prop.ResourceType != nil && ps.Prop.ResourceType.Collection != nil
+		}
+		listing[name] = e
+	}
+
+	return listing, nil
+}
+
+/*
+delete removes a file or a whole collection, one that is already gone is fine
+*/
+func (w webdavShipper) delete(rel string, collection bool) error {
+
+	resp, err := w.do(http.MethodDelete, w.remoteURL(rel, collection), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		return fmt.Errorf("DELETE: %s", resp.Status)
+	}
+	return nil
+}
+
+/*
+put uploads one file and returns the ETag the server gave it, if any
+*/
+func (w webdavShipper) put(rel string, fileName string, info os.FileInfo) (string, error) {
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	req, err := http.NewRequestWithContext(runCtx, http.MethodPut, w.remoteURL(rel, false), f)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = info.Size()
+	if w.user != "" {
+		req.SetBasicAuth(w.user, config.ENV.WEBDAVPASSWORD)
+	}
+	// Nextcloud/ownCloud keep the file's own modified time with this
+	req.Header.Set("X-OC-Mtime", strconv.FormatInt(info.ModTime().Unix(), 10))
+
+	resp, err := webdavClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("PUT: %s", resp.Status)
+	}
+
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		etag = resp.Header.Get("OC-ETag")
+	}
+	return etag, nil
+}
+
+func (w webdavShipper) loadCache() map[string]davCacheEntry {
+	cache := make(map[string]davCacheEntry)
+	if data, err := os.ReadFile(w.cacheFile); err == nil {
+		_ = json.Unmarshal(data, &cache)
+	}
+	return cache
+}
+
+func (w webdavShipper) saveCache(cache map[string]davCacheEntry) {
+	data, err := json.Marshal(cache)
+	if err == nil {
+		if err = os.MkdirAll(filepath.Dir(w.cacheFile), SecureDirMode); err == nil {
+			err = os.WriteFile(w.cacheFile, data, SecureFileMode)
+		}
+	}
+	if err != nil {
+		logger("Warning: Unable to save WebDAV ETag cache "+w.cacheFile+": "+err.Error(), "warn", true, false, config, LogFieldOp, "ship")
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+/*
+newTestWebDAVServer serves a temp directory over WebDAV, the way a Nextcloud share is written to
+*/
+func newTestWebDAVServer(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+
+	// Keep the shipper's ETag cache out of the real user cache
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	root := t.TempDir()
+	srv := httptest.NewServer(&webdav.Handler{FileSystem: webdav.Dir(root), LockSystem: webdav.NewMemLS()})
+	t.Cleanup(srv.Close)
+
+	return srv, root
+}
+
+func TestWebDAVMirror(t *testing.T) {
+	srv, root := newTestWebDAVServer(t)
+	s, err := newShipper(ShipConfig{Type: "webdav", URL: srv.URL + "/", Mirror: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testMirror(t, s, root, true)
+}
+
+func TestWebDAVPropfindSkipsSelf(t *testing.T) {
+	srv, root := newTestWebDAVServer(t)
+	writeTestFile(t, filepath.Join(root, "Board", "List", "card.md"), []byte("a card\n"), time.Now())
+	s, err := newShipper(ShipConfig{Type: "webdav", URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The collection is in its own PROPFIND answer, mirror must not take it for a child
+	listing, err := s.(webdavShipper).propfind("Board/List")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := listing["card.md"]; len(listing) != 1 || !ok {
+		t.Errorf("listing = %+v, want only card.md", listing)
+	}
+}
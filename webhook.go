@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/adlio/trello"
+	"github.com/spf13/cobra"
+)
+
+const (
+	DefaultWebhookDebounce = 5 * time.Second // Trello sends a burst of actions for one edit, wait for it to go quiet
+	MaxWebhookBody         = 1 << 20         // Largest callback body read, Trello payloads are a few KB
+)
+
+// A board the webhook receiver keeps mirrored, keyed by full board ID
+type webhookBoard struct {
+	board     *trello.Board
+	config    Config
+	boardPath string
+}
+
+// A card waiting to be re-dumped
+type webhookCard struct {
+	boardID string
+	cardID  string
+}
+
+/*
+webhookReceiver
+
+	http.Handler for Trello webhook callbacks.  Every callback is checked against the
+	X-Trello-Webhook signature, then the card it touched is re-dumped once it has been
+	quiet for the debounce time.
+*/
+type webhookReceiver struct {
+	secret      string
+	callbackURL string
+	debounce    time.Duration
+	boards      map[string]*webhookBoard
+
+	mu      sync.Mutex
+	pending map[string]*time.Timer // card ID
+	queue   chan webhookCard
+	done    chan struct{}     // closed when the worker has stopped
+	redump  func(webhookCard) // redumpCard, tests swap it for a stub
+}
+
+func newWebhookReceiver(secret string, callbackURL string, debounce time.Duration, boards map[string]*webhookBoard) *webhookReceiver {
+	wr := &webhookReceiver{
+		secret:      secret,
+		callbackURL: callbackURL,
+		debounce:    debounce,
+		boards:      boards,
+		pending:     make(map[string]*time.Timer),
+		queue:       make(chan webhookCard, 100),
+		done:        make(chan struct{}),
+	}
+	wr.redump = wr.redumpCard
+	return wr
+}
+
+func newWebhookCmd() *cobra.Command {
+	var (
+		listen      string
+		callbackURL string
+		debounce    time.Duration
+		noRegister  bool
+		unregister  bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "webhook [boardID...]",
+		Short: "Keep a dump current by re-dumping cards as Trello webhooks report changes",
+		Long: "Run an HTTP server that receives Trello webhooks for the boards and re-dumps each changed card.\n" +
+			"Start from a normal dump, then leave this running to keep it current.\n" +
+			"TRELLGO_APISECRET (the API key's secret) must be set, it is used to check every callback is really from Trello.",
+		Example: "  trellgo webhook -b c52d11s -s '/path/to/here' --listen :8080 --callback-url 'https://backup.example.com/trello'\n" +
+			"  trellgo webhook --config '/etc/trellgo.yaml' --callback-url 'https://backup.example.com/trello' --no-register",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, boards, err := buildArgs(cmd, args)
+			if err != nil {
+				return err
+			}
+			if !storageForAll(a, boards) {
+				return fmt.Errorf("no storage path provided for every board, use -s or set storage in --config")
+			}
+			if !labelArchivedOK(a, boards) {
+				return fmt.Errorf("cannot use -l with -a, use -l without -a to filter by label name")
+			}
+			u, err := url.Parse(callbackURL)
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				return fmt.Errorf("--callback-url must be the full public URL Trello will call, ie https://backup.example.com/trello")
+			}
+			startRun(a)
+			if config.ENV.TRELLOAPISECRET == "" {
+				return fmt.Errorf("TRELLGO_APISECRET is not set, it is needed to check webhook signatures")
+			}
+			return runWebhook(boards, listen, callbackURL, debounce, !noRegister, unregister)
+		},
+	}
+	addBoardFlag(cmd)
+	addDumpFlags(cmd)
+	cmd.Flags().StringVar(&listen, "listen", ":8080", "Address to listen on for webhook callbacks (and /metrics)")
+	cmd.Flags().StringVar(&callbackURL, "callback-url", "", "Public URL Trello calls, its path is where callbacks are served (REQUIRED)")
+	cmd.Flags().DurationVar(&debounce, "debounce", DefaultWebhookDebounce, "Wait for a card to be quiet this long before re-dumping it")
+	cmd.Flags().BoolVar(&noRegister, "no-register", false, "Don't register webhooks with Trello (already registered, or testing with a local fake)")
+	cmd.Flags().BoolVar(&unregister, "unregister", false, "Delete the webhooks this run registered when shutting down")
+	_ = cmd.MarkFlagRequired("callback-url")
+	return cmd
+}
+
+/*
+runWebhook
+
+	Serve webhook callbacks until SIGTERM/SIGINT.  The server has to be up before
+	registering, Trello sends a HEAD to the callback URL to check it.
+*/
+func runWebhook(boardIDs []string, listen string, callbackURL string, debounce time.Duration, register bool, unregister bool) error {
+
+	boards := make(map[string]*webhookBoard)
+	for _, id := range boardIDs {
+		board, ok := getBoard(id)
+		if !ok {
+			continue
+		}
+		boardConfig := config
+		boardConfig.ARGS = boardArgs(config.ARGS, id)
+		boardPath := SanitizePathName(board.Name)
+		dirCreate(filepath.Join(boardConfig.ARGS.StoragePath, boardPath))
+		boards[board.ID] = &webhookBoard{board: board, config: boardConfig, boardPath: boardPath}
+	}
+	if len(boards) == 0 {
+		return fmt.Errorf("none of the %d board(s) could be found", len(boardIDs))
+	}
+
+	wr := newWebhookReceiver(config.ENV.TRELLOAPISECRET, callbackURL, debounce, boards)
+	go wr.worker()
+
+	u, _ := url.Parse(callbackURL)
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, wr)
+	mux.Handle("/metrics", metricsHandler())
+	srv := &http.Server{Addr: listen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.ListenAndServe() }()
+	logger("Listening for Trello webhooks on "+listen+path, "info", true, false, config, LogFieldOp, "webhook")
+
+	var created []*trello.Webhook
+	if register {
+		created = registerWebhooks(boards, callbackURL)
+	}
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("webhook listener: %w", err)
+	case <-runCtx.Done():
+	}
+
+	logger("Shutdown requested, finishing the card in progress", "info", true, false, config, LogFieldOp, "webhook")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = srv.Shutdown(ctx)
+
+	if n := wr.stop(); n > 0 {
+		logger(fmt.Sprintf("%d changed card(s) were waiting and not re-dumped, run a dump to catch up", n), "warn", true, false, config, LogFieldOp, "webhook")
+	}
+	<-wr.done
+
+	if unregister {
+		for _, w := range created {
+			if err := w.Delete(); err != nil {
+				logger("Error: Unable to delete webhook "+w.ID+": "+err.Error(), "err", true, false, config, LogFieldBoard, w.IDModel, LogFieldOp, "webhook")
+			} else {
+				logger("Deleted webhook "+w.ID, "info", true, true, config, LogFieldBoard, w.IDModel, LogFieldOp, "webhook")
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+registerWebhooks registers a webhook for each board, reusing any this token already has for the callback URL.
+Returns the ones created by this run.
+*/
+func registerWebhooks(boards map[string]*webhookBoard, callbackURL string) []*trello.Webhook {
+
+	var created []*trello.Webhook
+
+	existing := make(map[string]bool)
+	var hooks []*trello.Webhook
+	token, err := client.GetToken(config.ENV.TRELLOAPITOK, trello.Defaults())
+	if err == nil {
+		hooks, err = token.GetWebhooks(trello.Defaults())
+	}
+	for _, w := range hooks {
+		if w.CallbackURL == callbackURL {
+			existing[w.IDModel] = true
+		}
+	}
+	if err != nil {
+		logger("Warning: Unable to list existing webhooks: "+err.Error(), "warn", true, false, config, LogFieldOp, "webhook")
+	}
+
+	for id, b := range boards {
+		if existing[id] {
+			logger("Webhook already registered for board "+b.board.Name, "info", true, false, config, LogFieldBoard, id, LogFieldOp, "webhook")
+			continue
+		}
+		w := &trello.Webhook{IDModel: id, Description: "trellgo mirror of " + b.board.Name, CallbackURL: callbackURL}
+		if err := client.CreateWebhook(w); err != nil {
+			errorWarnOnCompletion = true
+			logger("Error: Unable to register webhook for board "+b.board.Name+": "+err.Error(), "err", true, false, config, LogFieldBoard, id, LogFieldOp, "webhook")
+			continue
+		}
+		created = append(created, w)
+		logger("Registered webhook for board "+b.board.Name, "info", true, false, config, LogFieldBoard, id, LogFieldOp, "webhook")
+	}
+
+	return created
+}
+
+/*
+ServeHTTP handles Trello's HEAD check and the POSTed callbacks
+*/
+func (wr *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	switch r.Method {
+	case http.MethodHead:
+		// Trello checks the callback URL answers 200 before creating a webhook
+		w.WriteHeader(http.StatusOK)
+		return
+	case http.MethodPost:
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, MaxWebhookBody+1))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(body) > MaxWebhookBody {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if !validWebhookSignature(wr.secret, body, wr.callbackURL, r.Header.Get("X-Trello-Webhook")) {
+		logger("Warning: Rejected webhook callback with a bad signature from "+r.RemoteAddr, "warn", true, false, config, LogFieldOp, "webhook")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var req trello.BoardWebhookRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		logger("Warning: Unable to decode webhook callback: "+err.Error(), "warn", true, false, config, LogFieldOp, "webhook")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Anything else Trello sends is answered 200, or it retries and eventually disables the webhook
+	w.WriteHeader(http.StatusOK)
+
+	if req.Action == nil || req.Action.Data == nil || req.Action.Data.Card == nil || req.Action.Data.Card.ID == "" {
+		return
+	}
+
+	boardID := ""
+	if req.Action.Data.Board != nil {
+		boardID = req.Action.Data.Board.ID
+	} else if req.Model != nil {
+		boardID = req.Model.ID
+	}
+	if _, ok := wr.boards[boardID]; !ok {
+		return
+	}
+
+	cardID := req.Action.Data.Card.ID
+	if req.Action.Type == "deleteCard" {
+		logger("Card "+cardID+" was deleted in Trello, leaving the local copy", "info", true, true, config, LogFieldBoard, boardID, LogFieldCard, cardID, LogFieldOp, "webhook")
+		return
+	}
+
+	logger("Webhook "+req.Action.Type+" for card "+cardID, "debug", true, true, config, LogFieldBoard, boardID, LogFieldCard, cardID, LogFieldOp, "webhook")
+	wr.schedule(webhookCard{boardID: boardID, cardID: cardID})
+}
+
+/*
+validWebhookSignature
+
+	Trello signs callbacks with base64(HMAC-SHA1(app secret, body + callback URL))
+*/
+func validWebhookSignature(secret string, body []byte, callbackURL string, signature string) bool {
+	if signature == "" {
+		return false
+	}
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write(body)
+	mac.Write([]byte(callbackURL))
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+/*
+schedule queues a card for re-dumping once it has been quiet for the debounce time
+*/
+func (wr *webhookReceiver) schedule(c webhookCard) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	if t, ok := wr.pending[c.cardID]; ok {
+		t.Reset(wr.debounce)
+		return
+	}
+
+	wr.pending[c.cardID] = time.AfterFunc(wr.debounce, func() {
+		wr.mu.Lock()
+		delete(wr.pending, c.cardID)
+		wr.mu.Unlock()
+
+		select {
+		case wr.queue <- c:
+		case <-runCtx.Done():
+		}
+	})
+}
+
+/*
+stop cancels cards still waiting out the debounce, returns how many there were
+*/
+func (wr *webhookReceiver) stop() int {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	n := 0
+	for id, t := range wr.pending {
+		if t.Stop() {
+			n++
+		}
+		delete(wr.pending, id)
+	}
+
+	return n
+}
+
+/*
+worker re-dumps queued cards one at a time, so two callbacks never write the same card at once
+*/
+func (wr *webhookReceiver) worker() {
+	defer close(wr.done)
+
+	for {
+		select {
+		case <-runCtx.Done():
+			return
+		case c := <-wr.queue:
+			wr.redump(c)
+		}
+	}
+}
+
+/*
+redumpCard fetches a card and writes it through the same path as a full dump
+*/
+func (wr *webhookReceiver) redumpCard(c webhookCard) {
+
+	b := wr.boards[c.boardID]
+
+	card, err := client.GetCard(c.cardID, trello.Defaults())
+	if err != nil {
+		logger("Error: Unable to get card "+c.cardID+" for webhook update: "+err.Error(), "err", true, false, b.config, LogFieldBoard, c.boardID, LogFieldCard, c.cardID, LogFieldOp, "webhook")
+		return
+	}
+
+	// Same filters as a full dump of this board
+	if card.Closed && !b.config.ARGS.Archived {
+		logger("Card "+card.Name+" is archived and archived cards are not dumped, skipping", "info", true, true, b.config, cardLogFields(card, "webhook")...)
+		return
+	}
+	if b.config.ARGS.LabelID != "" && !cardHasLabelName(card, b.config.ARGS.LabelID) {
+		return
+	}
+
+	// A rename is the most common update, the state lets the card's directory follow it
+	state := loadBoardState(filepath.Join(b.config.ARGS.StoragePath, b.boardPath), c.boardID)
+	state.carryForward()
+
+	err = processSingleCard(CardProcessingJob{
+		card:      card,
+		board:     b.board,
+		boardPath: b.boardPath,
+		config:    b.config,
+		client:    client,
+		listCache: make(map[string]*trello.List), // lists can be renamed between callbacks, always look them up
+		state:     state,
+		index:     0,
+		total:     1,
+	})
+	// Without a state file the next full dump still has older directories to pick up, leave that to it
+	if !state.legacy {
+		if serr := state.save(); serr != nil {
+			logger("Error: Unable to save board state: "+serr.Error(), "err", true, false, b.config, cardLogFields(card, "state")...)
+		}
+	}
+	metricCard(c.boardID, err)
+	if err != nil {
+		logger("Error: Unable to re-dump card "+card.Name+": "+err.Error(), "err", true, false, b.config, cardLogFields(card, "webhook")...)
+		return
+	}
+
+	logger("Re-dumped card "+card.Name, "info", true, false, b.config, cardLogFields(card, "webhook")...)
+}
+
+/*
+cardHasLabelName reports whether a card carries a label with this name (the -l filter)
+*/
+func cardHasLabelName(card *trello.Card, name string) bool {
+	for _, l := range card.Labels {
+		if l.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adlio/trello"
+)
+
+const (
+	testWebhookSecret   = "s3cret"
+	testWebhookCallback = "https://backup.example.com/trello"
+	testWebhookBoard    = "5f0c1a2b3c4d5e6f7a8b9c0d"
+)
+
+/*
+newTestWebhook starts a receiver behind an httptest server, with redumpCard stubbed to
+report each card it is given on the returned channel
+*/
+func newTestWebhook(t *testing.T, debounce time.Duration) (*httptest.Server, <-chan webhookCard) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	prevCtx, prevConfig := runCtx, config
+	runCtx = ctx
+	config.ARGS.SuperQuiet = true
+
+	boards := map[string]*webhookBoard{
+		testWebhookBoard: {board: &trello.Board{ID: testWebhookBoard, Name: "Test"}},
+	}
+	wr := newWebhookReceiver(testWebhookSecret, testWebhookCallback, debounce, boards)
+	redumped := make(chan webhookCard, 10)
+	wr.redump = func(c webhookCard) { redumped <- c }
+	go wr.worker()
+
+	srv := httptest.NewServer(wr)
+	t.Cleanup(func() {
+		srv.Close()
+		wr.stop()
+		cancel()
+		<-wr.done
+		runCtx, config = prevCtx, prevConfig
+	})
+
+	return srv, redumped
+}
+
+// signWebhook is what Trello puts in X-Trello-Webhook
+func signWebhook(secret string, body string, callbackURL string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(body + callbackURL))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func cardCallback(actionType string, cardID string) string {
+	return `{"model":{"id":"` + testWebhookBoard + `"},"action":{"type":"` + actionType + `","data":{"board":{"id":"` + testWebhookBoard + `"},"card":{"id":"` + cardID + `"}}}}`
+}
+
+func postWebhook(t *testing.T, url string, body string, signature string) int {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signature != "" {
+		req.Header.Set("X-Trello-Webhook", signature)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// redumpedWithin collects the cards re-dumped until nothing more arrives for quiet
+func redumpedWithin(redumped <-chan webhookCard, quiet time.Duration) []webhookCard {
+	var cards []webhookCard
+	for {
+		select {
+		case c := <-redumped:
+			cards = append(cards, c)
+		case <-time.After(quiet):
+			return cards
+		}
+	}
+}
+
+func TestWebhookHEADVerification(t *testing.T) {
+	srv, _ := newTestWebhook(t, time.Millisecond)
+
+	resp, err := http.Head(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("HEAD = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	resp, err = http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET = %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	srv, redumped := newTestWebhook(t, 10*time.Millisecond)
+	body := cardCallback("updateCard", "card1")
+
+	tests := []struct {
+		name      string
+		signature string
+		want      int
+	}{
+		{"missing", "", http.StatusUnauthorized},
+		{"wrong secret", signWebhook("not-the-secret", body, testWebhookCallback), http.StatusUnauthorized},
+		{"wrong callback URL", signWebhook(testWebhookSecret, body, "https://elsewhere.example.com/"), http.StatusUnauthorized},
+		{"other body", signWebhook(testWebhookSecret, cardCallback("updateCard", "card2"), testWebhookCallback), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if got := postWebhook(t, srv.URL, body, tt.signature); got != tt.want {
+			t.Errorf("%s signature: got %d, want %d", tt.name, got, tt.want)
+		}
+	}
+	if cards := redumpedWithin(redumped, 100*time.Millisecond); len(cards) != 0 {
+		t.Fatalf("rejected callbacks re-dumped %v", cards)
+	}
+
+	if got := postWebhook(t, srv.URL, body, signWebhook(testWebhookSecret, body, testWebhookCallback)); got != http.StatusOK {
+		t.Fatalf("valid signature: got %d, want %d", got, http.StatusOK)
+	}
+	cards := redumpedWithin(redumped, 100*time.Millisecond)
+	if len(cards) != 1 || cards[0] != (webhookCard{boardID: testWebhookBoard, cardID: "card1"}) {
+		t.Fatalf("valid callback re-dumped %v, want card1 once", cards)
+	}
+}
+
+func TestWebhookIgnoredCallbacks(t *testing.T) {
+	srv, redumped := newTestWebhook(t, 10*time.Millisecond)
+
+	for _, body := range []string{
+		cardCallback("deleteCard", "card1"),
+		strings.ReplaceAll(cardCallback("updateCard", "card1"), testWebhookBoard, "someotherboard"),
+		`{"model":{"id":"` + testWebhookBoard + `"},"action":{"type":"updateBoard","data":{"board":{"id":"` + testWebhookBoard + `"}}}}`,
+	} {
+		// Still answered 200, or Trello retries and disables the webhook
+		if got := postWebhook(t, srv.URL, body, signWebhook(testWebhookSecret, body, testWebhookCallback)); got != http.StatusOK {
+			t.Errorf("%s: got %d, want %d", body, got, http.StatusOK)
+		}
+	}
+	if cards := redumpedWithin(redumped, 100*time.Millisecond); len(cards) != 0 {
+		t.Fatalf("ignored callbacks re-dumped %v", cards)
+	}
+}
+
+func TestWebhookDebounce(t *testing.T) {
+	srv, redumped := newTestWebhook(t, 50*time.Millisecond)
+
+	// One edit in Trello is a burst of actions on the card
+	for _, action := range []string{"updateCard", "addLabelToCard", "updateCard", "addMemberToCard", "updateCard"} {
+		body := cardCallback(action, "card1")
+		if got := postWebhook(t, srv.URL, body, signWebhook(testWebhookSecret, body, testWebhookCallback)); got != http.StatusOK {
+			t.Fatalf("%s: got %d, want %d", action, got, http.StatusOK)
+		}
+	}
+	body := cardCallback("commentCard", "card2")
+	postWebhook(t, srv.URL, body, signWebhook(testWebhookSecret, body, testWebhookCallback))
+
+	got := make(map[string]int)
+	for _, c := range redumpedWithin(redumped, 300*time.Millisecond) {
+		got[c.cardID]++
+	}
+	if got["card1"] != 1 || got["card2"] != 1 || len(got) != 2 {
+		t.Fatalf("re-dumps per card = %v, want card1 and card2 once each", got)
+	}
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/adlio/trello"
+)
+
+// Written in each board directory with --format wekan
+const WekanFile = "wekan-board.json"
+
+// Trello's own date format, which Wekan's importer reads
+const wekanTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// Board backgrounds Wekan's Trello importer turns into a board colour, image backgrounds become blue
+var wekanBackgrounds = []string{"blue", "orange", "green", "red", "purple", "pink", "lime", "sky", "grey"}
+
+// Board visibility Wekan's importer accepts, Trello's enterprise boards are imported as org
+var wekanPermissionLevels = []string{"org", "private", "public"}
+
+// Card history Wekan's importer uses, creation dates and comments
+var wekanActionTypes = []string{"createCard", "commentCard"}
+
+/*
+wekanBoard
+
+	A board in the JSON layout of Trello's own board export, which Wekan (and other
+	tools with a Trello importer) can import.  Only the fields those importers read are kept.
+*/
+type wekanBoard struct {
+	ID         string           `json:"id"`
+	Name       string           `json:"name"`
+	Desc       string           `json:"desc"`
+	Closed     bool             `json:"closed"`
+	URL        string           `json:"url"`
+	ShortURL   string           `json:"shortUrl"`
+	Prefs      wekanPrefs       `json:"prefs"`
+	Labels     []wekanLabel     `json:"labels"`
+	Lists      []wekanList      `json:"lists"`
+	Cards      []wekanCard      `json:"cards"`
+	Checklists []wekanChecklist `json:"checklists"`
+	Actions    []wekanAction    `json:"actions"`
+	Members    []wekanMember    `json:"members"`
+}
+
+type wekanPrefs struct {
+	Background      string `json:"background"`
+	PermissionLevel string `json:"permissionLevel"`
+}
+
+type wekanLabel struct {
+	ID      string `json:"id"`
+	IDBoard string `json:"idBoard"`
+	Name    string `json:"name"`
+	Color   string `json:"color"`
+}
+
+type wekanList struct {
+	ID      string  `json:"id"`
+	IDBoard string  `json:"idBoard"`
+	Name    string  `json:"name"`
+	Closed  bool    `json:"closed"`
+	Pos     float64 `json:"pos"`
+}
+
+type wekanCard struct {
+	ID               string            `json:"id"`
+	IDBoard          string            `json:"idBoard"`
+	IDList           string            `json:"idList"`
+	IDShort          int               `json:"idShort"`
+	Name             string            `json:"name"`
+	Desc             string            `json:"desc"`
+	Closed           bool              `json:"closed"`
+	Pos              float64           `json:"pos"`
+	Start            *string           `json:"start"`
+	Due              *string           `json:"due"`
+	DueComplete      bool              `json:"dueComplete"`
+	DateLastActivity string            `json:"dateLastActivity"`
+	IDLabels         []string          `json:"idLabels"`
+	IDMembers        []string          `json:"idMembers"`
+	IDChecklists     []string          `json:"idChecklists"`
+	Attachments      []wekanAttachment `json:"attachments"`
+	ShortLink        string            `json:"shortLink"`
+	URL              string            `json:"url"`
+}
+
+type wekanAttachment struct {
+	ID       string  `json:"id"`
+	Name     string  `json:"name"`
+	URL      string  `json:"url"`
+	MimeType string  `json:"mimeType"`
+	Bytes    int     `json:"bytes"`
+	IsUpload bool    `json:"isUpload"`
+	Date     string  `json:"date"`
+	IDMember string  `json:"idMember"`
+	Pos      float32 `json:"pos"`
+}
+
+type wekanChecklist struct {
+	ID         string           `json:"id"`
+	IDBoard    string           `json:"idBoard"`
+	IDCard     string           `json:"idCard"`
+	Name       string           `json:"name"`
+	Pos        float64          `json:"pos"`
+	CheckItems []wekanCheckItem `json:"checkItems"`
+}
+
+type wekanCheckItem struct {
+	ID          string  `json:"id"`
+	IDChecklist string  `json:"idChecklist"`
+	Name        string  `json:"name"`
+	State       string  `json:"state"`
+	Pos         float64 `json:"pos"`
+}
+
+type wekanAction struct {
+	ID              string          `json:"id"`
+	IDMemberCreator string          `json:"idMemberCreator"`
+	Type            string          `json:"type"`
+	Date            string          `json:"date"`
+	Data            wekanActionData `json:"data"`
+	MemberCreator   *wekanMember    `json:"memberCreator,omitempty"`
+}
+
+type wekanActionData struct {
+	Text  string    `json:"text,omitempty"`
+	Card  wekanRef  `json:"card"`
+	List  *wekanRef `json:"list,omitempty"`
+	Board wekanRef  `json:"board"`
+}
+
+type wekanRef struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+type wekanMember struct {
+	ID         string `json:"id"`
+	Username   string `json:"username"`
+	FullName   string `json:"fullName"`
+	Initials   string `json:"initials,omitempty"`
+	AvatarHash string `json:"avatarHash,omitempty"`
+}
+
+/*
+wekanExport
+
+	A board as Trello-style JSON for Wekan.  Card workers add cards as they go, and the
+	file is written once the whole board has been processed, checked first against what
+	Wekan's importer requires.
+*/
+type wekanExport struct {
+	mu    sync.Mutex
+	dir   string
+	board wekanBoard
+}
+
+/*
+newWekanExport is nil when the wekan format isn't on for the board
+*/
+func newWekanExport(config Config, boardDir string, board *trello.Board, labels []*trello.Label, members []BoardMember) *wekanExport {
+	if !hasFormat(config.ARGS, "wekan") {
+		return nil
+	}
+
+	b := wekanBoard{
+		ID:         board.ID,
+		Name:       board.Name,
+		Desc:       board.Desc,
+		Closed:     board.Closed,
+		URL:        board.URL,
+		ShortURL:   board.ShortURL,
+		Prefs:      wekanPrefs{Background: "blue", PermissionLevel: "private"},
+		Labels:     []wekanLabel{},
+		Lists:      []wekanList{},
+		Cards:      []wekanCard{},
+		Checklists: []wekanChecklist{},
+		Actions:    []wekanAction{},
+		Members:    []wekanMember{},
+	}
+	if slices.Contains(wekanBackgrounds, board.Prefs.Background) {
+		b.Prefs.Background = board.Prefs.Background
+	}
+	switch {
+	case slices.Contains(wekanPermissionLevels, board.Prefs.PermissionLevel):
+		b.Prefs.PermissionLevel = board.Prefs.PermissionLevel
+	case board.Prefs.PermissionLevel == "enterprise":
+		b.Prefs.PermissionLevel = "org"
+	}
+	for _, l := range labels {
+		b.Labels = append(b.Labels, wekanLabel{ID: l.ID, IDBoard: board.ID, Name: l.Name, Color: l.Color})
+	}
+	for _, m := range members {
+		b.Members = append(b.Members, wekanMember{ID: m.ID, Username: m.Username, FullName: m.FullName, Initials: m.Initials, AvatarHash: m.AvatarHash})
+	}
+
+	return &wekanExport{dir: boardDir, board: b}
+}
+
+/*
+addCard
+
+	Add a card, with its list the first time the list is seen.  Checklists, attachments and
+	history come from getComprehensiveCardData, a card without them still gets its entry.
+*/
+func (w *wekanExport) addCard(card *trello.Card, list *trello.List) {
+	if w == nil {
+		return
+	}
+
+	boardID := w.board.ID
+	lastActivity := card.DateLastActivity
+	if lastActivity == nil {
+		created := card.CreatedAt()
+		lastActivity = &created
+	}
+	c := wekanCard{
+		ID:               card.ID,
+		IDBoard:          boardID,
+		IDList:           card.IDList,
+		IDShort:          card.IDShort,
+		Name:             card.Name,
+		Desc:             card.Desc,
+		Closed:           card.Closed,
+		Pos:              card.Pos,
+		Start:            wekanTime(card.Start),
+		Due:              wekanTime(card.Due),
+		DueComplete:      card.DueComplete,
+		DateLastActivity: wekanDate(lastActivity),
+		IDLabels:         []string{},
+		IDMembers:        append([]string{}, card.IDMembers...),
+		IDChecklists:     []string{},
+		Attachments:      []wekanAttachment{},
+		ShortLink:        card.ShortLink,
+		URL:              card.URL,
+	}
+	for _, l := range card.Labels {
+		c.IDLabels = append(c.IDLabels, l.ID)
+	}
+	if len(card.IDLabels) > len(c.IDLabels) {
+		c.IDLabels = append([]string{}, card.IDLabels...)
+	}
+	for _, a := range card.Attachments {
+		c.Attachments = append(c.Attachments, wekanAttachment{
+			ID: a.ID, Name: a.Name, URL: a.URL, MimeType: a.MimeType, Bytes: a.Bytes, IsUpload: a.IsUpload, Date: a.Date, IDMember: a.IDMember, Pos: a.Pos,
+		})
+	}
+
+	var checklists []wekanChecklist
+	for _, cl := range card.Checklists {
+		c.IDChecklists = append(c.IDChecklists, cl.ID)
+		wc := wekanChecklist{ID: cl.ID, IDBoard: boardID, IDCard: card.ID, Name: cl.Name, Pos: cl.Pos, CheckItems: []wekanCheckItem{}}
+		for _, item := range cl.CheckItems {
+			wc.CheckItems = append(wc.CheckItems, wekanCheckItem{ID: item.ID, IDChecklist: cl.ID, Name: item.Name, State: cmp.Or(item.State, "incomplete"), Pos: item.Pos})
+		}
+		checklists = append(checklists, wc)
+	}
+
+	var (
+		actions []wekanAction
+		members []wekanMember
+	)
+	for _, a := range card.Actions {
+		if a == nil || !slices.Contains(wekanActionTypes, a.Type) {
+			continue
+		}
+		wa := wekanAction{
+			ID:              a.ID,
+			IDMemberCreator: a.IDMemberCreator,
+			Type:            a.Type,
+			Date:            wekanDate(&a.Date),
+			Data: wekanActionData{
+				Card:  wekanRef{ID: card.ID, Name: card.Name},
+				Board: wekanRef{ID: boardID, Name: w.board.Name},
+			},
+		}
+		if a.Data != nil {
+			wa.Data.Text = a.Data.Text
+			if a.Data.List != nil {
+				wa.Data.List = &wekanRef{ID: a.Data.List.ID, Name: a.Data.List.Name}
+			}
+		}
+		if a.MemberCreator != nil {
+			wa.MemberCreator = &wekanMember{ID: a.MemberCreator.ID, Username: a.MemberCreator.Username, FullName: a.MemberCreator.FullName}
+		}
+		actions = append(actions, wa)
+	}
+	// Card members who have since left the board are still members of the card
+	for _, m := range card.Members {
+		members = append(members, wekanMember{ID: m.ID, Username: m.Username, FullName: m.FullName, Initials: m.Initials, AvatarHash: m.AvatarHash})
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if list != nil && !slices.ContainsFunc(w.board.Lists, func(l wekanList) bool { return l.ID == list.ID }) {
+		w.board.Lists = append(w.board.Lists, wekanList{ID: list.ID, IDBoard: boardID, Name: list.Name, Closed: list.Closed, Pos: float64(list.Pos)})
+	}
+	for _, m := range members {
+		if !slices.ContainsFunc(w.board.Members, func(b wekanMember) bool { return b.ID == m.ID }) {
+			w.board.Members = append(w.board.Members, m)
+		}
+	}
+	w.board.Cards = append(w.board.Cards, c)
+	w.board.Checklists = append(w.board.Checklists, checklists...)
+	w.board.Actions = append(w.board.Actions, actions...)
+}
+
+/*
+write
+
+	Write the board's Wekan JSON.  Called once every card has been added, a run that
+	stopped part way leaves the file from the last complete run in place.  A board that
+	fails validation is still written, so it can be looked at, but the run is flagged.
+*/
+func (w *wekanExport) write(lists map[string]*trello.List, config Config) {
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	b := &w.board
+
+	if runCtx.Err() != nil {
+		logger("Shutting down, not rewriting the Wekan export for board "+b.Name, "warn", true, false, config, LogFieldBoard, b.ID, LogFieldOp, "wekan")
+		return
+	}
+
+	// Empty open lists carry over too
+	for _, l := range lists {
+		if !slices.ContainsFunc(b.Lists, func(wl wekanList) bool { return wl.ID == l.ID }) {
+			b.Lists = append(b.Lists, wekanList{ID: l.ID, IDBoard: b.ID, Name: l.Name, Closed: l.Closed, Pos: float64(l.Pos)})
+		}
+	}
+
+	// Board order, Trello exports history newest first
+	slices.SortStableFunc(b.Lists, func(x, y wekanList) int { return cmp.Or(cmp.Compare(x.Pos, y.Pos), cmp.Compare(x.ID, y.ID)) })
+	listPos := make(map[string]int, len(b.Lists))
+	for i, l := range b.Lists {
+		listPos[l.ID] = i
+	}
+	slices.SortStableFunc(b.Cards, func(x, y wekanCard) int {
+		return cmp.Or(cmp.Compare(listPos[x.IDList], listPos[y.IDList]), cmp.Compare(x.Pos, y.Pos), cmp.Compare(x.ID, y.ID))
+	})
+	slices.SortStableFunc(b.Checklists, func(x, y wekanChecklist) int {
+		return cmp.Or(cmp.Compare(x.IDCard, y.IDCard), cmp.Compare(x.Pos, y.Pos), cmp.Compare(x.ID, y.ID))
+	})
+	slices.SortStableFunc(b.Actions, func(x, y wekanAction) int { return cmp.Or(cmp.Compare(y.Date, x.Date), cmp.Compare(x.ID, y.ID)) })
+
+	fileName := filepath.Join(w.dir, WekanFile)
+	if errs := validateWekanBoard(b); len(errs) > 0 {
+		for _, err := range errs {
+			logger("Error: "+fileName+" won't import into Wekan: "+err.Error(), "err", true, false, config, LogFieldBoard, b.ID, LogFieldOp, "wekan")
+		}
+		errorWarnOnCompletion = true
+	}
+
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err == nil {
+		err = os.WriteFile(fileName, append(data, '\n'), SecureFileMode)
+	}
+	if err != nil {
+		logger("CRITICAL - Unable to write "+fileName+" Error: "+err.Error(), "err", true, false, config, LogFieldBoard, b.ID, LogFieldOp, "wekan")
+		errorWarnOnCompletion = true
+		return
+	}
+	logger(fmt.Sprintf("Wrote %d lists, %d cards and %d checklists to %s", len(b.Lists), len(b.Cards), len(b.Checklists), fileName), "info", true, true, config, LogFieldBoard, b.ID, LogFieldOp, "wekan")
+}
+
+/*
+validateWekanBoard
+
+	Check a board against what Wekan's Trello importer requires before it imports anything
+	(the board background, dates that parse, checklist item states), and that everything
+	a card points at (its list, labels, members, checklists) is in the file.
+*/
+func validateWekanBoard(b *wekanBoard) []error {
+
+	var errs []error
+	bad := func(format string, args ...any) { errs = append(errs, fmt.Errorf(format, args...)) }
+	date := func(s string) bool {
+		_, err := time.Parse(time.RFC3339, s)
+		return err == nil
+	}
+	ids := func(n int, id func(int) string) map[string]bool {
+		seen := make(map[string]bool, n)
+		for i := range n {
+			seen[id(i)] = true
+		}
+		return seen
+	}
+
+	if b.ID == "" {
+		bad("board has no id")
+	}
+	if b.Name == "" {
+		bad("board %s has no name", b.ID)
+	}
+	if !slices.Contains(wekanBackgrounds, b.Prefs.Background) {
+		bad("board background %q isn't one of %v", b.Prefs.Background, wekanBackgrounds)
+	}
+	if !slices.Contains(wekanPermissionLevels, b.Prefs.PermissionLevel) {
+		bad("board permissionLevel %q isn't one of %v", b.Prefs.PermissionLevel, wekanPermissionLevels)
+	}
+
+	lists := ids(len(b.Lists), func(i int) string { return b.Lists[i].ID })
+	labels := ids(len(b.Labels), func(i int) string { return b.Labels[i].ID })
+	members := ids(len(b.Members), func(i int) string { return b.Members[i].ID })
+	cards := ids(len(b.Cards), func(i int) string { return b.Cards[i].ID })
+	checklists := ids(len(b.Checklists), func(i int) string { return b.Checklists[i].ID })
+	if len(lists) != len(b.Lists) || len(labels) != len(b.Labels) || len(members) != len(b.Members) || len(cards) != len(b.Cards) || len(checklists) != len(b.Checklists) {
+		bad("duplicate ids in lists, labels, members, cards or checklists")
+	}
+	if lists[""] || labels[""] || members[""] || cards[""] || checklists[""] {
+		bad("a list, label, member, card or checklist has no id")
+	}
+
+	for _, c := range b.Cards {
+		if !lists[c.IDList] {
+			bad("card %s is in list %s, which isn't in the file", c.ID, c.IDList)
+		}
+		if !date(c.DateLastActivity) {
+			bad("card %s dateLastActivity %q isn't a date", c.ID, c.DateLastActivity)
+		}
+		for _, t := range []*string{c.Start, c.Due} {
+			if t != nil && !date(*t) {
+				bad("card %s date %q isn't a date", c.ID, *t)
+			}
+		}
+		for _, id := range c.IDLabels {
+			if !labels[id] {
+				bad("card %s has label %s, which isn't in the file", c.ID, id)
+			}
+		}
+		for _, id := range c.IDMembers {
+			if !members[id] {
+				bad("card %s has member %s, who isn't in the file", c.ID, id)
+			}
+		}
+		for _, id := range c.IDChecklists {
+			if !checklists[id] {
+				bad("card %s has checklist %s, which isn't in the file", c.ID, id)
+			}
+		}
+	}
+
+	for _, cl := range b.Checklists {
+		if !cards[cl.IDCard] {
+			bad("checklist %s is on card %s, which isn't in the file", cl.ID, cl.IDCard)
+		}
+		if cl.IDBoard != b.ID {
+			bad("checklist %s is on board %s, not %s", cl.ID, cl.IDBoard, b.ID)
+		}
+		for _, item := range cl.CheckItems {
+			if item.State != "complete" && item.State != "incomplete" {
+				bad("checklist item %s state %q isn't complete or incomplete", item.ID, item.State)
+			}
+		}
+	}
+
+	for _, a := range b.Actions {
+		if a.Type == "" || !date(a.Date) {
+			bad("action %s has no type or date", a.ID)
+		}
+		if !cards[a.Data.Card.ID] {
+			bad("action %s is on card %s, which isn't in the file", a.ID, a.Data.Card.ID)
+		}
+	}
+
+	return errs
+}
+
+// wekanTime is a time in Trello's format, nil when not set
+func wekanTime(t *time.Time) *string {
+	if t == nil || t.IsZero() {
+		return nil
+	}
+	s := t.UTC().Format(wekanTimeFormat)
+	return &s
+}
+
+// wekanDate is a time in Trello's format, empty when not set
+func wekanDate(t *time.Time) string {
+	if s := wekanTime(t); s != nil {
+		return *s
+	}
+	return ""
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adlio/trello"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+const wekanSchemaFile = "testdata/wekan.schema.json"
+
+// testTrelloID is a Trello style ID, the first 4 bytes are its creation time like Trello's
+func testTrelloID(n int) string {
+	return fmt.Sprintf("%08x%016x", 1700000000, n)
+}
+
+func compileWekanSchema(t *testing.T) *jsonschema.Schema {
+	t.Helper()
+
+	c := jsonschema.NewCompiler()
+	c.AssertFormat()
+	sch, err := c.Compile(wekanSchemaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sch
+}
+
+func validateWekanJSON(sch *jsonschema.Schema, data []byte) error {
+	inst, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	return sch.Validate(inst)
+}
+
+/*
+writeTestWekanBoard runs a board with lists, cards, labels, checklists, comments, members,
+attachments and positions through the Wekan export and returns the file it wrote
+*/
+func writeTestWekanBoard(t *testing.T) []byte {
+	t.Helper()
+
+	prevErrors := errorWarnOnCompletion
+	errorWarnOnCompletion = false
+	t.Cleanup(func() { errorWarnOnCompletion = prevErrors })
+
+	dir := t.TempDir()
+	cfg := Config{ARGS: ARGS{Formats: []string{"wekan"}, SuperQuiet: true}}
+	at := func(s string) *time.Time {
+		tm, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &tm
+	}
+
+	var (
+		boardID = testTrelloID(1)
+		todo    = &trello.List{ID: testTrelloID(10), Name: "To Do", Pos: 16384}
+		doing   = &trello.List{ID: testTrelloID(11), Name: "Doing", Pos: 32768}
+		done    = &trello.List{ID: testTrelloID(12), Name: "Done", Pos: 65536} // no cards, still carried over
+		bug     = &trello.Label{ID: testTrelloID(20), IDBoard: testTrelloID(1), Name: "Bug", Color: "red"}
+		plain   = &trello.Label{ID: testTrelloID(21), IDBoard: testTrelloID(1), Name: "No colour"}
+		ann     = BoardMember{ID: testTrelloID(30), Username: "ann", FullName: "Ann Lee", Initials: "AL"}
+		bob     = BoardMember{ID: testTrelloID(31), Username: "bob", FullName: "Bob Ray"}
+		left    = &trello.Member{ID: testTrelloID(32), Username: "cat", FullName: "Cat Left"} // left the board, still on a card
+	)
+	board := &trello.Board{ID: boardID, Name: "Roadmap", Desc: "Plans", URL: "https://trello.com/b/abc123/roadmap", ShortURL: "https://trello.com/b/abc123"}
+	board.Prefs.Background = "5f0000000000000000000000" // an image background, Wekan only takes colours
+	board.Prefs.PermissionLevel = "enterprise"
+
+	w := newWekanExport(cfg, dir, board, []*trello.Label{bug, plain}, []BoardMember{ann, bob})
+	if w == nil {
+		t.Fatal("newWekanExport is nil with the wekan format on")
+	}
+
+	withChecklist := &trello.Card{
+		ID: testTrelloID(100), IDList: doing.ID, IDShort: 7, Name: "Ship the export", Desc: "All of it, ~with~ *markdown*",
+		Pos: 2048.5, Start: at("2024-05-01T09:00:00Z"), Due: at("2024-05-10T17:30:00Z"), DateLastActivity: at("2024-05-02T08:00:00Z"),
+		Labels: []*trello.Label{bug, plain}, IDMembers: []string{ann.ID, left.ID}, Members: []*trello.Member{left},
+		ShortLink: "Xy12Ab", URL: "https://trello.com/c/Xy12Ab/7-ship-the-export",
+		Checklists: []*trello.Checklist{{
+			ID: testTrelloID(200), Name: "Steps", Pos: 16384,
+			CheckItems: []trello.CheckItem{
+				{ID: testTrelloID(201), Name: "Write it", State: "complete", Pos: 100},
+				{ID: testTrelloID(202), Name: "Test it", State: "incomplete", Pos: 200},
+				{ID: testTrelloID(203), Name: "No state from the API", Pos: 300},
+			},
+		}},
+		Attachments: []*trello.Attachment{
+			{ID: testTrelloID(300), Name: "design.pdf", URL: "https://trello.com/1/cards/x/attachments/y/download/design.pdf", MimeType: "application/pdf", Bytes: 12345, IsUpload: true, Date: "2024-05-01T10:00:00.000Z", IDMember: ann.ID, Pos: 16384},
+			{ID: testTrelloID(301), Name: "https://example.com/spec", URL: "https://example.com/spec", Date: "2024-05-01T11:00:00.000Z", IDMember: bob.ID, Pos: 32768},
+		},
+		Actions: trello.ActionCollection{
+			{ID: testTrelloID(400), IDMemberCreator: ann.ID, Type: "createCard", Date: *at("2024-04-30T12:00:00Z"), Data: &trello.ActionData{List: todo}, MemberCreator: &trello.Member{ID: ann.ID, Username: "ann", FullName: "Ann Lee"}},
+			{ID: testTrelloID(401), IDMemberCreator: bob.ID, Type: "commentCard", Date: *at("2024-05-02T08:00:00Z"), Data: &trello.ActionData{Text: "Looks good, \"ship it\""}},
+			{ID: testTrelloID(402), IDMemberCreator: bob.ID, Type: "updateCard", Date: *at("2024-05-01T08:00:00Z"), Data: &trello.ActionData{}}, // not imported
+			nil,
+		},
+	}
+	first := &trello.Card{ID: testTrelloID(101), IDList: doing.ID, Name: "First in Doing", Pos: 1024, DateLastActivity: at("2024-04-01T00:00:00Z")}
+	archived := &trello.Card{ID: testTrelloID(102), IDList: todo.ID, Name: "Old idea", Closed: true, Pos: 99999, IDLabels: []string{bug.ID}, DueComplete: true, Due: at("2023-01-01T00:00:00Z")} // no last activity, falls back to when it was created
+
+	w.addCard(withChecklist, doing)
+	w.addCard(first, doing)
+	w.addCard(archived, todo)
+	w.write(map[string]*trello.List{todo.ID: todo, doing.ID: doing, done.ID: done}, cfg)
+
+	if errorWarnOnCompletion {
+		t.Fatal("the export flagged an error writing a valid board")
+	}
+	data, err := os.ReadFile(filepath.Join(dir, WekanFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestWekanExportMatchesSchema(t *testing.T) {
+	sch := compileWekanSchema(t)
+	data := writeTestWekanBoard(t)
+
+	if err := validateWekanJSON(sch, data); err != nil {
+		t.Fatalf("%s does not match %s:\n%v\n%s", WekanFile, wekanSchemaFile, err, data)
+	}
+
+	var b wekanBoard
+	if err := json.Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	if errs := validateWekanBoard(&b); len(errs) > 0 {
+		t.Fatalf("validateWekanBoard: %v", errs)
+	}
+
+	if b.Prefs.Background != "blue" || b.Prefs.PermissionLevel != "org" {
+		t.Errorf("image background and enterprise board exported as %q and %q, want blue and org", b.Prefs.Background, b.Prefs.PermissionLevel)
+	}
+	var lists []string
+	for _, l := range b.Lists {
+		lists = append(lists, fmt.Sprintf("%s@%g", l.Name, l.Pos))
+	}
+	if got := strings.Join(lists, ", "); got != "To Do@16384, Doing@32768, Done@65536" {
+		t.Errorf("lists = %s, want board order with their positions", got)
+	}
+	var cards []string
+	for _, c := range b.Cards {
+		cards = append(cards, fmt.Sprintf("%s@%g", c.Name, c.Pos))
+	}
+	if got := strings.Join(cards, ", "); got != "Old idea@99999, First in Doing@1024, Ship the export@2048.5" {
+		t.Errorf("cards = %s, want list order then card position", got)
+	}
+
+	c := b.Cards[2]
+	if len(c.IDLabels) != 2 || len(c.IDMembers) != 2 || len(c.IDChecklists) != 1 || len(c.Attachments) != 2 {
+		t.Errorf("card %s has %d labels, %d members, %d checklists and %d attachments, want 2, 2, 1, 2", c.Name, len(c.IDLabels), len(c.IDMembers), len(c.IDChecklists), len(c.Attachments))
+	}
+	if c.Start == nil || *c.Start != "2024-05-01T09:00:00.000Z" || c.Due == nil || *c.Due != "2024-05-10T17:30:00.000Z" {
+		t.Errorf("card dates start %v due %v", c.Start, c.Due)
+	}
+	if !slices.ContainsFunc(b.Members, func(m wekanMember) bool { return m.Username == "cat" }) {
+		t.Error("card member who left the board is not in members")
+	}
+
+	var states []string
+	for _, item := range b.Checklists[0].CheckItems {
+		states = append(states, item.State)
+	}
+	if got := strings.Join(states, ","); got != "complete,incomplete,incomplete" {
+		t.Errorf("check item states = %s", got)
+	}
+
+	var actions []string
+	for _, a := range b.Actions {
+		actions = append(actions, a.Type+":"+a.Data.Text)
+	}
+	if got := strings.Join(actions, ", "); got != `commentCard:Looks good, "ship it", createCard:` {
+		t.Errorf("actions = %s, want the comment then the creation, newest first", got)
+	}
+}
+
+func TestWekanSchemaRejectsBrokenBoards(t *testing.T) {
+	sch := compileWekanSchema(t)
+	data := writeTestWekanBoard(t)
+
+	// Each breaks one of the checks in Wekan's models/trelloCreator.js
+	tests := []struct {
+		name   string
+		mutate func(b map[string]any)
+	}{
+		{"no permission level", func(b map[string]any) { delete(b["prefs"].(map[string]any), "permissionLevel") }},
+		{"permission level Wekan doesn't have", func(b map[string]any) { b["prefs"].(map[string]any)["permissionLevel"] = "enterprise" }},
+		{"background not a string", func(b map[string]any) { b["prefs"].(map[string]any)["background"] = nil }},
+		{"no lists", func(b map[string]any) { delete(b, "lists") }},
+		{"no checklists", func(b map[string]any) { delete(b, "checklists") }},
+		{"list without closed", func(b map[string]any) { delete(b["lists"].([]any)[0].(map[string]any), "closed") }},
+		{"label without name", func(b map[string]any) { delete(b["labels"].([]any)[1].(map[string]any), "name") }},
+		{"card without last activity", func(b map[string]any) { delete(decodedCard(b, 0), "dateLastActivity") }},
+		{"card without description", func(b map[string]any) { delete(decodedCard(b, 1), "desc") }},
+		{"card last activity not a date", func(b map[string]any) { decodedCard(b, 2)["dateLastActivity"] = "next tuesday" }},
+		{"card position not a number", func(b map[string]any) { decodedCard(b, 1)["pos"] = "top" }},
+		{"card label ids not strings", func(b map[string]any) { decodedCard(b, 2)["idLabels"] = []any{1, 2} }},
+		{"card members null", func(b map[string]any) { decodedCard(b, 1)["idMembers"] = nil }},
+		{"checklist without card", func(b map[string]any) { delete(b["checklists"].([]any)[0].(map[string]any), "idCard") }},
+		{"check item without state", func(b map[string]any) {
+			delete(b["checklists"].([]any)[0].(map[string]any)["checkItems"].([]any)[0].(map[string]any), "state")
+		}},
+		{"action without data", func(b map[string]any) { delete(b["actions"].([]any)[0].(map[string]any), "data") }},
+		{"action date not a date", func(b map[string]any) { b["actions"].([]any)[1].(map[string]any)["date"] = "yesterday" }},
+	}
+	for _, tt := range tests {
+		var b map[string]any
+		if err := json.Unmarshal(data, &b); err != nil {
+			t.Fatal(err)
+		}
+		tt.mutate(b)
+		broken, _ := json.Marshal(b)
+		if err := validateWekanJSON(sch, broken); err == nil {
+			t.Errorf("%s: schema accepted the broken board", tt.name)
+		}
+	}
+
+	// Wekan doesn't check members, a board without them still imports
+	var b map[string]any
+	if err := json.Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	delete(b, "members")
+	noMembers, _ := json.Marshal(b)
+	if err := validateWekanJSON(sch, noMembers); err != nil {
+		t.Errorf("schema rejected a board without members: %v", err)
+	}
+}
+
+// decodedCard is the nth card in a decoded board
+func decodedCard(b map[string]any, n int) map[string]any {
+	return b["cards"].([]any)[n].(map[string]any)
+}